@@ -28,6 +28,7 @@ var convCmd = &cobra.Command{
 		if cfg.UserNumber == "" {
 			log.Fatalf("no user phone number configured @ %s", model.ConfigPath())
 		}
+		unlockMessages(cfg)
 
 		var signalAPI model.SignalAPI = signal.NewSignal(cfg.UserNumber)
 		if mock != "" {
@@ -52,6 +53,14 @@ var convCmd = &cobra.Command{
 			}
 		}
 		if conv != nil {
+			// print the whole conversation, not just what was loaded at startup
+			for !conv.LoadedAll() {
+				if n, err := s.LoadOlder(conv); err != nil {
+					log.Fatalf("failed to load conversation: %v", err)
+				} else if n == 0 {
+					break
+				}
+			}
 			fmt.Printf("%s", conv.String())
 		} else {
 			log.Fatalf("failed to find conversation")
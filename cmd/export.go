@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/derricw/siggo/model"
+	"github.com/derricw/siggo/signal"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportAll             bool
+	exportGroup           bool
+	exportFormat          string
+	exportSince           string
+	exportUntil           string
+	exportOut             string
+	exportCopyAttachments bool
+	exportRevealSpoilers  bool
+)
+
+// exportDateFormats are the formats accepted by --since and --until
+var exportDateFormats = []string{"2006-01-02", "2006-01-02 15:04", time.RFC3339}
+
+// unsafeFilenameChars are replaced when naming export files after contacts
+var unsafeFilenameChars = regexp.MustCompile(`[^\pL\pN +._-]+`)
+
+func init() {
+	exportCmd.Flags().BoolVar(&exportAll, "all", false, "export every conversation")
+	exportCmd.Flags().BoolVarP(&exportGroup, "group", "g", false, "export a group instead of a contact")
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", model.ExportMarkdown,
+		fmt.Sprintf("export format (%s)", strings.Join(model.ExportFormats, ", ")))
+	exportCmd.Flags().StringVar(&exportSince, "since", "", "only export messages from this date on (2006-01-02)")
+	exportCmd.Flags().StringVar(&exportUntil, "until", "", "only export messages up to this date (2006-01-02)")
+	exportCmd.Flags().StringVarP(&exportOut, "out", "o", ".", "folder to write exports to")
+	exportCmd.Flags().BoolVar(&exportCopyAttachments, "copy-attachments", false,
+		"copy attachment files next to the export and link to the copies")
+	exportCmd.Flags().BoolVar(&exportRevealSpoilers, "reveal-spoilers", false,
+		"export spoilers as they are, instead of hidden")
+	rootCmd.AddCommand(exportCmd)
+}
+
+// parseExportDate parses a --since or --until date in the local timezone, returning it in ms
+func parseExportDate(s string, endOfDay bool) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	for _, format := range exportDateFormats {
+		t, err := time.ParseInLocation(format, s, time.Local)
+		if err != nil {
+			continue
+		}
+		if endOfDay && format == exportDateFormats[0] {
+			// --until 2020-01-31 includes the 31st
+			t = t.AddDate(0, 0, 1).Add(-time.Millisecond)
+		}
+		return t.UnixNano() / int64(time.Millisecond), nil
+	}
+	return 0, fmt.Errorf("can't parse date: %s (use one of %s)", s, strings.Join(exportDateFormats, ", "))
+}
+
+// isExportFormat returns true if `format` is one we can export to
+func isExportFormat(format string) bool {
+	for _, f := range model.ExportFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// exportFilename names the export of a conversation
+func exportFilename(contact *model.Contact) string {
+	name := strings.TrimSpace(unsafeFilenameChars.ReplaceAllString(contact.String(), "_"))
+	if name == "" || strings.Trim(name, "._") == "" {
+		name = unsafeFilenameChars.ReplaceAllString(contact.Number, "_")
+	}
+	return name
+}
+
+// exportFilenames names the exports of conversations with `contacts`. Contacts that have the same
+// name get their number (or group id) added, so that one export doesn't overwrite another.
+func exportFilenames(contacts []*model.Contact) map[*model.Contact]string {
+	counts := make(map[string]int)
+	for _, contact := range contacts {
+		counts[strings.ToLower(exportFilename(contact))]++
+	}
+	names := make(map[*model.Contact]string, len(contacts))
+	for _, contact := range contacts {
+		name := exportFilename(contact)
+		if counts[strings.ToLower(name)] > 1 {
+			name += "_" + unsafeFilenameChars.ReplaceAllString(contact.Number, "_")
+		}
+		names[contact] = name
+	}
+	return names
+}
+
+// copyFile copies `src` to `dst`
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// attachmentLinker returns a function that links attachments in the export of a conversation
+// named `name`, copying them into a folder next to it if --copy-attachments was given
+func attachmentLinker(name string) func(*model.Attachment) string {
+	folder := name + "-attachments"
+	return func(a *model.Attachment) string {
+		path, err := a.Path()
+		if err != nil {
+			return a.DisplayName()
+		}
+		if !exportCopyAttachments {
+			return path
+		}
+		if err := os.MkdirAll(filepath.Join(exportOut, folder), os.ModePerm); err != nil {
+			log.Fatalf("failed to create attachment folder: %v", err)
+		}
+		// prefix with the timestamp, lots of attachments have the same name
+		filename := fmt.Sprintf("%d-%s", a.Timestamp, a.DisplayName())
+		if err := copyFile(path, filepath.Join(exportOut, folder, filename)); err != nil {
+			log.Warnf("failed to copy attachment %s: %v", path, err)
+			return path
+		}
+		return filepath.ToSlash(filepath.Join(folder, filename))
+	}
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export <contact|--all>",
+	Short: "exports saved conversations to markdown, html, json or text",
+	Long: `Writes a conversation, or every conversation with --all, to a file named after the contact
+in the --out folder. Exports include sender names, timestamps, receipts and links to attachments.
+Spoilers stay hidden, unless you --reveal-spoilers.
+
+Example:
+	$ siggo export "Leeloo Dallas" --format html --since 2020-01-01
+	$ siggo export -g "Fifth Element" --format md --copy-attachments --out ~/chats
+	$ siggo export --all --format json --out ~/backup`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if exportAll && len(args) > 0 {
+			return fmt.Errorf("--all exports every conversation, don't name a contact")
+		} else if !exportAll && len(args) != 1 {
+			return fmt.Errorf("name a contact to export, or use --all")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := model.GetConfig()
+		if err != nil {
+			log.Fatalf("failed to read config @ %s", model.ConfigPath())
+		}
+		if cfg.UserNumber == "" {
+			log.Fatalf("no user phone number configured @ %s", model.ConfigPath())
+		}
+		unlockMessages(cfg)
+		since, err := parseExportDate(exportSince, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+		until, err := parseExportDate(exportUntil, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !isExportFormat(exportFormat) {
+			log.Fatalf("unknown export format: %s (use one of %s)", exportFormat,
+				strings.Join(model.ExportFormats, ", "))
+		}
+
+		var signalAPI model.SignalAPI = signal.NewSignal(cfg.UserNumber)
+		if mock != "" {
+			signalAPI = setupMock(mock, cfg)
+		}
+		s := model.NewSiggo(signalAPI, cfg)
+		if mock != "" {
+			s.Receive()
+		}
+
+		var contacts []*model.Contact
+		if exportAll {
+			if contacts, err = s.ExportContacts(); err != nil {
+				log.Fatalf("failed to list saved conversations: %v", err)
+			}
+		} else {
+			contact, err := s.Contacts().Lookup(args[0], exportGroup)
+			if err != nil {
+				log.Fatal(err)
+			}
+			contacts = []*model.Contact{contact}
+		}
+		if err := os.MkdirAll(exportOut, os.ModePerm); err != nil {
+			log.Fatalf("failed to create folder %s: %v", exportOut, err)
+		}
+
+		spoiler := s.SpoilerGlyph()
+		if exportRevealSpoilers {
+			spoiler = ""
+		}
+		names := exportFilenames(contacts)
+		exported := 0
+		for _, contact := range contacts {
+			msgs, err := s.History(contact, since, until)
+			if err != nil {
+				log.Fatalf("failed to read conversation with %s: %v", contact, err)
+			}
+			if len(msgs) == 0 {
+				if !exportAll {
+					log.Fatalf("no messages with %s to export", contact)
+				}
+				continue
+			}
+			name := names[contact]
+			export := model.NewExport(contact, msgs, cfg, spoiler, attachmentLinker(name))
+			path := filepath.Join(exportOut, fmt.Sprintf("%s.%s", name, exportFormat))
+			f, err := os.Create(path)
+			if err != nil {
+				log.Fatalf("failed to create %s: %v", path, err)
+			}
+			if err := export.Write(f, exportFormat); err != nil {
+				f.Close()
+				log.Fatalf("failed to export conversation with %s: %v", contact, err)
+			}
+			if err := f.Close(); err != nil {
+				log.Fatalf("failed to write %s: %v", path, err)
+			}
+			fmt.Printf("%s: %d messages -> %s\n", contact, len(msgs), path)
+			exported++
+		}
+		fmt.Printf("exported %d conversations\n", exported)
+	},
+}
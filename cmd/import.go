@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/derricw/siggo/model"
+	"github.com/derricw/siggo/signal"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <file...>",
+	Short: "imports messages recorded from signal-cli receive --json",
+	Long: `Reads files of signal-cli JSON output, one message per line, and merges the messages and
+receipts into your saved conversations. Messages that are already saved are not duplicated, so
+importing the same file twice is harmless. Nothing is sent and no notifications are shown.
+
+Example:
+	$ signal-cli -u +1234567890 receive --json >> ~/signal.log
+	$ siggo import ~/signal.log`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := model.GetConfig()
+		if err != nil {
+			log.Fatalf("failed to read config @ %s", model.ConfigPath())
+		}
+		if cfg.UserNumber == "" {
+			log.Fatalf("no user phone number configured @ %s", model.ConfigPath())
+		}
+		if !cfg.SaveMessages {
+			log.Fatalf("save_messages is disabled in %s, there is nowhere to import to",
+				model.ConfigPath())
+		}
+		unlockMessages(cfg)
+		// everything has to be loaded to recognize messages we already have
+		cfg.MaxConversationLength = 0
+
+		sig := signal.NewSignal(cfg.UserNumber)
+		s := model.NewSiggo(sig, cfg)
+		s.SetOffline(true)
+
+		total := &model.ImportStats{}
+		for _, path := range args {
+			f, err := os.Open(path)
+			if err != nil {
+				log.Fatalf("failed to open %s: %v", path, err)
+			}
+			stats, skipped, err := s.Import(f, sig.ProcessWire)
+			f.Close()
+			if err != nil {
+				log.Fatalf("failed to read %s: %v", path, err)
+			}
+			fmt.Printf("%s:\n", path)
+			for _, contact := range s.Contacts().SortedByName() {
+				st, ok := stats[contact]
+				if !ok {
+					continue
+				}
+				fmt.Printf("  %s: %d messages, %d receipts, %d attachments\n",
+					contact, st.Messages, st.Receipts, st.Attachments)
+				total.Messages += st.Messages
+				total.Receipts += st.Receipts
+				total.Attachments += st.Attachments
+			}
+			if skipped > 0 {
+				fmt.Printf("  skipped %d lines that couldn't be imported\n", skipped)
+			}
+			// save as we go, so one bad file doesn't cost us the others
+			s.WaitForArchive()
+			s.SaveConversations()
+		}
+		fmt.Printf("imported %d messages, %d receipts and %d attachments\n",
+			total.Messages, total.Receipts, total.Attachments)
+		s.Quit()
+	},
+}
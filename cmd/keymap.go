@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/derricw/siggo/model"
+	"github.com/derricw/siggo/widgets"
+)
+
+func init() {
+	rootCmd.AddCommand(keymapCmd)
+}
+
+var keymapCmd = &cobra.Command{
+	Use:   "keymap",
+	Short: "prints the keybindings, including any configured in the keymap",
+	Long: `Prints every action that can be bound in each mode, and the keys bound to it.
+
+Example:
+    $ siggo keymap`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := model.GetConfig()
+		if err != nil {
+			log.Fatalf("couldn't load config: %s\n", err)
+		}
+		keymap, err := widgets.NewKeymap(cfg.Keymap)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, mode := range widgets.KeymapModes {
+			fmt.Printf("%s:\n", mode)
+			actions := make([]string, 0, len(widgets.Actions[mode]))
+			for action := range widgets.Actions[mode] {
+				actions = append(actions, action)
+			}
+			sort.Strings(actions)
+			for _, action := range actions {
+				keys := keymap.Keys(mode, action)
+				if keys == "" {
+					keys = "-"
+				}
+				fmt.Printf("  %-18s %-20s %s\n", action, keys, widgets.Actions[mode][action])
+			}
+		}
+	},
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/derricw/siggo/model"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+// passphraseEnv can hold the passphrase for scripts, so that they aren't prompted for it
+const passphraseEnv = "SIGGO_PASSPHRASE"
+
+// maxPassphraseAttempts is how many wrong passphrases we accept before giving up
+const maxPassphraseAttempts = 3
+
+// readPassphrase prompts for a passphrase on the terminal without echoing it. We read from the tty
+// rather than stdin, which might be a message piped to `siggo send`.
+func readPassphrase(prompt string) (string, error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		tty = os.Stdin
+	} else {
+		defer tty.Close()
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return string(b), nil
+}
+
+// readNewPassphrase prompts for a new passphrase twice, to make sure it was typed correctly
+func readNewPassphrase() (string, error) {
+	passphrase, err := readPassphrase("new passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase can't be empty")
+	}
+	confirm, err := readPassphrase("confirm passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != passphrase {
+		return "", fmt.Errorf("passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+// unlockMessages asks for the passphrase if saved messages are encrypted. The first time, it sets
+// the passphrase instead. A rekey that was interrupted is finished (or undone) first.
+func unlockMessages(cfg *model.Config) {
+	if err := model.FinishRekey(); err != nil {
+		log.Fatalf("failed to finish interrupted rekey: %v", err)
+	}
+	if !cfg.EncryptMessages {
+		return
+	}
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		if err := cfg.Unlock(passphrase); err != nil {
+			log.Fatalf("failed to unlock message history with $%s: %v", passphraseEnv, err)
+		}
+		return
+	}
+	if !model.KeyExists() {
+		fmt.Fprintln(os.Stderr, "encrypt_messages is enabled, choose a passphrase for your message history")
+		passphrase, err := readNewPassphrase()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := cfg.Unlock(passphrase); err != nil {
+			log.Fatalf("failed to create key: %v", err)
+		}
+		return
+	}
+	for i := 0; i < maxPassphraseAttempts; i++ {
+		passphrase, err := readPassphrase("passphrase: ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = cfg.Unlock(passphrase)
+		if err == nil {
+			return
+		} else if err != model.ErrWrongPassphrase {
+			log.Fatalf("failed to unlock message history: %v", err)
+		}
+		fmt.Fprintln(os.Stderr, err)
+	}
+	log.Fatalf("failed to unlock message history")
+}
@@ -20,11 +20,11 @@ var receiveCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("failed to read config @ %s", model.ConfigPath())
 		}
-		initLogging(cfg)
-
 		if cfg.UserNumber == "" {
 			log.Fatalf("no user phone number configured @ %s", model.ConfigPath())
 		}
+		unlockMessages(cfg)
+		initLogging(cfg)
 
 		var signalAPI model.SignalAPI = signal.NewSignal(cfg.UserNumber)
 		if mock != "" {
@@ -37,6 +37,7 @@ var receiveCmd = &cobra.Command{
 			log.Printf("From: %v | Conv: \n%s", conv.Contact, conv.String())
 		}
 		s.ReceiveForever()
+		s.StartAutosave()
 		<-make(chan struct{})
 	},
 }
@@ -91,8 +91,24 @@ var rootCmd = &cobra.Command{
 			log.Fatalf("user phone number: %s is too short. did you forget a country code?", cfg.UserNumber)
 		}
 
+		unlockMessages(cfg)
 		initLogging(cfg)
 
+		keymap, err := widgets.NewKeymap(cfg.Keymap)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := cfg.Layout.Validate(); err != nil {
+			log.Fatalf("bad layout: %v", err)
+		}
+		if err := cfg.Messages.Validate(); err != nil {
+			log.Fatalf("bad message format: %v", err)
+		}
+		theme, err := model.LoadTheme(cfg.Theme)
+		if err != nil {
+			log.Fatalf("bad theme: %v", err)
+		}
+
 		var signalAPI model.SignalAPI = signal.NewSignal(cfg.UserNumber)
 		if mock != "" {
 			signalAPI = setupMock(mock, cfg)
@@ -102,9 +118,10 @@ var rootCmd = &cobra.Command{
 		s := model.NewSiggo(signalAPI, cfg)
 
 		s.ReceiveForever()
-		//tview.Styles.PrimitiveBackgroundColor = tcell.ColorDefault
+		s.RetryOutbox()
+		s.StartAutosave()
 		app := tview.NewApplication()
-		chatWindow := widgets.NewChatWindow(s, app)
+		chatWindow := widgets.NewChatWindow(s, app, keymap, theme)
 
 		// also want to make sure to handle signals
 		sigChan := make(chan os.Signal, 1)
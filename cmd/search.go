@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/derricw/siggo/model"
+	"github.com/derricw/siggo/signal"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	searchContact string
+	searchGroup   bool
+	searchSince   string
+	searchContext int
+	searchLimit   int
+)
+
+func init() {
+	searchCmd.Flags().StringVarP(&searchContact, "contact", "c", "", "only search the conversation with this contact")
+	searchCmd.Flags().BoolVarP(&searchGroup, "group", "g", false, "--contact is a group")
+	searchCmd.Flags().StringVar(&searchSince, "since", "", "only search messages from this date on (2006-01-02)")
+	searchCmd.Flags().IntVarP(&searchContext, "context", "C", 1, "number of messages to show around each match")
+	searchCmd.Flags().IntVarP(&searchLimit, "limit", "n", 50, "maximum number of matches, 0 for all")
+	rootCmd.AddCommand(searchCmd)
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "searches saved conversations",
+	Long: `Prints every message containing all the words in the query, newest first, with the
+messages around it. The last word also matches the start of longer words.
+
+Example:
+	$ siggo search multipass
+	$ siggo search "big bada" --contact "Leeloo Dallas" --since 2020-01-01 -C 3`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := model.GetConfig()
+		if err != nil {
+			log.Fatalf("failed to read config @ %s", model.ConfigPath())
+		}
+		if cfg.UserNumber == "" {
+			log.Fatalf("no user phone number configured @ %s", model.ConfigPath())
+		}
+		unlockMessages(cfg)
+		since, err := parseExportDate(searchSince, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var signalAPI model.SignalAPI = signal.NewSignal(cfg.UserNumber)
+		if mock != "" {
+			signalAPI = setupMock(mock, cfg)
+		}
+		s := model.NewSiggo(signalAPI, cfg)
+		if mock != "" {
+			s.Receive()
+		}
+
+		opts := model.SearchOptions{Since: since, Limit: searchLimit}
+		if searchContact != "" {
+			if opts.Contact, err = s.Contacts().Lookup(searchContact, searchGroup); err != nil {
+				log.Fatal(err)
+			}
+		}
+		results, err := s.Search(strings.Join(args, " "), opts)
+		if err != nil {
+			log.Fatalf("search failed: %v", err)
+		}
+		if len(results) == 0 {
+			fmt.Println("no matches")
+			return
+		}
+
+		// whole conversations, for context
+		history := make(map[*model.Contact][]*model.Message)
+		for i, result := range results {
+			msgs, ok := history[result.Contact]
+			if !ok {
+				if msgs, err = s.History(result.Contact, 0, 0); err != nil {
+					log.Fatalf("failed to read conversation with %s: %v", result.Contact, err)
+				}
+				history[result.Contact] = msgs
+			}
+			if i > 0 {
+				fmt.Println("--")
+			}
+			fmt.Printf("%s <%s>\n", result.Contact, result.Contact.Number)
+			printSearchContext(result, msgs, cfg, s.SpoilerGlyph())
+		}
+	},
+}
+
+// printSearchContext prints a search result and the messages around it, marking the match. Spoilers
+// are hidden behind `spoiler`.
+func printSearchContext(result *model.SearchResult, msgs []*model.Message, cfg *model.Config, spoiler string) {
+	hit := 0
+	for i, msg := range msgs {
+		if msg.Key() == result.Message.Key() {
+			hit = i
+			break
+		}
+	}
+	start, end := hit-searchContext, hit+searchContext+1
+	if start < 0 {
+		start = 0
+	}
+	if end > len(msgs) {
+		end = len(msgs)
+	}
+	window := msgs[start:end]
+	if len(msgs) == 0 {
+		window = []*model.Message{result.Message}
+		start, hit = 0, 0
+	}
+	export := model.NewExport(result.Contact, window, cfg, spoiler, func(a *model.Attachment) string {
+		return a.DisplayName()
+	})
+	for i, m := range export.Messages {
+		marker := " "
+		if start+i == hit {
+			marker = ">"
+		}
+		fmt.Printf("%s %s|%s| %s: %s\n", marker, m.FormattedTime(), m.Status(), m.Sender, m.Content)
+	}
+}
@@ -1,21 +1,61 @@
 package cmd
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
 	"github.com/derricw/siggo/model"
 	"github.com/derricw/siggo/signal"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+var (
+	sendAttachments []string
+	sendToGroup     bool
+	sendNoDaemon    bool
+)
+
+var phoneNumberRegex = regexp.MustCompile(`^\+?[0-9]+$`)
+
 func init() {
+	sendCmd.Flags().StringArrayVarP(&sendAttachments, "attach", "a", nil, "attach a file (can be repeated)")
+	sendCmd.Flags().BoolVarP(&sendToGroup, "group", "g", false, "send to a group instead of a contact")
+	sendCmd.Flags().BoolVar(&sendNoDaemon, "no-daemon", false, "don't send through a running signal-cli daemon")
 	rootCmd.AddCommand(sendCmd)
 }
 
+// readMessage returns the message to send, reading it from stdin if it is "-"
+func readMessage(arg string) (string, error) {
+	if arg != "-" {
+		return arg, nil
+	}
+	b, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read message from stdin: %v", err)
+	}
+	return strings.TrimRight(string(b), "\n"), nil
+}
+
 var sendCmd = &cobra.Command{
-	Use:   "send",
+	Use:   "send <contact> [message]",
 	Short: "send a single message",
-	Long:  `$siggo send +1234567890 "hello good sir"`,
-	Args:  cobra.ExactArgs(2),
+	Long: `Sends a message to a contact or group, by number, name or alias. Use "-" to read the
+message from stdin. If a signal-cli daemon is running (for example, siggo is open) the message is
+sent through it, otherwise signal-cli is invoked directly.
+
+If save_messages is enabled, the message is added to the saved conversation.
+
+Example:
+	$ siggo send +1234567890 "hello good sir"
+	$ siggo send "Ruby Rhod" "hello good sir" -a ~/cat.jpg -a ~/dog.jpg
+	$ siggo send -g "Fifth Element" "we're going to be late"
+	$ fortune | siggo send "Leeloo Dallas" -`,
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		cfg, err := model.GetConfig()
 		if err != nil {
@@ -24,11 +64,55 @@ var sendCmd = &cobra.Command{
 		if cfg.UserNumber == "" {
 			log.Fatalf("no user phone number configured @ %s", model.ConfigPath())
 		}
-		sig := signal.NewSignal(cfg.UserNumber)
-		ID, err := sig.Send(args[0], args[1])
+		unlockMessages(cfg)
+
+		msg := ""
+		if len(args) == 2 {
+			if msg, err = readMessage(args[1]); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if msg == "" && len(sendAttachments) == 0 {
+			log.Fatalf("nothing to send, need a message or an attachment")
+		}
+
+		var signalAPI model.SignalAPI = signal.NewSignal(cfg.UserNumber)
+		if mock != "" {
+			signalAPI = setupMock(mock, cfg)
+		}
+		s := model.NewSiggo(signalAPI, cfg)
+		s.SetDaemon(!sendNoDaemon && signal.DaemonRunning())
+
+		contact, err := s.Contacts().Lookup(args[0], sendToGroup)
 		if err != nil {
-			log.Fatal(err)
+			if sendToGroup || !phoneNumberRegex.MatchString(args[0]) {
+				log.Fatal(err)
+			}
+			// not in our contacts but we can still send to a number
+			number := args[0]
+			if !strings.HasPrefix(number, "+") {
+				number = fmt.Sprintf("+%s", number)
+			}
+			contact = &model.Contact{Number: number}
+		}
+
+		conv := s.Conversation(contact)
+		for _, path := range sendAttachments {
+			// absolute paths so that the saved conversation can find them later
+			if abs, err := filepath.Abs(path); err == nil {
+				path = abs
+			}
+			if err := conv.AddAttachment(path); err != nil {
+				log.Fatalf("failed to attach: %s - %v", path, err)
+			}
+		}
+		if err := s.Send(msg, contact); err != nil {
+			log.Fatalf("failed to send message to %s, it will be retried from the outbox: %v",
+				contact, err)
+		}
+		if cfg.SaveMessages {
+			s.SaveConversations()
 		}
-		log.Infof("message sent with ID: %d", ID)
+		log.Infof("message sent to %s with ID: %d", contact, conv.LastMessage().Timestamp)
 	},
 }
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/derricw/siggo/model"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	storeCmd.AddCommand(rekeyCmd)
+	rootCmd.AddCommand(storeCmd)
+	rootCmd.AddCommand(migrateStoreCmd)
+}
+
+var migrateStoreCmd = &cobra.Command{
+	Use:   "migrate-store <from> <to>",
+	Short: "copies saved conversations from one message store to another",
+	Long: `Message stores are "jsonl" (the default) or "sqlite". Conversations already in the
+destination store are overwritten. Set message_store in your config to start using the new store.
+	Example:
+	$ siggo migrate-store jsonl sqlite`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if args[0] == args[1] {
+			log.Fatalf("nothing to do, both stores are %s", args[0])
+		}
+		cfg, err := model.GetConfig()
+		if err != nil {
+			log.Fatalf("failed to read config @ %s", model.ConfigPath())
+		}
+		unlockMessages(cfg)
+		from, err := model.OpenStore(args[0], cfg.Cipher())
+		if err != nil {
+			log.Fatalf("failed to open store %s: %v", args[0], err)
+		}
+		defer from.Close()
+		to, err := model.OpenStore(args[1], cfg.Cipher())
+		if err != nil {
+			log.Fatalf("failed to open store %s: %v", args[1], err)
+		}
+		defer to.Close()
+
+		convs, err := from.Conversations()
+		if err != nil {
+			log.Fatalf("failed to list conversations in %s: %v", args[0], err)
+		}
+		total := 0
+		for _, conv := range convs {
+			n, err := model.CopyConversation(from, to, conv)
+			if err != nil {
+				log.Fatalf("failed to copy conversation %s: %v", conv, err)
+			}
+			fmt.Printf("%s: %d messages\n", conv, n)
+			total += n
+		}
+		fmt.Printf("copied %d messages in %d conversations from %s to %s\n",
+			total, len(convs), args[0], args[1])
+
+		if cfg.MessageStore != args[1] {
+			fmt.Printf("set message_store: %s in %s to use it\n", args[1], model.ConfigPath())
+		}
+	},
+}
+
+var storeCmd = &cobra.Command{
+	Use:   "store",
+	Short: "manages saved messages",
+}
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "encrypts saved messages with a new passphrase",
+	Long: `Asks for the current passphrase (if there is one) and a new one, then re-encrypts every
+saved conversation and the outbox with the new passphrase. Messages that were saved before
+encryption was enabled are encrypted too. Set encrypt_messages: true in your config to keep
+encrypting new messages.
+	Example:
+	$ siggo store rekey`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := model.GetConfig()
+		if err != nil {
+			log.Fatalf("failed to read config @ %s", model.ConfigPath())
+		}
+		// the current key is the new one if an earlier rekey got far enough
+		if err := model.FinishRekey(); err != nil {
+			log.Fatalf("failed to finish interrupted rekey: %v", err)
+		}
+		var oldCipher *model.Cipher
+		if model.KeyExists() {
+			passphrase, err := readPassphrase("current passphrase: ")
+			if err != nil {
+				log.Fatal(err)
+			}
+			if oldCipher, err = model.LoadCipher(model.KeyPath(), passphrase); err != nil {
+				log.Fatalf("failed to unlock message history: %v", err)
+			}
+		}
+		passphrase, err := readNewPassphrase()
+		if err != nil {
+			log.Fatal(err)
+		}
+		newCipher, err := model.NewCipher(passphrase)
+		if err != nil {
+			log.Fatalf("failed to create key: %v", err)
+		}
+
+		convs, total, err := model.Rekey(cfg.MessageStore, oldCipher, newCipher)
+		if err != nil {
+			log.Fatalf("failed to rekey message history: %v", err)
+		}
+		fmt.Printf("encrypted %d messages in %d conversations\n", total, convs)
+		if !cfg.EncryptMessages {
+			fmt.Printf("set encrypt_messages: true in %s to keep encrypting new messages\n",
+				model.ConfigPath())
+		}
+	},
+}
@@ -0,0 +1,231 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AttachmentArchive keeps siggo's own copy of every attachment, since signal-cli may delete them
+// and the files we send can be moved. Files are named by the sha256 of their content, so the same
+// file sent twice is only kept once. The original filename stays in the Attachment for display.
+type AttachmentArchive struct {
+	folder string
+	// maxSize (bytes) and maxAge limit what is kept when pruning, 0 means no limit
+	maxSize int64
+	maxAge  time.Duration
+}
+
+// Add copies an attachment into the archive and points the attachment at the copy. Attachments
+// that are already archived are left alone.
+func (aa *AttachmentArchive) Add(a *Attachment) error {
+	if a.Archived != "" {
+		return nil
+	}
+	src, err := a.sourcePath()
+	if err != nil {
+		return err
+	}
+	rel, err := aa.copy(src, a.extension())
+	if err != nil {
+		return err
+	}
+	a.Archived = rel
+	return nil
+}
+
+// copy copies the file @ `src` into the archive, and returns the path of the copy relative to the
+// archive folder
+func (aa *AttachmentArchive) copy(src, ext string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(aa.folder, os.ModePerm); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(aa.folder, ".incoming")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), in); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	rel := filepath.Join(sum[:2], sum+ext)
+	dst := filepath.Join(aa.folder, rel)
+	if _, err := os.Stat(dst); err == nil {
+		// we already have it, just reset its age
+		now := time.Now()
+		os.Chtimes(dst, now, now)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmp.Name(), dst); err != nil {
+			return "", err
+		}
+	}
+	return rel, nil
+}
+
+// archivedFile is a file in the archive, for pruning
+type archivedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Prune deletes archived attachments older than the maximum age, and then the oldest ones until
+// the archive fits in the maximum size. Returns the number of files deleted.
+func (aa *AttachmentArchive) Prune() (int, error) {
+	if aa.maxSize <= 0 && aa.maxAge <= 0 {
+		return 0, nil
+	}
+	files := make([]archivedFile, 0)
+	total := int64(0)
+	err := filepath.Walk(aa.folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		files = append(files, archivedFile{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	deleted := 0
+	for _, f := range files {
+		tooOld := aa.maxAge > 0 && time.Since(f.modTime) > aa.maxAge
+		tooBig := aa.maxSize > 0 && total > aa.maxSize
+		if !tooOld && !tooBig {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			return deleted, err
+		}
+		total -= f.size
+		deleted++
+	}
+	return deleted, nil
+}
+
+// NewAttachmentArchive creates an archive in `folder` that is pruned to `maxSize` bytes and
+// `maxAge`
+func NewAttachmentArchive(folder string, maxSize int64, maxAge time.Duration) *AttachmentArchive {
+	return &AttachmentArchive{
+		folder:  folder,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}
+}
+
+// archiveAttachments copies the attachments of a message in `conv` into the archive, if archiving
+// is enabled. Copying and hashing a big file takes a while, so it happens in the background rather
+// than while we hold convMu, and the archived paths are filled in on Queue once it is done. The
+// caller holds convMu.
+func (s *Siggo) archiveAttachments(conv *Conversation, message *Message) {
+	if s.archive == nil {
+		return
+	}
+	type archiveJob struct {
+		attachment *Attachment
+		src, ext   string
+		what       string
+	}
+	jobs := make([]archiveJob, 0)
+	add := func(a *Attachment, what string) {
+		if a.Archived != "" {
+			return
+		}
+		src, err := a.sourcePath()
+		if err != nil {
+			log.Errorf("failed to archive %s: %v", what, err)
+			return
+		}
+		jobs = append(jobs, archiveJob{a, src, a.extension(), what})
+	}
+	for _, a := range message.Attachments {
+		add(a, fmt.Sprintf("attachment %s", a.Filename))
+	}
+	for _, p := range message.LinkPreviews() {
+		if p.Image != nil {
+			add(p.Image, fmt.Sprintf("preview image of %s", p.URL))
+		}
+	}
+	if len(jobs) == 0 {
+		return
+	}
+	s.archiving.Add(1)
+	go func() {
+		defer s.archiving.Done()
+		archived := make(map[*Attachment]string)
+		for _, job := range jobs {
+			rel, err := s.archive.copy(job.src, job.ext)
+			if err != nil {
+				log.Errorf("failed to archive %s: %v", job.what, err)
+				continue
+			}
+			archived[job.attachment] = rel
+		}
+		if len(archived) == 0 {
+			return
+		}
+		s.Queue(func() {
+			s.convMu.Lock()
+			defer s.convMu.Unlock()
+			for a, rel := range archived {
+				a.Archived = rel
+			}
+			// the message could have been deleted or unloaded while we were copying
+			if key := message.Key(); conv.Messages[key] == message {
+				conv.markChanged(key)
+				s.journal(conv)
+			}
+		})
+	}()
+}
+
+// WaitForArchive waits until the attachments that are being archived in the background are done
+// and their messages updated. It can't be called from Queue, which they are updated on.
+func (s *Siggo) WaitForArchive() {
+	s.archiving.Wait()
+}
+
+// extension picks a file extension for an attachment so that the archived copy opens with the
+// right program
+func (a *Attachment) extension() string {
+	for _, name := range []string{a.Filename, a.ID} {
+		if ext := filepath.Ext(name); ext != "" {
+			return ext
+		}
+	}
+	if exts, err := mime.ExtensionsByType(a.ContentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
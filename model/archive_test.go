@@ -0,0 +1,86 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttachmentArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{"cat.jpg": "meow", "copy.jpg": "meow", "dog.png": "woof!"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive := NewAttachmentArchive(filepath.Join(dir, "archive"), 5, 0)
+
+	cat := &Attachment{Filename: filepath.Join(dir, "cat.jpg")}
+	catCopy := &Attachment{Filename: filepath.Join(dir, "copy.jpg")}
+	dog := &Attachment{Filename: filepath.Join(dir, "dog.png")}
+	for _, a := range []*Attachment{cat, catCopy, dog} {
+		if err := archive.Add(a); err != nil {
+			t.Fatalf("failed to archive %s: %v", a.Filename, err)
+		}
+	}
+	assert.Equal(t, cat.Archived, catCopy.Archived, "same content is only kept once")
+	assert.Equal(t, ".jpg", filepath.Ext(cat.Archived))
+	assert.NotEqual(t, cat.Archived, dog.Archived)
+
+	// 9 bytes archived, only room for 5, so whichever file is older goes
+	n, err := archive.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestArchiveAttachments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cat.jpg")
+	if err := ioutil.WriteFile(path, []byte("meow"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSiggo()
+	s.store = NewJSONLStore(filepath.Join(dir, "conversations"), nil)
+	s.archive = NewAttachmentArchive(filepath.Join(dir, "archive"), 0, 0)
+	queued := 0
+	s.Queue = func(f func()) {
+		queued++
+		f()
+	}
+	contact := &Contact{Number: "+15550000001"}
+	s.contacts[contact.Number] = contact
+
+	s.convMu.Lock()
+	conv := s.newConversation(contact)
+	msg := &Message{Content: "multipass", Timestamp: 1, Author: contact.Number, FromContact: contact}
+	msg.AddAttachments([]string{path})
+	s.archiveAttachments(conv, msg)
+	conv.AddMessage(msg)
+	s.journal(conv)
+	// the copy is made without holding up the conversations
+	assert.Empty(t, msg.Attachments[0].Archived)
+	s.convMu.Unlock()
+
+	s.WaitForArchive()
+	assert.Equal(t, 1, queued)
+	archived := msg.Attachments[0].Archived
+	assert.Equal(t, ".jpg", filepath.Ext(archived))
+	_, err = os.Stat(filepath.Join(dir, "archive", archived))
+	assert.NoError(t, err)
+	// and the message is saved again with it
+	saved, err := s.store.Range(contact.Number, 0, 1)
+	if assert.NoError(t, err) && assert.Len(t, saved, 1) {
+		assert.Equal(t, archived, saved[0].Attachments[0].Archived)
+	}
+}
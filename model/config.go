@@ -46,6 +46,26 @@ func ConversationFolder() string {
 	return filepath.Join(FindDataFolder(), "conversations")
 }
 
+// SQLiteStorePath returns the path of the database used by the sqlite message store
+func SQLiteStorePath() string {
+	return filepath.Join(FindDataFolder(), "siggo.db")
+}
+
+// OutboxPath returns the path where unsent messages are saved
+func OutboxPath() string {
+	return filepath.Join(FindDataFolder(), "outbox")
+}
+
+// AttachmentFolder returns the folder where siggo keeps its copies of attachments
+func AttachmentFolder() string {
+	return filepath.Join(FindDataFolder(), "attachments")
+}
+
+// CommandHistoryPath returns the path where commands entered on the command line are saved
+func CommandHistoryPath() string {
+	return filepath.Join(FindDataFolder(), "command_history")
+}
+
 // LogPath returns the log file path
 func LogPath() string {
 	return filepath.Join(FindDataFolder(), "siggo.log")
@@ -53,9 +73,18 @@ func LogPath() string {
 
 func DefaultConfig() *Config {
 	return &Config{
-		UserName:       "self",
-		ContactColors:  make(map[string]string),
-		ContactAliases: make(map[string]string),
+		UserName:              "self",
+		MessageStore:          JSONLStoreName,
+		MaxConversationLength: 500,
+		AutosaveInterval:      30,
+		AttachmentMaxSize:     1024,
+		ContactColors:         make(map[string]string),
+		ContactAliases:        make(map[string]string),
+		Layout:                DefaultLayout(),
+		Messages:              DefaultMessageFormat(),
+		TextFormatting:        true,
+		Theme:                 &Theme{Base: DefaultThemeName},
+		Keymap:                make(map[string]map[string]string),
 	}
 }
 
@@ -66,21 +95,157 @@ type Config struct {
 	// SaveMessages enables message saving. You will still load any (previously) saved messages
 	// at startup.
 	SaveMessages bool `yaml:"save_messages"`
+	// MessageStore is where saved messages are kept, either "jsonl" (one file per conversation) or
+	// "sqlite" (a single database). Use `siggo migrate-store` to move messages between them.
+	MessageStore string `yaml:"message_store"`
+	// EncryptMessages encrypts saved messages and the outbox with a key derived from a passphrase,
+	// which is asked for at startup. Use `siggo store rekey` to encrypt existing messages or change
+	// the passphrase.
+	EncryptMessages bool `yaml:"encrypt_messages"`
+	// AutosaveInterval is how often (in seconds) changes to saved messages, like receipts, are
+	// written to disk. New messages are saved as soon as they arrive. 0 disables autosave.
+	AutosaveInterval int `yaml:"autosave_interval"`
+	// ArchiveAttachments keeps a copy of every attachment sent or received in the siggo data
+	// folder, since signal-cli may delete them. It only applies when messages are saved, and not
+	// when they are encrypted, since the copies aren't. The archive is pruned at startup to
+	// AttachmentMaxSize (MB) and AttachmentMaxAge (days), 0 means no limit.
+	ArchiveAttachments bool `yaml:"archive_attachments"`
+	AttachmentMaxSize  int  `yaml:"attachment_max_size"`
+	AttachmentMaxAge   int  `yaml:"attachment_max_age"`
 	// Attempt to send desktop notifications
 	DesktopNotifications            bool `yaml:"desktop_notifications"`
 	DesktopNotificationsShowMessage bool `yaml:"desktop_notifications_show_message"`
 	DesktopNotificationsShowAvatar  bool `yaml:"desktop_notifications_show_avatar"`
 	// Terminal bell
 	TerminalBellNotifications bool `yaml:"terminal_bell_notifications"`
-	// doesn't do anything yet
+	// MaxConversationLength is how many saved messages of each conversation are loaded at
+	// startup. Older messages are loaded as you scroll up. 0 loads everything.
 	MaxConversationLength int               `yaml:"max_coversation_length"`
 	HidePanelTitles       bool              `yaml:"hide_panel_titles"`
 	HidePhoneNumbers      bool              `yaml:"hide_phone_numbers"`
 	ContactColors         map[string]string `yaml:"contact_colors"`
 	ContactAliases        map[string]string `yaml:"contact_aliases"`
+	// Layout is where the panels go and how big they are
+	Layout Layout `yaml:"layout"`
+	// TextFormatting turns *bold*, _italic_, ~strikethrough~, `monospace` and ||spoiler|| in
+	// messages we send into text styles
+	TextFormatting bool `yaml:"text_formatting"`
+	// Messages is how messages are shown: timestamps, time zone, wrapping and so on
+	Messages MessageFormat `yaml:"messages"`
+	// Theme is how siggo looks: a built-in theme or theme file as `base`, and anything to change
+	// about it. See the config README for everything a theme has.
+	Theme *Theme `yaml:"theme"`
+	// Keymap changes keybindings, per mode, from keys in vim notation like "<C-n>" or "gg" to an
+	// action. An empty action unbinds the key. See `siggo keymap` for the bindings and actions.
+	Keymap map[string]map[string]string `yaml:"keymap"`
 
 	// No rotation provided, use at your own risk!
 	LogFilePath string `yaml:"log_file"`
+
+	// cipher is set once the message history has been unlocked
+	cipher *Cipher
+	// edits are the settings changed while running, which SaveChanges writes
+	edits []configEdit
+}
+
+// ContactsLeft and ContactsRight are where the contact list can go
+const (
+	ContactsLeft  = "left"
+	ContactsRight = "right"
+)
+
+// How the contact list can be ordered: by the last message in the conversation, most recent
+// first, by name, by number, or in the order signal-cli lists them
+const (
+	SortByActivity = "activity"
+	SortByName     = "name"
+	SortByNumber   = "number"
+	SortByIndex    = "index"
+)
+
+// Layout is where the panels of the UI go and how big they are
+type Layout struct {
+	// ContactsWidth is the width of the contact list in columns, 0 fits it to the longest name
+	ContactsWidth int `yaml:"contacts_width"`
+	// ContactsPosition is which side the contact list is on, "left" or "right"
+	ContactsPosition string `yaml:"contacts_position"`
+	HideContacts     bool   `yaml:"hide_contacts"`
+	// ContactSort is how the contact list is ordered, and ContactPreviews shows the last message
+	// of each conversation under its name
+	ContactSort     string `yaml:"contact_sort"`
+	ContactPreviews bool   `yaml:"contact_previews"`
+	// SendHeight is the height of the send panel in rows, including its border
+	SendHeight int `yaml:"send_height"`
+	// Compact mode drops the panel borders and the contact list to fit narrow terminals. It is
+	// turned on by itself when the terminal is narrower than CompactWidth columns, 0 never does.
+	Compact      bool `yaml:"compact"`
+	CompactWidth int  `yaml:"compact_width"`
+}
+
+// DefaultLayout returns the default layout
+func DefaultLayout() Layout {
+	return Layout{
+		ContactsWidth:    20,
+		ContactsPosition: ContactsLeft,
+		ContactSort:      SortByActivity,
+		SendHeight:       3,
+	}
+}
+
+// Validate checks that the layout makes sense
+func (l *Layout) Validate() error {
+	if l.ContactsPosition != ContactsLeft && l.ContactsPosition != ContactsRight {
+		return fmt.Errorf("contacts_position must be %s or %s, not %q", ContactsLeft, ContactsRight,
+			l.ContactsPosition)
+	}
+	switch l.ContactSort {
+	case SortByActivity, SortByName, SortByNumber, SortByIndex:
+	default:
+		return fmt.Errorf("contact_sort must be %s, %s, %s or %s, not %q", SortByActivity, SortByName,
+			SortByNumber, SortByIndex, l.ContactSort)
+	}
+	if l.ContactsWidth < 0 || l.CompactWidth < 0 {
+		return fmt.Errorf("widths can't be negative")
+	}
+	if l.SendHeight < 1 {
+		return fmt.Errorf("send_height must be at least 1")
+	}
+	return nil
+}
+
+// Unlock derives the message encryption key from `passphrase`. If there is no key file yet, one
+// is created, so the first passphrase used becomes the passphrase.
+func (c *Config) Unlock(passphrase string) error {
+	if !KeyExists() {
+		cipher, err := NewCipher(passphrase)
+		if err != nil {
+			return err
+		}
+		if err := cipher.SaveKey(KeyPath()); err != nil {
+			return err
+		}
+		c.cipher = cipher
+		return nil
+	}
+	cipher, err := LoadCipher(KeyPath(), passphrase)
+	if err != nil {
+		return err
+	}
+	c.cipher = cipher
+	return nil
+}
+
+// Cipher returns the cipher used to encrypt saved messages, or nil if they are not encrypted
+func (c *Config) Cipher() *Cipher {
+	if !c.EncryptMessages {
+		return nil
+	}
+	return c.cipher
+}
+
+// Locked returns true if messages should be encrypted but we don't have the passphrase
+func (c *Config) Locked() bool {
+	return c.EncryptMessages && c.cipher == nil
 }
 
 // SaveAs writes the config to `path`
@@ -89,13 +254,10 @@ func (c *Config) SaveAs(path string) error {
 	if err != nil {
 		return err
 	}
-	out, err := os.Create(path)
-	if err != nil {
+	return writeFileAtomic(path, 0644, func(f *os.File) error {
+		_, err := f.Write(d)
 		return err
-	}
-	defer out.Close()
-	_, err = out.Write(d)
-	return err
+	})
 }
 
 // Save saves the config to the default location
@@ -0,0 +1,187 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configEdit is a setting changed while siggo is running, by the path of its key in the config
+// file. A nil value removes the key.
+type configEdit struct {
+	path  []string
+	value interface{}
+}
+
+// edit notes a change to the setting at `path`, to be written by SaveChanges
+func (c *Config) edit(value interface{}, path ...string) {
+	c.edits = append(c.edits, configEdit{path, value})
+}
+
+// SaveChanges writes the settings changed while running to the config file @ `path`. Only their
+// keys are touched, everything else in the file (comments too) stays as it is, and nothing siggo
+// worked out for itself, like the log file, is written.
+func (c *Config) SaveChanges(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := make([]string, 0)
+	if text := strings.TrimRight(string(b), "\n"); text != "" {
+		lines = strings.Split(text, "\n")
+	}
+	for _, e := range c.edits {
+		if lines, err = editYAML(lines, 0, e.path, e.value); err != nil {
+			return err
+		}
+	}
+	out := []byte(strings.Join(lines, "\n") + "\n")
+	// don't write anything we couldn't load again
+	if err := yaml.Unmarshal(out, DefaultConfig()); err != nil {
+		return fmt.Errorf("failed to edit %s: %v", path, err)
+	}
+	if err := writeFileAtomic(path, 0644, func(f *os.File) error {
+		_, err := f.Write(out)
+		return err
+	}); err != nil {
+		return err
+	}
+	c.edits = nil
+	return nil
+}
+
+// yamlIndent returns the indentation of a line, or -1 if it is blank or a comment
+func yamlIndent(line string) int {
+	trimmed := strings.TrimLeft(line, " ")
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return -1
+	}
+	return len(line) - len(trimmed)
+}
+
+// yamlKey returns the key of a "key: value" line, and its value
+func yamlKey(line string) (string, interface{}, bool) {
+	var entry yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(strings.TrimSpace(line)), &entry); err != nil || len(entry) != 1 {
+		return "", nil, false
+	}
+	return fmt.Sprint(entry[0].Key), entry[0].Value, true
+}
+
+// yamlComment returns the comment at the end of a "key: value" line, with the space before it
+func yamlComment(line string) string {
+	_, value, _ := yamlKey(line)
+	for i := strings.Index(line, " #"); i >= 0; {
+		if _, before, ok := yamlKey(line[:i]); ok && reflect.DeepEqual(before, value) {
+			return line[i:]
+		}
+		next := strings.Index(line[i+1:], " #")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return ""
+}
+
+// yamlLines marshals `key: value` as lines indented by `indent`
+func yamlLines(indent int, key string, value interface{}) ([]string, error) {
+	b, err := yaml.Marshal(yaml.MapSlice{{Key: key, Value: value}})
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.Repeat(" ", indent) + lines[i]
+	}
+	return lines, nil
+}
+
+// editYAML sets the key at `path` to `value`, or removes it if `value` is nil, in `lines`: a
+// mapping whose keys are indented by `indent`. Keys on the way to it are added if they are missing.
+func editYAML(lines []string, indent int, path []string, value interface{}) ([]string, error) {
+	// find the key and the lines that belong to it
+	start, end := -1, len(lines)
+	last := -1
+	for i, line := range lines {
+		n := yamlIndent(line)
+		if n < 0 {
+			continue
+		}
+		if start >= 0 && n <= indent {
+			end = i
+			break
+		}
+		if n == indent {
+			last = i
+			if key, _, ok := yamlKey(line); ok && key == path[0] {
+				start = i
+			}
+		} else if start < 0 && n > indent {
+			last = i
+		}
+	}
+	out := make([]string, 0, len(lines)+1)
+	if start < 0 {
+		if value == nil {
+			return lines, nil
+		}
+		// add it after the last key, so it isn't taken for part of a comment below
+		for i := len(path) - 1; i > 0; i-- {
+			value = yaml.MapSlice{{Key: path[i], Value: value}}
+		}
+		added, err := yamlLines(indent, path[0], value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, lines[:last+1]...)
+		out = append(out, added...)
+		return append(out, lines[last+1:]...), nil
+	}
+	out = append(out, lines[:start]...)
+	switch {
+	case len(path) > 1:
+		header := lines[start]
+		children := lines[start+1 : end]
+		if _, inline, _ := yamlKey(header); inline != nil {
+			// a flow mapping like {}, written out as a block so we can edit it
+			entries, _ := inline.(yaml.MapSlice)
+			header = strings.Repeat(" ", indent) + path[0] + ":"
+			children = make([]string, 0)
+			for _, entry := range entries {
+				entryLines, err := yamlLines(indent+2, fmt.Sprint(entry.Key), entry.Value)
+				if err != nil {
+					return nil, err
+				}
+				children = append(children, entryLines...)
+			}
+		}
+		childIndent := indent + 2
+		for _, line := range children {
+			if n := yamlIndent(line); n > indent {
+				childIndent = n
+				break
+			}
+		}
+		edited, err := editYAML(children, childIndent, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, header)
+		out = append(out, edited...)
+	case value != nil:
+		replaced, err := yamlLines(indent, path[0], value)
+		if err != nil {
+			return nil, err
+		}
+		if len(replaced) == 1 {
+			replaced[0] += yamlComment(lines[start])
+		}
+		out = append(out, replaced...)
+	}
+	return append(out, lines[end:]...), nil
+}
@@ -0,0 +1,124 @@
+package model
+
+import "sort"
+
+// contactKey is what contacts are sorted by, as of when it was made
+type contactKey struct {
+	activity int64
+	name     string
+	number   PhoneNumber
+	index    int
+}
+
+func newContactKey(c *Contact, convs ConvInfo) contactKey {
+	key := contactKey{name: c.Name, number: c.Number, index: c.Index}
+	if conv, ok := convs[c]; ok && conv != nil {
+		key.activity = conv.LastActivity()
+	}
+	return key
+}
+
+// less returns true if the contact with key `k` goes before the one with `o` when sorted `by` one
+// of the contact sorts. Ties go by number, which no two contacts share, so there is only one way
+// to sort them.
+func (k contactKey) less(o contactKey, by string) bool {
+	switch by {
+	case SortByActivity:
+		if k.activity != o.activity {
+			return k.activity > o.activity
+		}
+		if k.index != o.index {
+			return k.index < o.index
+		}
+	case SortByName:
+		if k.name != o.name {
+			return k.name < o.name
+		}
+	case SortByIndex:
+		if k.index != o.index {
+			return k.index < o.index
+		}
+	}
+	return k.number < o.number
+}
+
+// ContactOrder keeps contacts sorted as conversations change. Each update only moves the contacts
+// whose place changed, rather than sorting everyone again.
+type ContactOrder struct {
+	by       string
+	contacts []*Contact
+	keys     map[*Contact]contactKey
+}
+
+// NewContactOrder returns an empty order for contacts sorted `by` one of the contact sorts
+func NewContactOrder(by string) *ContactOrder {
+	return &ContactOrder{
+		by:   by,
+		keys: make(map[*Contact]contactKey),
+	}
+}
+
+// Update brings the order up to date with `contacts` and their conversations in `convs`, taking out
+// anyone who isn't in `contacts` any more. Returns true if anything moved.
+func (o *ContactOrder) Update(contacts ContactList, convs ConvInfo) bool {
+	if len(o.keys) == 0 {
+		// everyone is new, sorting is quicker than inserting them one at a time
+		o.contacts = contacts.Sorted(o.by, convs)
+		for _, c := range o.contacts {
+			o.keys[c] = newContactKey(c, convs)
+		}
+		return len(o.contacts) > 0
+	}
+	moved := false
+	for c, key := range o.keys {
+		if contacts[c.Number] == c {
+			continue
+		}
+		i := o.search(key)
+		o.contacts = append(o.contacts[:i], o.contacts[i+1:]...)
+		delete(o.keys, c)
+		moved = true
+	}
+	for _, c := range contacts {
+		key := newContactKey(c, convs)
+		old, ok := o.keys[c]
+		if ok && old == key {
+			continue
+		}
+		if ok {
+			i := o.search(old)
+			o.contacts = append(o.contacts[:i], o.contacts[i+1:]...)
+		}
+		i := o.search(key)
+		o.contacts = append(o.contacts, nil)
+		copy(o.contacts[i+1:], o.contacts[i:])
+		o.contacts[i] = c
+		o.keys[c] = key
+		moved = true
+	}
+	return moved
+}
+
+// search returns where a contact with `key` is, or would go
+func (o *ContactOrder) search(key contactKey) int {
+	return sort.Search(len(o.contacts), func(i int) bool {
+		return !o.keys[o.contacts[i]].less(key, o.by)
+	})
+}
+
+// List returns the contacts in order. It is only good until the next update.
+func (o *ContactOrder) List() []*Contact {
+	return o.contacts
+}
+
+// Position returns where `c` is in the order, -1 if it isn't there
+func (o *ContactOrder) Position(c *Contact) int {
+	key, ok := o.keys[c]
+	if !ok {
+		return -1
+	}
+	if i := o.search(key); i < len(o.contacts) && o.contacts[i] == c {
+		return i
+	}
+	return -1
+}
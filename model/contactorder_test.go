@@ -0,0 +1,72 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContactOrder(t *testing.T) {
+	s := newTestSiggo()
+	for i, name := range []string{"Korben", "Leeloo", "Ruby"} {
+		c := &Contact{Number: fmt.Sprintf("+155500000%02d", i+1), Name: name, Index: i}
+		s.contacts[c.Number] = c
+	}
+	korben, leeloo, ruby := s.contacts["+15550000001"], s.contacts["+15550000002"], s.contacts["+15550000003"]
+	s.newConversation(leeloo).AddMessage(&Message{Timestamp: 100, FromContact: leeloo, Author: leeloo.Number})
+
+	order := NewContactOrder(SortByActivity)
+	assert.True(t, order.Update(s.contacts, s.conversations))
+	// nobody has talked to Korben or Ruby, so they go by index
+	assert.Equal(t, []*Contact{leeloo, korben, ruby}, order.List())
+	assert.False(t, order.Update(s.contacts, s.conversations))
+
+	s.newConversation(ruby).AddMessage(&Message{Timestamp: 200, FromContact: ruby, Author: ruby.Number})
+	assert.True(t, order.Update(s.contacts, s.conversations))
+	assert.Equal(t, []*Contact{ruby, leeloo, korben}, order.List())
+	assert.Equal(t, 1, order.Position(leeloo))
+	assert.Equal(t, 2, s.conversations[ruby].Unread()+s.conversations[leeloo].Unread())
+
+	zorg := &Contact{Number: "+15550000004", Name: "Zorg", Index: 3}
+	s.contacts[zorg.Number] = zorg
+	order.Update(s.contacts, s.conversations)
+	assert.Equal(t, []*Contact{ruby, leeloo, korben, zorg}, order.List())
+	assert.Equal(t, order.List(), s.contacts.SortedByActivity(s.conversations))
+
+	byName := NewContactOrder(SortByName)
+	byName.Update(s.contacts, s.conversations)
+	assert.Equal(t, []*Contact{korben, leeloo, ruby, zorg}, byName.List())
+	assert.Equal(t, -1, byName.Position(&Contact{Number: "+15550000005"}))
+
+	// contacts that are gone are taken out, even if someone else has their number now
+	delete(s.contacts, korben.Number)
+	zorg2 := &Contact{Number: zorg.Number, Name: "Jean-Baptiste Emanuel Zorg", Index: 3}
+	s.contacts[zorg2.Number] = zorg2
+	assert.True(t, order.Update(s.contacts, s.conversations))
+	assert.Equal(t, []*Contact{ruby, leeloo, zorg2}, order.List())
+	assert.Equal(t, -1, order.Position(korben))
+	assert.Equal(t, -1, order.Position(zorg))
+	assert.Equal(t, 2, order.Position(zorg2))
+	assert.False(t, order.Update(s.contacts, s.conversations))
+
+	for number := range s.contacts {
+		delete(s.contacts, number)
+	}
+	assert.True(t, order.Update(s.contacts, s.conversations))
+	assert.Empty(t, order.List())
+}
+
+func TestUnread(t *testing.T) {
+	s := newTestSiggo()
+	leeloo := &Contact{Number: "+15550000001", Name: "Leeloo"}
+	conv := s.newConversation(leeloo)
+	conv.AddMessage(&Message{Timestamp: 1, FromContact: leeloo, Author: leeloo.Number, IsRead: true})
+	conv.AddMessage(&Message{Timestamp: 2, FromContact: leeloo, Author: leeloo.Number})
+	conv.AddMessage(&Message{Timestamp: 3, FromSelf: true})
+	conv.AddMessage(&Message{Timestamp: 4, FromContact: leeloo, Author: leeloo.Number})
+	assert.Equal(t, 2, conv.Unread())
+	assert.Equal(t, int64(4), conv.LastActivity())
+	conv.CaughtUp()
+	assert.Equal(t, 0, conv.Unread())
+}
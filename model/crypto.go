@@ -0,0 +1,183 @@
+package model
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// encryptedPrefix marks a saved record as encrypted. Anything else is plain JSON, so conversations
+// saved before encryption was enabled can still be read.
+const encryptedPrefix = "enc:"
+
+// checkPlaintext is sealed into the key file so that we can tell a wrong passphrase apart from
+// corrupt messages
+const checkPlaintext = "siggo"
+
+var (
+	// ErrWrongPassphrase is returned when a passphrase doesn't unlock the key file
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	// ErrLocked is returned when reading encrypted messages without a passphrase
+	ErrLocked = errors.New("message history is encrypted, a passphrase is needed to read it")
+)
+
+// KeyPath returns the path of the file describing how the message encryption key is derived
+func KeyPath() string {
+	return filepath.Join(FindDataFolder(), "key")
+}
+
+// KeyExists returns true if a key file has already been created
+func KeyExists() bool {
+	_, err := os.Stat(KeyPath())
+	return err == nil
+}
+
+// keyParams are the argon2id parameters and salt used to derive a key from a passphrase. The key
+// itself is never written to disk.
+type keyParams struct {
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	// Check is checkPlaintext sealed with the derived key
+	Check string `json:"check"`
+}
+
+// Cipher encrypts and decrypts saved messages with XChaCha20-Poly1305, using a key derived from a
+// passphrase with argon2id. A nil *Cipher leaves messages in plain text.
+type Cipher struct {
+	aead   cipher.AEAD
+	params keyParams
+}
+
+func newCipher(passphrase string, params keyParams) (*Cipher, error) {
+	key := argon2.IDKey([]byte(passphrase), params.Salt, params.Time, params.Memory, params.Threads,
+		chacha20poly1305.KeySize)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead, params: params}, nil
+}
+
+// NewCipher derives a new key from `passphrase` with a fresh salt
+func NewCipher(passphrase string) (*Cipher, error) {
+	params := keyParams{
+		Salt:    make([]byte, 16),
+		Time:    1,
+		Memory:  64 * 1024,
+		Threads: 4,
+	}
+	if _, err := rand.Read(params.Salt); err != nil {
+		return nil, err
+	}
+	c, err := newCipher(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+	if c.params.Check, err = c.seal([]byte(checkPlaintext)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadCipher reads the key file @ `path` and derives its key from `passphrase`. Returns
+// ErrWrongPassphrase if it is not the passphrase the key file was made with.
+func LoadCipher(path, passphrase string) (*Cipher, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	params := keyParams{}
+	if err := json.Unmarshal(b, &params); err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	c, err := newCipher(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+	check, err := c.open(params.Check)
+	if err != nil || string(check) != checkPlaintext {
+		return nil, ErrWrongPassphrase
+	}
+	return c, nil
+}
+
+// SaveKey writes the key file (but not the key) to `path`
+func (c *Cipher) SaveKey(path string) error {
+	b, err := json.Marshal(c.params)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, 0600, func(f *os.File) error {
+		_, err := f.Write(b)
+		return err
+	})
+}
+
+// seal encrypts `plaintext` into a printable record. A nil cipher returns it unchanged.
+func (c *Cipher) seal(plaintext []byte) (string, error) {
+	if c == nil {
+		return string(plaintext), nil
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plaintext, nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a record made by seal. Plain text records are returned unchanged.
+func (c *Cipher) open(record string) ([]byte, error) {
+	if !strings.HasPrefix(record, encryptedPrefix) {
+		return []byte(record), nil
+	}
+	if c == nil {
+		return nil, ErrLocked
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(record, encryptedPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, errors.New("encrypted record is too short")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt record: %v", err)
+	}
+	return plaintext, nil
+}
+
+// encodeRecord marshals `v` as JSON and encrypts it if we have a cipher
+func encodeRecord(v interface{}, c *Cipher) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return c.seal(b)
+}
+
+// decodeRecord decrypts (if needed) a record made by encodeRecord and unmarshals it into `v`
+func decodeRecord(record string, v interface{}, c *Cipher) error {
+	b, err := c.open(record)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
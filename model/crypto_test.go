@@ -0,0 +1,61 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCipher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-crypto-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath := filepath.Join(dir, "key")
+
+	c, err := NewCipher("multipass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SaveKey(keyPath); err != nil {
+		t.Fatalf("failed to save key: %v", err)
+	}
+	_, err = LoadCipher(keyPath, "big badda boom")
+	assert.Equal(t, ErrWrongPassphrase, err)
+	loaded, err := LoadCipher(keyPath, "multipass")
+	if err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+
+	conv := "+15550000001"
+	folder := filepath.Join(dir, "conversations")
+	plain := &Message{Content: "leeloo dallas", Timestamp: 1, Author: conv}
+	if err := NewJSONLStore(folder, nil).Append(conv, plain); err != nil {
+		t.Fatal(err)
+	}
+	secret := &Message{Content: "the fifth element", Timestamp: 2, Author: conv}
+	if err := NewJSONLStore(folder, c).Append(conv, secret); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(folder, conv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, strings.Contains(string(b), "fifth element"))
+
+	// plain text written before encryption was enabled is still readable
+	msgs, err := NewJSONLStore(folder, loaded).Range(conv, 0, 10)
+	if err != nil {
+		t.Fatalf("failed to read encrypted conversation: %v", err)
+	}
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, "the fifth element", msgs[1].Content)
+
+	_, err = NewJSONLStore(folder, nil).Range(conv, 0, 10)
+	assert.Equal(t, ErrLocked, err)
+}
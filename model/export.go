@@ -0,0 +1,278 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ExportMarkdown, ExportHTML, ExportJSON and ExportText are the formats a conversation can be
+	// exported to
+	ExportMarkdown = "md"
+	ExportHTML     = "html"
+	ExportJSON     = "json"
+	ExportText     = "txt"
+)
+
+// ExportFormats lists every export format
+var ExportFormats = []string{ExportMarkdown, ExportHTML, ExportJSON, ExportText}
+
+// exportTimeFormat is how timestamps are written in every format except JSON
+const exportTimeFormat = "2006-01-02 15:04:05"
+
+// ExportedAttachment is an attachment as it appears in an export
+type ExportedAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	// Link is where the file can be found, relative to the export if it was copied
+	Link string `json:"link"`
+}
+
+// ExportedMessage is a message as it appears in an export
+type ExportedMessage struct {
+	Time         time.Time            `json:"time"`
+	Timestamp    int64                `json:"timestamp"`
+	Sender       string               `json:"sender"`
+	SenderNumber PhoneNumber          `json:"sender_number"`
+	FromSelf     bool                 `json:"from_self"`
+	Content      string               `json:"content"`
+	IsDelivered  bool                 `json:"is_delivered"`
+	IsRead       bool                 `json:"is_read"`
+	Attachments  []ExportedAttachment `json:"attachments"`
+}
+
+// Status returns the delivery and read receipts of the message, the way siggo shows them
+func (m ExportedMessage) Status() string {
+	return DeliveryStatus[m.IsDelivered] + ReadStatus[m.IsRead]
+}
+
+// FormattedTime returns the time of the message in the local timezone
+func (m ExportedMessage) FormattedTime() string {
+	return m.Time.Format(exportTimeFormat)
+}
+
+// Export is a conversation (or part of one) ready to be written out
+type Export struct {
+	Contact    string            `json:"contact"`
+	Number     PhoneNumber       `json:"number"`
+	IsGroup    bool              `json:"is_group"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Messages   []ExportedMessage `json:"messages"`
+}
+
+// NewExport prepares `msgs` from the conversation with `contact` for export. `link` returns where
+// each attachment should be linked to. Spoilers are hidden behind `spoiler`, or shown if it is "".
+func NewExport(contact *Contact, msgs []*Message, cfg *Config, spoiler string,
+	link func(*Attachment) string) *Export {
+	e := &Export{
+		Contact:    contact.String(),
+		Number:     contact.Number,
+		IsGroup:    contact.IsGroup(),
+		ExportedAt: time.Now(),
+		Messages:   make([]ExportedMessage, 0, len(msgs)),
+	}
+	for _, msg := range msgs {
+		em := ExportedMessage{
+			Time:         time.Unix(0, msg.Timestamp*int64(time.Millisecond)),
+			Timestamp:    msg.Timestamp,
+			SenderNumber: msg.Author,
+			FromSelf:     msg.FromSelf,
+			Content:      msg.Content,
+			IsDelivered:  msg.IsDelivered,
+			IsRead:       msg.IsRead,
+			Attachments:  make([]ExportedAttachment, 0, len(msg.Attachments)),
+		}
+		if msg.IsDeleted {
+			em.Content = DeletedContent
+		} else if spoiler != "" {
+			em.Content = msg.MaskedText(spoiler)
+		}
+		switch {
+		case msg.FromSelf:
+			em.Sender = cfg.UserName
+		case msg.FromContact != nil:
+			em.Sender = msg.FromContact.String()
+		default:
+			em.Sender = msg.Author
+		}
+		for _, a := range msg.Attachments {
+			em.Attachments = append(em.Attachments, ExportedAttachment{
+				Filename:    a.DisplayName(),
+				ContentType: a.ContentType,
+				Size:        a.Size,
+				Link:        link(a),
+			})
+		}
+		e.Messages = append(e.Messages, em)
+	}
+	return e
+}
+
+// Write writes the export to `w` in `format`
+func (e *Export) Write(w io.Writer, format string) error {
+	switch format {
+	case ExportMarkdown:
+		return e.writeMarkdown(w)
+	case ExportHTML:
+		return exportHTMLTemplate.Execute(w, e)
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(e)
+	case ExportText:
+		return e.writeText(w)
+	}
+	return fmt.Errorf("unknown export format: %s (use one of %s)", format,
+		strings.Join(ExportFormats, ", "))
+}
+
+func (e *Export) writeText(w io.Writer) error {
+	for _, m := range e.Messages {
+		if _, err := fmt.Fprintf(w, "%s|%s| %s: %s\n", m.FormattedTime(), m.Status(), m.Sender,
+			m.Content); err != nil {
+			return err
+		}
+		for _, a := range m.Attachments {
+			if _, err := fmt.Fprintf(w, " 📎| %s | %s | %dB\n", a.Link, a.ContentType, a.Size); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// markdownEscaper escapes the characters that would otherwise turn message text into markdown
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "#", `\#`, "<", `\<`, ">", `\>`,
+)
+
+func (e *Export) writeMarkdown(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# %s <%s>\n\n", markdownEscaper.Replace(e.Contact), e.Number); err != nil {
+		return err
+	}
+	for _, m := range e.Messages {
+		content := markdownEscaper.Replace(m.Content)
+		// keep line breaks within a message
+		content = strings.ReplaceAll(content, "\n", "  \n")
+		if _, err := fmt.Fprintf(w, "**%s** _%s_ %s  \n%s\n", markdownEscaper.Replace(m.Sender),
+			m.FormattedTime(), m.Status(), content); err != nil {
+			return err
+		}
+		for _, a := range m.Attachments {
+			if _, err := fmt.Fprintf(w, "📎 [%s](<%s>) (%s, %dB)  \n", markdownEscaper.Replace(a.Filename),
+				a.Link, a.ContentType, a.Size); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var exportHTMLTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Contact}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: auto; }
+.message { margin: 0.5em 0; }
+.self { color: #666; }
+.time, .status { color: #999; font-size: 0.8em; }
+.content { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Contact}} &lt;{{.Number}}&gt;</h1>
+{{range .Messages}}<div class="message{{if .FromSelf}} self{{end}}">
+<span class="time">{{.FormattedTime}}</span> <span class="status">{{.Status}}</span> <b>{{.Sender}}</b>:
+<span class="content">{{.Content}}</span>
+{{range .Attachments}}<div class="attachment">📎 <a href="{{.Link}}">{{.Filename}}</a> ({{.ContentType}}, {{.Size}}B)</div>
+{{end}}</div>
+{{end}}</body>
+</html>
+`))
+
+// DisplayName returns the name of the attachment file without its folder
+func (a *Attachment) DisplayName() string {
+	for _, name := range []string{a.Filename, a.ID, a.Archived} {
+		if name != "" {
+			return filepath.Base(name)
+		}
+	}
+	return ""
+}
+
+// History returns every message in the conversation with `contact` sent between `since` and
+// `until` (in ms), oldest first. Saved messages are read from the store, so this isn't limited to
+// what has been loaded.
+func (s *Siggo) History(contact *Contact, since, until int64) ([]*Message, error) {
+	if until <= 0 {
+		until = math.MaxInt64
+	}
+	msgs := make(map[MessageKey]*Message)
+	if s.store != nil {
+		saved, err := s.store.Range(contact.Number, since, until)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range saved {
+			if msg.FromContact != nil {
+				msg.FromContact.Configure(s.config)
+			}
+			if msg.Author == "" {
+				msg.Author = legacyAuthor(msg, s.config, contact)
+			}
+			if !msg.FromSelf && msg.FromContact == nil {
+				msg.FromContact = contact
+			}
+			msgs[msg.Key()] = msg
+		}
+	}
+	// plus anything we haven't saved
+	if conv, ok := s.conversations[contact]; ok {
+		for key, msg := range conv.Messages {
+			if msg.Timestamp >= since && msg.Timestamp <= until {
+				msgs[key] = msg
+			}
+		}
+	}
+	out := make([]*Message, 0, len(msgs))
+	for _, msg := range msgs {
+		out = append(out, msg)
+	}
+	sort.Slice(out, func(i, j int) bool { return keyBefore(out[i].Key(), out[j].Key()) })
+	return out, nil
+}
+
+// ExportContacts returns everyone there is a conversation with to export: our contacts sorted by
+// name, then anybody with saved messages who isn't a contact any more, by number
+func (s *Siggo) ExportContacts() ([]*Contact, error) {
+	contacts := s.contacts.SortedByName()
+	if s.store == nil {
+		return contacts, nil
+	}
+	saved, err := s.store.Conversations()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(saved)
+	for _, number := range saved {
+		if _, ok := s.contacts[number]; ok {
+			continue
+		}
+		// groups are known by their id, which isn't a phone number
+		contacts = append(contacts, &Contact{Number: number, isGroup: !strings.HasPrefix(number, "+")})
+	}
+	return contacts, nil
+}
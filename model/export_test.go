@@ -0,0 +1,88 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.UserName = "Korben"
+	contact := &Contact{Number: "+15550000001", Name: "Leeloo"}
+	msgs := []*Message{
+		{Content: "*multipass*", Timestamp: 1000, Author: contact.Number, FromContact: contact,
+			IsDelivered: true, Attachments: []*Attachment{{Filename: "/tmp/pass.jpg", ContentType: "image/jpeg"}}},
+		{Content: "<b>yes</b>", Timestamp: 2000, Author: "+15559999999", FromSelf: true},
+	}
+	export := NewExport(contact, msgs, cfg, "▒", func(a *Attachment) string { return "files/" + a.DisplayName() })
+
+	md := &bytes.Buffer{}
+	assert.NoError(t, export.Write(md, ExportMarkdown))
+	assert.Contains(t, md.String(), `\*multipass\*`)
+	assert.Contains(t, md.String(), "[pass.jpg](<files/pass.jpg>)")
+	assert.Contains(t, md.String(), "**Korben**")
+
+	html := &bytes.Buffer{}
+	assert.NoError(t, export.Write(html, ExportHTML))
+	assert.False(t, strings.Contains(html.String(), "<b>yes</b>"))
+
+	js := &bytes.Buffer{}
+	assert.NoError(t, export.Write(js, ExportJSON))
+	loaded := &Export{}
+	assert.NoError(t, json.Unmarshal(js.Bytes(), loaded))
+	assert.Len(t, loaded.Messages, 2)
+	assert.Equal(t, "Leeloo", loaded.Messages[0].Sender)
+	assert.True(t, loaded.Messages[0].IsDelivered)
+
+	assert.Error(t, export.Write(&bytes.Buffer{}, "pdf"))
+
+	// spoilers are hidden, unless we ask for them
+	content, styles := ParseMarkup("the password is ||multipass||")
+	secret := []*Message{{Content: content, Styles: styles, Timestamp: 3000, Author: contact.Number,
+		FromContact: contact}}
+	for _, format := range ExportFormats {
+		out := &bytes.Buffer{}
+		assert.NoError(t, NewExport(contact, secret, cfg, "▒", nil).Write(out, format))
+		assert.NotContains(t, out.String(), "multipass", format)
+		assert.Contains(t, out.String(), "▒▒▒▒▒▒▒▒▒", format)
+	}
+	revealed := NewExport(contact, secret, cfg, "", nil)
+	assert.Equal(t, "the password is multipass", revealed.Messages[0].Content)
+}
+
+func TestExportContacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newTestSiggo()
+	s.store = NewJSONLStore(dir, nil)
+	leeloo := &Contact{Number: "+15550000001", Name: "Leeloo"}
+	korben := &Contact{Number: "+15550000002", Name: "Korben"}
+	s.contacts[leeloo.Number] = leeloo
+	s.contacts[korben.Number] = korben
+	// Zorg and a group were removed from our contacts, but we still have their messages
+	for _, conv := range []PhoneNumber{leeloo.Number, "+15550000004", "Zm9vYmFy"} {
+		assert.NoError(t, s.store.Append(conv, &Message{Timestamp: 1, Author: conv, Content: "multipass"}))
+	}
+
+	contacts, err := s.ExportContacts()
+	assert.NoError(t, err)
+	if assert.Len(t, contacts, 4) {
+		assert.Equal(t, []*Contact{korben, leeloo}, contacts[:2])
+		assert.Equal(t, "+15550000004", contacts[2].Number)
+		assert.False(t, contacts[2].IsGroup())
+		assert.Equal(t, "Zm9vYmFy", contacts[3].Number)
+		assert.True(t, contacts[3].IsGroup())
+	}
+	msgs, err := s.History(contacts[2], 0, 0)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces the file @ `path` with whatever `write` writes. The data goes to a
+// temporary file in the same folder that is synced and renamed over `path`, so a crash leaves
+// either the old file or the new one, never half of each.
+func writeFileAtomic(path string, perm os.FileMode, write func(f *os.File) error) error {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	// only does anything if we fail before the rename
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes sure a new or renamed file in `dir` survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// trimTornLine cuts off the last line of `f` if it doesn't end with a newline and isn't a whole
+// record (encrypted with `c`, if it isn't nil), which is what a crash in the middle of appending
+// leaves behind. A whole record only missing its newline, from another tool say, gets one instead.
+// `f` is opened for appending.
+func trimTornLine(f *os.File, c *Cipher) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+	start := int64(0)
+	buf := make([]byte, 4096)
+	for pos := end; pos > 0; {
+		n := int64(len(buf))
+		if pos < n {
+			n = pos
+		}
+		pos -= n
+		if _, err := f.ReadAt(buf[:n], pos); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			start = pos + int64(i) + 1
+			break
+		}
+	}
+	if start == end {
+		return nil // nothing torn
+	}
+	last := make([]byte, end-start)
+	if _, err := f.ReadAt(last, start); err != nil {
+		return err
+	}
+	var record interface{}
+	if decodeRecord(string(last), &record, c) == nil {
+		_, err := f.Write([]byte{'\n'})
+		return err
+	}
+	return f.Truncate(start)
+}
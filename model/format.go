@@ -0,0 +1,118 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// RelativeTime is the timestamp format for times like "5m ago"
+const RelativeTime = "relative"
+
+// How thumbnails of images are drawn: with half-block characters, which works in any terminal with
+// colors, with the kitty graphics protocol or with sixels. ImagesAuto picks the best the terminal
+// seems to have.
+const (
+	ImagesAuto   = "auto"
+	ImagesBlocks = "blocks"
+	ImagesKitty  = "kitty"
+	ImagesSixel  = "sixel"
+)
+
+// MessageFormat is how messages are shown in the conversation panel
+type MessageFormat struct {
+	// TimestampFormat is a Go time layout like "15:04" or "2006-01-02 15:04:05", or "relative" for
+	// times like "5m ago"
+	TimestampFormat string `yaml:"timestamp_format"`
+	// DayFormat is the Go time layout of the line shown where a new day starts, "" shows none
+	DayFormat string `yaml:"day_format"`
+	// Timezone is the IANA time zone times are shown in, like "Europe/Berlin". "" is the local
+	// time zone.
+	Timezone string `yaml:"timezone"`
+	// CollapseSenders leaves the sender off messages that follow one from the same sender
+	CollapseSenders bool `yaml:"collapse_senders"`
+	// HangingIndent wraps long messages to line up under where their text starts, rather than
+	// under the timestamp
+	HangingIndent bool `yaml:"hanging_indent"`
+	// Images shows thumbnails of image attachments in every conversation, rather than only in the
+	// ones they are turned on in. ImageProtocol is how they are drawn, and ImageRows how many rows
+	// high they are.
+	Images        bool   `yaml:"images"`
+	ImageProtocol string `yaml:"image_protocol"`
+	ImageRows     int    `yaml:"image_rows"`
+}
+
+// DefaultMessageFormat returns the default message format
+func DefaultMessageFormat() MessageFormat {
+	return MessageFormat{
+		TimestampFormat: "15:04",
+		DayFormat:       "Monday, January 2 2006",
+		CollapseSenders: true,
+		HangingIndent:   true,
+		ImageProtocol:   ImagesAuto,
+		ImageRows:       8,
+	}
+}
+
+// Validate checks that the message format makes sense
+func (f *MessageFormat) Validate() error {
+	if f.TimestampFormat == "" {
+		return fmt.Errorf("timestamp_format can't be empty")
+	}
+	if _, err := f.Location(); err != nil {
+		return err
+	}
+	switch f.ImageProtocol {
+	case ImagesAuto, ImagesBlocks, ImagesKitty, ImagesSixel:
+	default:
+		return fmt.Errorf("image_protocol must be %s, %s, %s or %s, not %q", ImagesAuto, ImagesBlocks,
+			ImagesKitty, ImagesSixel, f.ImageProtocol)
+	}
+	if f.ImageRows < 1 {
+		return fmt.Errorf("image_rows must be at least 1")
+	}
+	return nil
+}
+
+// Location returns the time zone times are shown in
+func (f *MessageFormat) Location() (*time.Location, error) {
+	if f.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(f.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q: %v", f.Timezone, err)
+	}
+	return loc, nil
+}
+
+// Timestamp formats `t` as of `now`, which only matters for relative times
+func (f *MessageFormat) Timestamp(t, now time.Time) string {
+	if f.TimestampFormat != RelativeTime {
+		return t.Format(f.TimestampFormat)
+	}
+	age := now.Sub(t)
+	switch {
+	case age < time.Minute:
+		return "now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age/time.Minute))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(age/time.Hour))
+	case age < 7*24*time.Hour:
+		return fmt.Sprintf("%dd ago", int(age/(24*time.Hour)))
+	case t.Year() == now.Year():
+		return t.Format("Jan 2")
+	}
+	return t.Format("Jan 2 2006")
+}
+
+// SameDay returns true if `a` and `b` are on the same day in the time zone of `a`
+func SameDay(a, b time.Time) bool {
+	b = b.In(a.Location())
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+// MessageTime returns the time a message was sent, from its timestamp in milliseconds
+func MessageTime(timestamp int64) time.Time {
+	return time.Unix(0, timestamp*int64(time.Millisecond))
+}
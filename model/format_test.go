@@ -0,0 +1,40 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageFormat(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	f := DefaultMessageFormat()
+	assert.Equal(t, "11:55", f.Timestamp(now.Add(-5*time.Minute), now))
+
+	f.TimestampFormat = RelativeTime
+	assert.Equal(t, "now", f.Timestamp(now.Add(-30*time.Second), now))
+	assert.Equal(t, "5m ago", f.Timestamp(now.Add(-5*time.Minute), now))
+	assert.Equal(t, "3h ago", f.Timestamp(now.Add(-3*time.Hour), now))
+	assert.Equal(t, "2d ago", f.Timestamp(now.Add(-50*time.Hour), now))
+	assert.Equal(t, "May 1", f.Timestamp(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), now))
+	assert.Equal(t, "Dec 31 2019", f.Timestamp(time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC), now))
+
+	f.Timezone = "Asia/Tokyo"
+	loc, err := f.Location()
+	assert.NoError(t, err)
+	// 20:00 in UTC is already the next day in Tokyo
+	late := time.Date(2020, 6, 15, 20, 0, 0, 0, time.UTC)
+	assert.True(t, SameDay(now, late))
+	assert.False(t, SameDay(now.In(loc), late))
+
+	f.Timezone = "Nowhere/Special"
+	assert.Error(t, f.Validate())
+
+	f = DefaultMessageFormat()
+	assert.NoError(t, f.Validate())
+	f.ImageProtocol = "iterm"
+	assert.Error(t, f.Validate())
+	f.ImageProtocol, f.ImageRows = ImagesSixel, 0
+	assert.Error(t, f.Validate())
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxCommandHistory is how many commands we remember
+const maxCommandHistory = 500
+
+// CommandHistory is the commands entered on the command line, oldest first. It is kept in a file,
+// one command per line.
+type CommandHistory struct {
+	path     string
+	Commands []string
+}
+
+// LoadCommandHistory reads the command history @ `path`. A missing file is an empty history.
+func LoadCommandHistory(path string) (*CommandHistory, error) {
+	h := &CommandHistory{path: path}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return h, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.Commands = append(h.Commands, line)
+		}
+	}
+	return h, scanner.Err()
+}
+
+// Add remembers `command`, moving it to the end if we already had it, and saves the history
+func (h *CommandHistory) Add(command string) error {
+	command = strings.TrimSpace(command)
+	if command == "" || strings.Contains(command, "\n") {
+		return nil
+	}
+	for i, c := range h.Commands {
+		if c == command {
+			h.Commands = append(h.Commands[:i], h.Commands[i+1:]...)
+			break
+		}
+	}
+	h.Commands = append(h.Commands, command)
+	if len(h.Commands) > maxCommandHistory {
+		h.Commands = h.Commands[len(h.Commands)-maxCommandHistory:]
+	}
+	return h.save()
+}
+
+// save writes the history to its file
+func (h *CommandHistory) save() error {
+	if err := os.MkdirAll(filepath.Dir(h.path), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(h.path, 0600, func(f *os.File) error {
+		w := bufio.NewWriter(f)
+		for _, c := range h.Commands {
+			if _, err := w.WriteString(c + "\n"); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	})
+}
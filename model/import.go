@@ -0,0 +1,81 @@
+package model
+
+import (
+	"bufio"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ImportStats counts what an import added to a conversation
+type ImportStats struct {
+	Messages    int
+	Receipts    int
+	Attachments int
+}
+
+// receiptState is what we knew about the receipts of one of our messages before an import
+type receiptState struct {
+	delivered bool
+	read      bool
+}
+
+// Import feeds recorded `signal-cli receive --json` output, one message per line, through
+// `process` (usually Signal.ProcessWire on the SignalAPI siggo was made with). Messages are merged
+// into the conversations we already have, so anything imported twice is only kept once. Returns
+// what was added to each conversation and the number of lines that couldn't be processed.
+//
+// Conversations should be fully loaded first (MaxConversationLength 0) so that duplicates of old
+// messages are recognized.
+func (s *Siggo) Import(r io.Reader, process func([]byte) error) (map[*Contact]*ImportStats, int, error) {
+	before := make(map[MessageKey]bool)
+	receipts := make(map[MessageKey]receiptState)
+	for _, conv := range s.conversations {
+		for key, msg := range conv.Messages {
+			before[key] = true
+			receipts[key] = receiptState{msg.IsDelivered, msg.IsRead}
+		}
+	}
+
+	skipped := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		wire := scanner.Bytes()
+		if len(wire) == 0 {
+			continue
+		}
+		if err := process(wire); err != nil {
+			log.Warnf("skipping line that couldn't be imported: %v", err)
+			skipped++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, skipped, err
+	}
+
+	stats := make(map[*Contact]*ImportStats)
+	for contact, conv := range s.conversations {
+		st := &ImportStats{}
+		for key, msg := range conv.Messages {
+			if !before[key] {
+				st.Messages++
+				st.Attachments += len(msg.Attachments)
+			}
+			if msg.FromSelf {
+				// receipts are only for our messages
+				old := receipts[key]
+				if msg.IsDelivered && !old.delivered {
+					st.Receipts++
+				}
+				if msg.IsRead && !old.read {
+					st.Receipts++
+				}
+			}
+		}
+		if *st != (ImportStats{}) {
+			stats[contact] = st
+		}
+	}
+	return stats, skipped, nil
+}
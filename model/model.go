@@ -1,13 +1,16 @@
 package model
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"github.com/derricw/siggo/signal"
 	"github.com/gen2brain/beeep"
@@ -24,12 +27,22 @@ var ReadStatus map[bool]string = map[bool]string{
 	false: "X",
 }
 
+// PendingStatus is shown instead of the delivery and read status while a message is being sent
+var PendingStatus = "⟳ "
+
+// FailedStatus is shown instead of the delivery and read status when a message failed to send
+var FailedStatus = "! "
+
+// DeletedContent is shown instead of a message that was deleted
+var DeletedContent = "(deleted)"
+
 // PhoneNumber is an alias for string not derived
 type PhoneNumber = string
 
 type Contact struct {
 	Number  PhoneNumber
 	Name    string
+	UUID    string `json:",omitempty"`
 	Index   int
 	alias   string
 	color   string
@@ -87,28 +100,168 @@ func (cl ContactList) List() []*Contact {
 	return list
 }
 
+// IsGroup returns true if the contact is a group
+func (c *Contact) IsGroup() bool {
+	return c.isGroup
+}
+
+// Lookup finds the contact or group matching `query`, which can be a number (or group ID), a name,
+// or an alias. Names and aliases are matched case-insensitively. It is an error if the name is
+// ambiguous.
+func (cl ContactList) Lookup(query string, group bool) (*Contact, error) {
+	if c, ok := cl[query]; ok && c.isGroup == group {
+		return c, nil
+	}
+	matches := make([]*Contact, 0)
+	for _, c := range cl.SortedByName() {
+		if c.isGroup != group {
+			continue
+		}
+		if strings.EqualFold(c.Name, query) || strings.EqualFold(c.alias, query) ||
+			strings.EqualFold(c.String(), query) {
+			matches = append(matches, c)
+		}
+	}
+	kind := "contact"
+	if group {
+		kind = "group"
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no %s matching: %s", kind, query)
+	case 1:
+		return matches[0], nil
+	}
+	names := make([]string, 0, len(matches))
+	for _, c := range matches {
+		names = append(names, fmt.Sprintf("%s <%s>", c.String(), c.Number))
+	}
+	return nil, fmt.Errorf("%s is ambiguous: %s", query, strings.Join(names, ", "))
+}
+
+// fuzzyScore scores how well `query` matches `target`. Every character of the query has to show
+// up in the target, in order. Matches at the start of the target or of a word, and runs of
+// consecutive matches, score higher; characters skipped in between cost a little. Both should
+// already be lower case.
+func fuzzyScore(query, target string) (int, bool) {
+	q := []rune(query)
+	t := []rune(target)
+	if len(q) == 0 {
+		return 0, true
+	}
+	score, qi, last := 0, 0, -1
+	for ti := 0; ti < len(t) && qi < len(q); ti++ {
+		if t[ti] != q[qi] {
+			continue
+		}
+		switch {
+		case ti == 0:
+			score += 10
+		case !unicode.IsLetter(t[ti-1]) && !unicode.IsNumber(t[ti-1]):
+			score += 8
+		case last == ti-1:
+			score += 5
+		default:
+			score++
+		}
+		if last >= 0 {
+			score -= ti - last - 1
+		}
+		last = ti
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	if len(q) == len(t) {
+		// exact match
+		score += 20
+	}
+	return score, true
+}
+
+// FuzzyFind returns the contacts and groups whose name, alias or number fuzzy matches `query`,
+// best match first. An empty query returns everyone, in the same order as SortedByIndex.
+func (cl ContactList) FuzzyFind(query string) []*Contact {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return cl.SortedByIndex()
+	}
+	scores := make(map[*Contact]int)
+	matches := make([]*Contact, 0)
+	for _, c := range cl {
+		best, found := 0, false
+		for _, field := range []string{c.String(), c.Name, c.alias, c.Number} {
+			if field == "" {
+				continue
+			}
+			if score, ok := fuzzyScore(query, strings.ToLower(field)); ok && (!found || score > best) {
+				best, found = score, true
+			}
+		}
+		if found {
+			scores[c] = best
+			matches = append(matches, c)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		if len(a.String()) != len(b.String()) {
+			return len(a.String()) < len(b.String())
+		}
+		return a.String() < b.String()
+	})
+	return matches
+}
+
 // SortedByNumber returns a slice of contacts sorted by phone number
 // Idk why anyone would ever want to use this but here it is.
 func (cl ContactList) SortedByNumber() []*Contact {
-	list := cl.List()
-	sort.Slice(list, func(i, j int) bool { return list[i].Number < list[j].Number })
-	return list
+	return cl.Sorted(SortByNumber, nil)
 }
 
 // SortedByName returns a slice of contacts sorted alphabetically
 func (cl ContactList) SortedByName() []*Contact {
-	list := cl.List()
-	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
-	return list
+	return cl.Sorted(SortByName, nil)
 }
 
 // SortedByIndex returns a slice of contacts sorted by index provided by signal-cli
 func (cl ContactList) SortedByIndex() []*Contact {
+	return cl.Sorted(SortByIndex, nil)
+}
+
+// SortedByActivity returns a slice of contacts sorted by the last message in their conversation
+// in `convs`, most recent first. Contacts we haven't talked to come last, sorted by index.
+func (cl ContactList) SortedByActivity(convs ConvInfo) []*Contact {
+	return cl.Sorted(SortByActivity, convs)
+}
+
+// Sorted returns a slice of contacts sorted `by` one of the contact sorts. Only SortByActivity
+// needs the conversations.
+func (cl ContactList) Sorted(by string, convs ConvInfo) []*Contact {
 	list := cl.List()
-	sort.Slice(list, func(i, j int) bool { return list[i].Index < list[j].Index })
+	keys := make(map[*Contact]contactKey, len(list))
+	for _, c := range list {
+		keys[c] = newContactKey(c, convs)
+	}
+	sort.Slice(list, func(i, j int) bool { return keys[list[i]].less(keys[list[j]], by) })
 	return list
 }
 
+// MessageKey identifies a message. A timestamp alone isn't enough, two members of a group can send
+// a message in the same millisecond.
+type MessageKey struct {
+	Author    PhoneNumber
+	Timestamp int64
+}
+
+func (k MessageKey) String() string {
+	return fmt.Sprintf("%s@%d", k.Author, k.Timestamp)
+}
+
 type Message struct {
 	Content     string        `json:"content"`
 	Timestamp   int64         `json:"timestamp"`
@@ -116,41 +269,112 @@ type Message struct {
 	IsRead      bool          `json:"is_read"`
 	FromSelf    bool          `json:"from_self"`
 	Attachments []*Attachment `json:"attachments"`
-	From        string        `json:from"`
-	FromContact *Contact      `json:from_contact"`
+	From        string        `json:"from"`
+	FromContact *Contact      `json:"from_contact"`
+	// Author is the number of whoever sent the message, our own number if it was us
+	Author PhoneNumber `json:"author"`
+	// IsPending and IsFailed are for our own messages that are still in the outbox
+	IsPending bool `json:"is_pending,omitempty"`
+	IsFailed  bool `json:"is_failed,omitempty"`
+	// Quote is the message this one replies to, Reactions everyone's emoji reaction to it
+	Quote     *Quote                 `json:"quote,omitempty"`
+	Reactions map[PhoneNumber]string `json:"reactions,omitempty"`
+	IsDeleted bool                   `json:"is_deleted,omitempty"`
+	// Styles are the bold, italic and other text styles of the content
+	Styles []*TextStyle `json:"styles,omitempty"`
+	// Previews are the link previews that came with it
+	Previews []*Preview `json:"previews,omitempty"`
+}
+
+// UnmarshalJSON reads a message, accepting the key older versions of siggo used for the sender.
+func (m *Message) UnmarshalJSON(b []byte) error {
+	type message Message
+	aux := &struct {
+		*message
+		LegacyFromContact *Contact `json:"FromContact"`
+	}{message: (*message)(m)}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+	if m.FromContact == nil {
+		m.FromContact = aux.LegacyFromContact
+	}
+	return nil
+}
+
+// Key returns the key that identifies this message
+func (m *Message) Key() MessageKey {
+	return MessageKey{Author: m.Author, Timestamp: m.Timestamp}
+}
+
+// applyReceipt marks the message as delivered and/or read
+func (m *Message) applyReceipt(isDelivery, isRead bool) {
+	if isRead {
+		// for whatever reason messages can be marked as
+		// read but not delivered, so we go ahead and assume any
+		// message that has been read has also been delivered
+		m.IsDelivered = true
+		m.IsRead = true
+	} else if isDelivery {
+		m.IsDelivered = true
+	}
 }
 
 func (m *Message) String() string {
-	var fromStr, color string
-	if !m.FromSelf {
-		fromStr = m.FromContact.String()
-		color = m.FromContact.Color()
-	} else {
-		fromStr = " ~ "
+	return m.Render(DefaultTheme())
+}
+
+// StyleTag returns the tview tag for a theme style, after resetting whatever came before
+func StyleTag(style string) string {
+	return fmt.Sprintf("[-:-:-][%s]", style)
+}
+
+// Body returns what the message says, with the message it replies to and its reactions
+func (m *Message) Body(theme *Theme) string {
+	content := m.Content
+	if m.IsDeleted {
+		content = DeletedContent
+	}
+	return m.QuotePrefix(theme) + content + m.ReactionSuffix()
+}
+
+// QuotePrefix returns what goes before the content of a reply, "" if the message isn't one
+func (m *Message) QuotePrefix(theme *Theme) string {
+	if m.Quote == nil {
+		return ""
 	}
+	return fmt.Sprintf("%s “%s” ", theme.ReplyGlyph, m.Quote)
+}
 
-	template := "%s|%s%s| %" + fmt.Sprintf("%dv", len(fromStr)) + ": %s\n"
+// ReactionSuffix returns what goes after the content of a message with reactions, "" if it has none
+func (m *Message) ReactionSuffix() string {
+	if len(m.Reactions) == 0 {
+		return ""
+	}
+	return "  " + m.ReactionSummary()
+}
+
+// Render renders the message on a single line, styled by `theme`. The message is escaped, so
+// nothing in it is taken for tags.
+func (m *Message) Render(theme *Theme) string {
+	fromStr := fmt.Sprintf(" %s ", theme.SelfGlyph)
+	if !m.FromSelf {
+		fromStr = EscapeMarkup(m.FromContact.String())
+	}
+	tag := theme.MessageTag(m)
+	template := "%s%s%s|%s| %" + fmt.Sprintf("%dv", len(fromStr)) + ": %s\n"
 	data := fmt.Sprintf(template,
-		// lets come up with a way to avoid the *1000000
+		tag+fmt.Sprintf("[%s]", theme.Timestamp),
 		// Magical Ref Data: Mon Jan 2 15:04:05 MST 2006
-		time.Unix(0, m.Timestamp*1000000).Format("2006-01-02 15:04:05"),
-		DeliveryStatus[m.IsDelivered],
-		ReadStatus[m.IsRead],
+		MessageTime(m.Timestamp).Format("2006-01-02 15:04:05"),
+		tag,
+		theme.Status(m),
 		fromStr,
-		m.Content,
+		EscapeMarkup(m.Body(theme)),
 	)
-	if m.FromSelf == true {
-		// dim messages from self (for now, until we support color for contacts)
-		data = fmt.Sprintf("[::d]%s[::-]", data)
-	} else if m.IsRead == false {
-		// bold messages that haven't been read
-		data = fmt.Sprintf("[%s::b]%s[-::-]", color, data)
-	} else if m.IsRead == true {
-		data = fmt.Sprintf("[%s::]%s[-::]", color, data)
-	}
 	// show attachments
 	for _, a := range m.Attachments {
-		data = fmt.Sprintf("%s%s\n", data, a)
+		data = fmt.Sprintf("%s%s%s%s\n", data, tag, fmt.Sprintf("[%s]", theme.Attachment), EscapeMarkup(a.Render(theme)))
 	}
 	return data
 }
@@ -176,9 +400,9 @@ func (m *Message) AddAttachments(paths []string) {
 	}
 }
 
-// Attachment is any file sent or received. Received attachments are left in the usual `signal-cli`
-// location for now. It seems to automatically delete old attachments, so we may want to come up
-// with a way to keep our own copy somewhere in the siggo data folder.
+// Attachment is any file sent or received. Received attachments are downloaded by `signal-cli`,
+// sent ones are wherever we attached them from. Either way, siggo keeps its own copy in the
+// attachment archive (if enabled), which is what Path returns.
 type Attachment struct {
 	ContentType string `json:"contentType"`
 	Filename    string `json:"filename"`
@@ -186,13 +410,25 @@ type Attachment struct {
 	Size        int    `json:"size"`
 	Timestamp   int64  `json:"timestamp"`
 	FromSelf    bool   `json:"from_self"`
+	// Archived is the path of our copy, relative to AttachmentFolder()
+	Archived string `json:"archived,omitempty"`
 }
 
-// Path returns the full path to an attachment file
+// Path returns the full path to an attachment file, preferring our archived copy
 func (a *Attachment) Path() (string, error) {
+	if a.Archived != "" {
+		path := filepath.Join(AttachmentFolder(), a.Archived)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		// pruned from the archive, maybe the original is still around
+	}
+	return a.sourcePath()
+}
+
+// sourcePath returns where signal-cli saved a received attachment, or where we attached a sent one
+func (a *Attachment) sourcePath() (string, error) {
 	if a.ID == "" {
-		// TODO: save our own copy of the attachment with our own ID
-		// for now, just return the path where we attached it
 		return a.Filename, nil
 	}
 	folder, err := signal.GetSignalFolder()
@@ -204,8 +440,13 @@ func (a *Attachment) Path() (string, error) {
 
 // String returns the string representation of the attachment
 func (a *Attachment) String() string {
+	return a.Render(DefaultTheme())
+}
+
+// Render returns the string representation of the attachment, with the glyph from `theme`
+func (a *Attachment) Render(theme *Theme) string {
 	ts := time.Unix(0, a.Timestamp*1000000).Format("2006-01-02 15:04:05")
-	txt := fmt.Sprintf(" 📎| %s | %s | %s | %dB", ts, a.Filename, a.ContentType, a.Size)
+	txt := fmt.Sprintf(" %s| %s | %s | %s | %dB", theme.AttachmentGlyph, ts, a.Filename, a.ContentType, a.Size)
 	return txt
 }
 
@@ -233,14 +474,26 @@ func ConvertAttachments(wire []*signal.Attachment, timestamp int64, fromSelf boo
 // Coversation is a contact or group and its associated messages
 type Conversation struct {
 	Contact       *Contact // can be a group!
-	Messages      map[int64]*Message
-	MessageOrder  []int64
+	Messages      map[MessageKey]*Message
+	MessageOrder  []MessageKey
 	HasNewMessage bool
 	StagedMessage string
 	// hasNewData tracks whether new data has been added
 	// since the last save to disk
 	hasNewData        bool
 	stagedAttachments []string
+	stagedReply       *Message
+	// saved tracks which messages are already in the store, and changed which of those have
+	// been modified since they were saved
+	saved   map[MessageKey]bool
+	changed map[MessageKey]bool
+	// rewrite is set when the whole conversation needs saving again, for example after migrating
+	// it from an older format. removed holds the keys of saved messages that have moved.
+	rewrite bool
+	removed map[MessageKey]bool
+	// loadedAll is set once every saved message has been loaded, config is what we loaded with
+	loadedAll bool
+	config    *Config
 }
 
 // String renders the conversation to a single string
@@ -258,9 +511,16 @@ func (c *Conversation) AddMessage(message *Message) {
 }
 
 func (c *Conversation) addMessage(message *Message) {
-	_, ok := c.Messages[message.Timestamp]
-	c.Messages[message.Timestamp] = message
-	if !ok {
+	key := message.Key()
+	old, ok := c.Messages[key]
+	if ok {
+		// the same message again (e.g. from an import), don't lose any receipts we had
+		message.applyReceipt(old.IsDelivered, old.IsRead)
+	}
+	c.Messages[key] = message
+	if ok {
+		c.markChanged(key)
+	} else {
 		// new messages
 		// TODO: this section is to prevent saved pre-groups conversations from breaking when
 		// loading.  it ensures that they have a contact. lets remove this after a few releases
@@ -268,22 +528,96 @@ func (c *Conversation) addMessage(message *Message) {
 			message.FromContact = c.Contact
 		}
 		// this we keep
-		c.MessageOrder = append(c.MessageOrder, message.Timestamp)
+		c.insertKey(key)
 		c.HasNewMessage = true
 		c.hasNewData = true
 	}
 }
 
+// insertKey puts a new message in MessageOrder by time. Most go at the end, but imported and
+// delayed messages can be older than the ones we have.
+func (c *Conversation) insertKey(key MessageKey) {
+	i := len(c.MessageOrder)
+	for i > 0 && keyBefore(key, c.MessageOrder[i-1]) {
+		i--
+	}
+	c.MessageOrder = append(c.MessageOrder, MessageKey{})
+	copy(c.MessageOrder[i+1:], c.MessageOrder[i:])
+	c.MessageOrder[i] = key
+}
+
+// updateTimestamp moves a message to a new timestamp, for example when signal-cli tells us the
+// official timestamp of a message we sent.
+func (c *Conversation) updateTimestamp(message *Message, ts int64) {
+	old := message.Key()
+	if old.Timestamp == ts {
+		return
+	}
+	delete(c.Messages, old)
+	if c.saved[old] {
+		// stores can't change a key, so start over
+		c.rewrite = true
+		c.removed[old] = true
+		delete(c.saved, old)
+	}
+	message.Timestamp = ts
+	for _, a := range message.Attachments {
+		a.Timestamp = ts
+	}
+	key := message.Key()
+	c.Messages[key] = message
+	for i, msgKey := range c.MessageOrder {
+		if msgKey == old {
+			c.MessageOrder[i] = key
+			break
+		}
+	}
+	c.hasNewData = true
+}
+
+// markChanged notes that a message has been modified and needs to be saved again
+func (c *Conversation) markChanged(key MessageKey) {
+	if c.saved[key] {
+		c.changed[key] = true
+	}
+	c.hasNewData = true
+}
+
 // LastMessage returns the most recent message. Can be nil.
 func (c *Conversation) LastMessage() *Message {
 	nMessage := len(c.MessageOrder)
 	if nMessage > 0 {
-		lastMsgID := c.MessageOrder[nMessage-1]
-		return c.Messages[lastMsgID]
+		lastMsgKey := c.MessageOrder[nMessage-1]
+		return c.Messages[lastMsgKey]
 	}
 	return nil
 }
 
+// LastActivity returns the time of the most recent message in milliseconds, 0 if there are none
+func (c *Conversation) LastActivity() int64 {
+	if msg := c.LastMessage(); msg != nil {
+		return msg.Timestamp
+	}
+	return 0
+}
+
+// Unread returns the number of messages from others that haven't been read, counting back from the
+// most recent one to the last one that was, like CaughtUp
+func (c *Conversation) Unread() int {
+	n := 0
+	for i := len(c.MessageOrder) - 1; i >= 0; i-- {
+		msg := c.Messages[c.MessageOrder[i]]
+		if msg.FromSelf {
+			continue
+		}
+		if msg.IsRead {
+			break
+		}
+		n++
+	}
+	return n
+}
+
 // StageAttachment attaches a file to be sent in the next message
 func (c *Conversation) AddAttachment(path string) error {
 	if _, err := os.Stat(path); err != nil {
@@ -308,6 +642,7 @@ func (c *Conversation) ClearStagedMessage() {
 func (c *Conversation) ClearStaged() {
 	c.ClearStagedMessage()
 	c.ClearAttachments()
+	c.StageReply(nil)
 }
 
 // NumAttachments returns the number of staged attachments
@@ -322,7 +657,7 @@ func (c *Conversation) HasStagedMessage() bool {
 
 // HasStagedData returns whether the conversation has a staged message or attachment
 func (c *Conversation) HasStagedData() bool {
-	return c.HasStagedMessage() || c.NumAttachments() != 0
+	return c.HasStagedMessage() || c.NumAttachments() != 0 || c.stagedReply != nil
 }
 
 // CaughtUp iterates back through the messages of the conversation marking the un-read ones
@@ -333,84 +668,224 @@ func (c *Conversation) CaughtUp() {
 		if msg.IsRead && !msg.FromSelf {
 			break
 		}
-		c.Messages[c.MessageOrder[i]].IsRead = true
+		if !msg.IsRead {
+			msg.IsRead = true
+			c.markChanged(c.MessageOrder[i])
+		}
 	}
 	c.HasNewMessage = false
 }
 
-// SaveAs writes the conversation to `path`.
-func (c *Conversation) SaveAs(path string) error {
-	f, err := os.Create(path)
-	if err != nil {
-		return err
+// savableMessages returns the messages that belong in the store, which excludes any that haven't
+// been sent yet. Those are saved in the outbox.
+func (c *Conversation) savableMessages() []*Message {
+	msgs := make([]*Message, 0, len(c.MessageOrder))
+	for _, msgKey := range c.MessageOrder {
+		msg := c.Messages[msgKey]
+		if msg.IsPending || msg.IsFailed {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// Save writes the conversation to `store` only if it has new data. New messages are appended and
+// modified ones are updated.
+func (c *Conversation) Save(store Store) error {
+	if !c.hasNewData {
+		return nil
 	}
-	defer f.Close()
-	for _, msgID := range c.MessageOrder {
-		msg := c.Messages[msgID]
-		b, err := json.Marshal(msg)
+	number := c.Contact.Number
+	if c.rewrite {
+		msgs, err := c.rewriteMessages(store)
 		if err != nil {
 			return err
 		}
-		f.Write(b)
-		f.Write([]byte{'\n'})
+		if err := store.Replace(number, msgs); err != nil {
+			return err
+		}
+		for _, msg := range c.savableMessages() {
+			c.saved[msg.Key()] = true
+		}
+		c.changed = make(map[MessageKey]bool)
+		c.removed = make(map[MessageKey]bool)
+		c.rewrite = false
+		c.hasNewData = false
+		return nil
 	}
+	newMsgs := make([]*Message, 0)
+	changedMsgs := make([]*Message, 0)
+	for _, msg := range c.savableMessages() {
+		key := msg.Key()
+		if !c.saved[key] {
+			newMsgs = append(newMsgs, msg)
+		} else if c.changed[key] {
+			changedMsgs = append(changedMsgs, msg)
+		}
+	}
+	if len(newMsgs) > 0 {
+		if err := store.Append(number, newMsgs...); err != nil {
+			return err
+		}
+		for _, msg := range newMsgs {
+			c.saved[msg.Key()] = true
+		}
+	}
+	if len(changedMsgs) > 0 {
+		if err := store.Update(number, changedMsgs...); err != nil {
+			return err
+		}
+	}
+	c.changed = make(map[MessageKey]bool)
+	c.hasNewData = false
 	return nil
 }
 
-// Save writes the conversation to the default location only if it has new data
-func (c *Conversation) Save() error {
-	if !c.hasNewData {
-		return nil
-	}
-	folder := ConversationFolder()
-	err := os.MkdirAll(folder, os.ModePerm)
+// rewriteMessages returns everything that belongs in the store when the conversation is rewritten:
+// the saved messages, migrated and without any that moved, with the ones we have loaded or added
+// in their place. Messages that aren't loaded are read from `store` but don't become part of the
+// conversation.
+func (c *Conversation) rewriteMessages(store Store) ([]*Message, error) {
+	saved, err := store.Range(c.Contact.Number, 0, math.MaxInt64)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	loaded := c.savableMessages()
+	keep := make(map[MessageKey]bool, len(loaded))
+	for _, msg := range loaded {
+		keep[msg.Key()] = true
 	}
-	path := filepath.Join(ConversationFolder(), c.Contact.Number)
-	c.hasNewData = false // better to do this after successful save?
-	return c.SaveAs(path)
+	msgs := make([]*Message, 0, len(saved)+len(loaded))
+	for _, msg := range saved {
+		if msg.Author == "" && c.config != nil {
+			msg.Author = legacyAuthor(msg, c.config, c.Contact)
+		}
+		if keep[msg.Key()] || c.removed[msg.Key()] {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	msgs = append(msgs, loaded...)
+	sortMessages(msgs)
+	return msgs, nil
 }
 
-// Load will load a conversation saved @ `path`
-// TODO: load only the last N messages based on config
+// Load loads the most recent messages of the conversation from `store`. Only the last
+// MaxConversationLength messages are loaded, older ones can be paged in with LoadOlder.
 // HERE IS WHERE THE COLOR THE LOADED MESSAGES
-func (c *Conversation) Load(path string, cfg *Config) error {
-	f, err := os.Open(path)
+func (c *Conversation) Load(store Store, cfg *Config) error {
+	c.config = cfg
+	_, err := c.LoadOlder(store, cfg.MaxConversationLength)
+	// only dirty if something needed migrating
+	c.hasNewData = c.rewrite
+	return err
+}
+
+// LoadOlder pages in up to `n` saved messages from before the oldest one we have, or all of
+// them if `n` is not positive. Returns the number of messages loaded. They are put at the start
+// of the conversation.
+func (c *Conversation) LoadOlder(store Store, n int) (int, error) {
+	if c.loadedAll {
+		return 0, nil
+	}
+	before := MessageKey{}
+	if oldest := c.oldestSaved(); oldest != nil {
+		before = *oldest
+	}
+	msgs, err := store.Page(c.Contact.Number, before, n)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer f.Close()
-	s := bufio.NewScanner(f)
-	for s.Scan() {
-		msg := &Message{}
-		err := json.Unmarshal(s.Bytes(), msg)
-		if err != nil {
-			return err
-		}
+	if n <= 0 || len(msgs) < n {
+		c.loadedAll = true
+	}
+	older := make([]MessageKey, 0, len(msgs))
+	for _, msg := range msgs {
 		if msg.FromContact != nil {
-			msg.FromContact.Configure(cfg)
+			msg.FromContact.Configure(c.config)
+		}
+		if msg.Author == "" {
+			c.migrateMessage(msg, c.config)
+		}
+		key := msg.Key()
+		if _, ok := c.Messages[key]; ok {
+			continue
+		}
+		if !msg.FromSelf && msg.FromContact == nil {
+			msg.FromContact = c.Contact
 		}
-		c.addMessage(msg)
+		c.Messages[key] = msg
+		c.saved[key] = true
+		older = append(older, key)
 	}
-	return nil
+	c.MessageOrder = append(older, c.MessageOrder...)
+	return len(older), nil
+}
+
+// LoadedAll returns true if every saved message has been loaded
+func (c *Conversation) LoadedAll() bool {
+	return c.loadedAll
+}
+
+// oldestSaved returns the key of the oldest message we loaded from the store, or nil if there
+// isn't one
+func (c *Conversation) oldestSaved() *MessageKey {
+	var oldest *MessageKey
+	for _, key := range c.MessageOrder {
+		key := key
+		if c.saved[key] && (oldest == nil || keyBefore(key, *oldest)) {
+			oldest = &key
+		}
+	}
+	return oldest
+}
+
+// migrateMessage fills in the author of a message saved by an older version of siggo, which keyed
+// messages by timestamp only. The conversation is re-saved in the new format next time.
+func (c *Conversation) migrateMessage(msg *Message, cfg *Config) {
+	msg.Author = legacyAuthor(msg, cfg, c.Contact)
+	c.rewrite = true
+	c.hasNewData = true
+}
+
+// legacyAuthor works out who sent a message saved without an author, in the conversation with
+// `contact`
+func legacyAuthor(msg *Message, cfg *Config, contact *Contact) PhoneNumber {
+	switch {
+	case msg.FromSelf:
+		return cfg.UserNumber
+	case msg.FromContact != nil:
+		return msg.FromContact.Number
+	}
+	// saved before groups, so the author is whoever the conversation is with
+	return contact.Number
 }
 
 func NewConversation(contact *Contact) *Conversation {
 	return &Conversation{
 		Contact:       contact,
-		Messages:      make(map[int64]*Message),
-		MessageOrder:  make([]int64, 0),
+		Messages:      make(map[MessageKey]*Message),
+		MessageOrder:  make([]MessageKey, 0),
 		HasNewMessage: false,
 
 		stagedAttachments: make([]string, 0),
+		saved:             make(map[MessageKey]bool),
+		changed:           make(map[MessageKey]bool),
+		removed:           make(map[MessageKey]bool),
 	}
 }
 
 type SignalAPI interface {
-	Send(string, string) (int64, error)
+	Send(string, string, ...string) (int64, error)
+	SendGroup(string, string, ...string) (int64, error)
 	SendDbus(string, string, ...string) (int64, error)
 	SendGroupDbus(string, string, ...string) (int64, error)
+	SendStyled(signal.Recipient, string, []*signal.TextStyle, ...string) (int64, error)
+	SendReply(signal.Recipient, string, []*signal.TextStyle, *signal.Quote, ...string) (int64, error)
+	SendReaction(signal.Recipient, *signal.Reaction) (int64, error)
+	RemoteDelete(signal.Recipient, int64) (int64, error)
+	UpdateGroup(signal.Recipient, ...string) (int64, error)
 	Receive() error
 	ReceiveForever()
 	Close()
@@ -426,60 +901,167 @@ type Siggo struct {
 	conversations map[*Contact]*Conversation
 	contactOrder  []*Contact
 	signal        SignalAPI
+	// noDaemon sends by invoking signal-cli directly instead of going through the dbus daemon
+	noDaemon bool
+	// offline is for processing recorded messages, nothing is sent and nobody is notified
+	offline bool
+	outbox  *Outbox
+	// retrying is set once we start automatically retrying failed messages
+	retrying bool
+	// store is where conversations are saved, nil if message saving is disabled
+	store Store
+	// receipts holds receipts for messages we haven't seen yet
+	receipts *receiptBuffer
+	// archive keeps copies of attachments, nil if archiving is disabled
+	archive *AttachmentArchive
+	// archiving counts the messages whose attachments are being archived in the background
+	archiving sync.WaitGroup
+	// index is for searching messages, it is built the first time we search
+	index   *SearchIndex
+	indexMu sync.Mutex
+	// convMu is held while conversations are changed or saved outside the UI: by the handlers for
+	// what signal-cli sends us, by sends and reactions finishing in the background, and by saves
+	convMu       sync.Mutex
+	stopAutosave chan struct{}
+	// theme is the configured theme, loaded once rather than for every notification
+	theme *Theme
 
 	NewInfo    func(*Conversation)
 	ErrorEvent func(error)
+	// Queue runs `f` on the goroutine that owns the conversations. The UI replaces it so that
+	// autosaves happen on its event loop instead of while it is using them.
+	Queue func(f func())
 }
 
-// Send sends a message to a contact.
+// Send sends a message to a contact. The message goes into the conversation right away as pending,
+// and stays in the outbox until it is sent successfully.
 func (s *Siggo) Send(msg string, contact *Contact) error {
-	ts := time.Now().Unix() * 1000
+	var styles []*TextStyle
+	if s.config.TextFormatting {
+		msg, styles = ParseMarkup(msg)
+	}
 	message := &Message{
 		Content:     msg,
+		Styles:      styles,
 		From:        " ~ ",
-		Timestamp:   ts,
+		Timestamp:   time.Now().UnixNano() / int64(time.Millisecond),
 		IsDelivered: false,
 		IsRead:      false,
 		FromSelf:    true,
+		Author:      s.config.UserNumber,
+		IsPending:   true,
 		Attachments: make([]*Attachment, 0),
 	}
-	conv, ok := s.conversations[contact]
-	if !ok {
-		log.Infof("new conversation for contact: %v", contact)
-		conv = s.newConversation(contact)
+	s.convMu.Lock()
+	conv := s.Conversation(contact)
+	attachments := conv.stagedAttachments
+	message.AddAttachments(attachments)
+	if reply := conv.stagedReply; reply != nil {
+		message.Quote = &Quote{Author: reply.Author, Timestamp: reply.Timestamp, Text: reply.Content}
+	}
+	conv.CaughtUp()
+	conv.ClearStaged()
+	s.archiveAttachments(conv, message)
+	conv.AddMessage(message)
+	s.convMu.Unlock()
+	entry := &OutboxEntry{
+		Contact:     contact.Number,
+		IsGroup:     contact.isGroup,
+		Message:     message,
+		Attachments: attachments,
+	}
+	s.outbox.Add(entry)
+	s.saveOutbox()
+	return s.attemptSend(entry, contact, conv)
+}
+
+func (s *Siggo) send(contact *Contact, msg string, styles []*TextStyle, quote *Quote, attachments ...string) (int64, error) {
+	if s.offline {
+		return 0, fmt.Errorf("can't send while offline")
+	}
+	if quote != nil {
+		log.Debugf("sending reply to %v", contact)
+		q := &signal.Quote{ID: quote.Timestamp, Author: quote.Author, Text: quote.Text}
+		return s.signal.SendReply(s.recipient(contact), msg, wireTextStyles(styles), q, attachments...)
+	}
+	if len(styles) > 0 {
+		log.Debugf("sending styled message to %v", contact)
+		return s.signal.SendStyled(s.recipient(contact), msg, wireTextStyles(styles), attachments...)
 	}
-	// finally send the message
-	var ID int64
-	var err error
 	if !contact.isGroup {
 		log.Debugf("sending message to contact: %v", contact)
-		ID, err = s.signal.SendDbus(contact.Number, msg, conv.stagedAttachments...)
-	} else {
-		log.Debugf("sending message to group: %v", contact)
-		ID, err = s.signal.SendGroupDbus(contact.Number, msg, conv.stagedAttachments...)
+		if s.noDaemon {
+			return s.signal.Send(contact.Number, msg, attachments...)
+		}
+		return s.signal.SendDbus(contact.Number, msg, attachments...)
 	}
-	if err != nil {
-		message.Content = fmt.Sprintf("FAILED TO SEND: %s ERROR: %v", message.Content, err)
-		s.NewInfo(conv)
-		return err
+	log.Debugf("sending message to group: %v", contact)
+	if s.noDaemon {
+		return s.signal.SendGroup(contact.Number, msg, attachments...)
 	}
-	// use the official timestamp on success
-	message.Timestamp = ID
-	conv.CaughtUp()
-	message.AddAttachments(conv.stagedAttachments)
-	conv.ClearStaged()
-	conv.AddMessage(message)
-	s.NewInfo(conv)
-	log.Infof("successfully sent message %s with timestamp: %d", message.Content, message.Timestamp)
-	return nil
+	return s.signal.SendGroupDbus(contact.Number, msg, attachments...)
+}
+
+// SetDaemon chooses whether messages are sent through a running signal-cli daemon (the default)
+// or by invoking signal-cli directly, which is slower but doesn't need the daemon.
+func (s *Siggo) SetDaemon(daemon bool) {
+	s.noDaemon = !daemon
+}
+
+// SetOffline puts siggo in offline mode, for processing recorded messages. Nothing is sent, there
+// are no notifications and conversations are only saved when SaveConversations is called.
+func (s *Siggo) SetOffline(offline bool) {
+	s.offline = offline
+}
+
+// OpenConversation is Conversation for anywhere siggo could be receiving at the same time, like the
+// UI. It holds convMu while it finds or creates the conversation.
+func (s *Siggo) OpenConversation(contact *Contact) *Conversation {
+	s.convMu.Lock()
+	defer s.convMu.Unlock()
+	return s.Conversation(contact)
+}
+
+// Conversation returns the conversation for a contact, creating a new one if there isn't one. The
+// caller holds convMu, unless nothing else is using siggo yet.
+func (s *Siggo) Conversation(contact *Contact) *Conversation {
+	conv, ok := s.conversations[contact]
+	if !ok {
+		log.Infof("new conversation for contact: %v", contact)
+		conv = s.newConversation(contact)
+	}
+	return conv
 }
 
 func (s *Siggo) newConversation(contact *Contact) *Conversation {
 	conv := NewConversation(contact)
+	conv.config = s.config
+	if s.store != nil {
+		// we may have talked before they were a contact
+		if err := conv.Load(s.store, s.config); err != nil && !os.IsNotExist(err) {
+			log.Errorf("failed to load conversation with %v: %v", contact, err)
+		}
+	}
 	s.conversations[contact] = conv
 	return conv
 }
 
+// LoadOlder pages older saved messages of a conversation in from the store, MaxConversationLength
+// at a time (or all of them if that isn't set). Returns the number of messages loaded.
+func (s *Siggo) LoadOlder(conv *Conversation) (int, error) {
+	s.convMu.Lock()
+	defer s.convMu.Unlock()
+	return s.loadOlder(conv)
+}
+
+// loadOlder is LoadOlder for a caller that holds convMu
+func (s *Siggo) loadOlder(conv *Conversation) (int, error) {
+	if s.store == nil {
+		return 0, nil
+	}
+	return conv.LoadOlder(s.store, s.config.MaxConversationLength)
+}
+
 func (s *Siggo) newContact(number string) *Contact {
 	contact := &Contact{
 		Number: number,
@@ -506,6 +1088,12 @@ func (s *Siggo) onSent(msg *signal.Message) error {
 	// add new message to conversation
 	sentMsg := msg.Envelope.SyncMessage.SentMessage
 
+	if sentMsg.Reaction != nil {
+		return s.onReaction(s.config.UserNumber, sentMsg.Reaction)
+	}
+	if sentMsg.RemoteDelete != nil {
+		return s.onRemoteDelete(s.config.UserNumber, sentMsg.RemoteDelete.Timestamp)
+	}
 	if sentMsg.GroupInfo != nil {
 		return s.onGroupMessageSent(msg)
 	}
@@ -528,21 +1116,34 @@ func (s *Siggo) onSent(msg *signal.Message) error {
 		IsDelivered: false,
 		IsRead:      false,
 		FromSelf:    true,
+		Author:      s.config.UserNumber,
 		Attachments: ConvertAttachments(sentMsg.Attachments, sentMsg.Timestamp, true),
+		Quote:       convertQuote(sentMsg.Quote),
+		Styles:      convertTextStyles(sentMsg.TextStyles),
+		Previews:    convertPreviews(sentMsg.Previews, sentMsg.Message, sentMsg.Timestamp, true),
 	}
 	conv, ok := s.conversations[c]
 	if !ok {
 		log.Infof("new conversation for contact: %v", c)
 		conv = s.newConversation(c)
 	}
+	s.applyBufferedReceipts(message)
+	s.archiveAttachments(conv, message)
 	conv.AddMessage(message)
 	s.NewInfo(conv)
+	s.journal(conv)
 	return nil
 }
 
 func (s *Siggo) onReceived(msg *signal.Message) error {
 	// add new message to conversation
 	receiveMsg := msg.Envelope.DataMessage
+	if receiveMsg.Reaction != nil {
+		return s.onReaction(msg.Envelope.Source, receiveMsg.Reaction)
+	}
+	if receiveMsg.RemoteDelete != nil {
+		return s.onRemoteDelete(msg.Envelope.Source, receiveMsg.RemoteDelete.Timestamp)
+	}
 	if receiveMsg.GroupInfo != nil {
 		return s.onGroupMessageReceived(msg)
 	}
@@ -573,60 +1174,66 @@ func (s *Siggo) onReceived(msg *signal.Message) error {
 		IsDelivered: true,
 		IsRead:      false,
 		Attachments: ConvertAttachments(receiveMsg.Attachments, receiveMsg.Timestamp, false),
+		Quote:       convertQuote(receiveMsg.Quote),
+		Styles:      convertTextStyles(receiveMsg.TextStyles),
+		Previews:    convertPreviews(receiveMsg.Previews, receiveMsg.Message, receiveMsg.Timestamp, false),
 		FromContact: c,
+		Author:      c.Number,
 	}
 	conv, ok := s.conversations[c]
 	if !ok {
 		log.Infof("new conversation for contact: %v", c)
 		conv = s.newConversation(c)
 	}
+	s.archiveAttachments(conv, message)
 	conv.AddMessage(message)
 	s.NewInfo(conv)
-	s.sendNotification(c.String(), message.Content, c.Avatar())
+	s.journal(conv)
+	s.sendNotification(c.String(), message, c.Avatar())
 	return nil
 }
 
 func (s *Siggo) onReceipt(msg *signal.Message) error {
 	receiptMsg := msg.Envelope.ReceiptMessage
-	// if the message exists, edit it with new data
-	contactNumber := msg.Envelope.Source
-	// if we have a name for this contact, use it
-	// otherwise it will be the phone number
-	c, ok := s.contacts[contactNumber]
-	if !ok {
-		c = s.newContact(contactNumber)
-	}
-	conv, ok := s.conversations[c]
-	if !ok {
-		conv = s.newConversation(c)
+	// receipts are always for messages we sent, from whoever we sent them to. for groups that
+	// means a member of the group, so the message may not be in their conversation.
+	var hint *Conversation
+	if c, ok := s.contacts[msg.Envelope.Source]; ok {
+		hint = s.conversations[c]
 	}
 	for _, ts := range receiptMsg.Timestamps {
-		message, ok := conv.Messages[ts]
-		if !ok {
-			// TODO: handle case where we get a read receipt for
-			// a message that we don't have
-			b, err := json.Marshal(msg)
-			if err != nil {
-				log.Warnf("couldn't marshal receipt for message we don't have: %v", err)
-			}
-			log.Warnf("read receipt for message we don't have: %s", b)
+		key := MessageKey{Author: s.config.UserNumber, Timestamp: ts}
+		message, conv := s.findMessage(key, hint)
+		if message == nil {
+			// we can get receipts before the message itself, for example before signal-cli
+			// tells us the timestamp of a message we just sent. hang on to them for later.
+			log.Debugf("buffering receipt for message we don't have yet: %s", key)
+			s.receipts.add(key, receiptMsg.IsDelivery, receiptMsg.IsRead)
 			continue
 		}
-		if receiptMsg.IsRead {
-			// for whatever reason messages can be marked as
-			// read but not delivered, so we go ahead and assume any
-			// message that has been read has also been delivered
-			message.IsDelivered = true
-			message.IsRead = true
-		} else {
-			message.IsDelivered = receiptMsg.IsDelivery
-			message.IsRead = receiptMsg.IsRead
-		}
+		message.applyReceipt(receiptMsg.IsDelivery, receiptMsg.IsRead)
+		conv.markChanged(key)
+		s.NewInfo(conv)
 	}
-	conv.hasNewData = true
 	return nil
 }
 
+// findMessage looks for a message, starting with the `hint` conversation (which can be nil).
+// Returns nils if we don't have it.
+func (s *Siggo) findMessage(key MessageKey, hint *Conversation) (*Message, *Conversation) {
+	if hint != nil {
+		if message, ok := hint.Messages[key]; ok {
+			return message, hint
+		}
+	}
+	for _, conv := range s.conversations {
+		if message, ok := conv.Messages[key]; ok {
+			return message, conv
+		}
+	}
+	return nil, nil
+}
+
 func (s *Siggo) onGroupMessageReceived(msg *signal.Message) error {
 	// add new message to conversation
 	receiveMsg := msg.Envelope.DataMessage
@@ -668,7 +1275,11 @@ func (s *Siggo) onGroupMessageReceived(msg *signal.Message) error {
 		IsDelivered: true,
 		IsRead:      false,
 		Attachments: ConvertAttachments(receiveMsg.Attachments, receiveMsg.Timestamp, false),
+		Quote:       convertQuote(receiveMsg.Quote),
+		Styles:      convertTextStyles(receiveMsg.TextStyles),
+		Previews:    convertPreviews(receiveMsg.Previews, receiveMsg.Message, receiveMsg.Timestamp, false),
 		FromContact: c,
+		Author:      c.Number,
 	}
 
 	conv, ok := s.conversations[g]
@@ -676,9 +1287,11 @@ func (s *Siggo) onGroupMessageReceived(msg *signal.Message) error {
 		log.Infof("new conversation for group: %v", g)
 		conv = s.newConversation(g)
 	}
+	s.archiveAttachments(conv, message)
 	conv.AddMessage(message)
 	s.NewInfo(conv)
-	s.sendNotification(g.String(), message.Content, c.Avatar())
+	s.journal(conv)
+	s.sendNotification(g.String(), message, c.Avatar())
 	return nil
 }
 
@@ -718,7 +1331,11 @@ func (s *Siggo) onGroupMessageSent(msg *signal.Message) error {
 		IsRead:      false,
 		FromSelf:    true,
 		Attachments: ConvertAttachments(sentMsg.Attachments, sentMsg.Timestamp, false),
+		Quote:       convertQuote(sentMsg.Quote),
+		Styles:      convertTextStyles(sentMsg.TextStyles),
+		Previews:    convertPreviews(sentMsg.Previews, sentMsg.Message, sentMsg.Timestamp, true),
 		FromContact: c,
+		Author:      s.config.UserNumber,
 	}
 
 	conv, ok := s.conversations[g]
@@ -726,20 +1343,28 @@ func (s *Siggo) onGroupMessageSent(msg *signal.Message) error {
 		log.Infof("new conversation for group: %v", g)
 		conv = s.newConversation(g)
 	}
+	s.applyBufferedReceipts(message)
+	s.archiveAttachments(conv, message)
 	conv.AddMessage(message)
 	s.NewInfo(conv)
+	s.journal(conv)
 	return nil
 }
 
-func (s *Siggo) sendNotification(title, content, iconPath string) {
+// sendNotification tells us about a new message, keeping its spoilers hidden
+func (s *Siggo) sendNotification(title string, msg *Message, iconPath string) {
+	if s.offline {
+		return
+	}
 	if s.config.TerminalBellNotifications {
 		fmt.Print("\a")
 	}
 	if !s.config.DesktopNotifications {
 		return
 	}
-	if !s.config.DesktopNotificationsShowMessage {
-		content = ""
+	content := ""
+	if s.config.DesktopNotificationsShowMessage {
+		content = msg.MaskedText(s.SpoilerGlyph())
 	}
 	if !s.config.DesktopNotificationsShowAvatar {
 		iconPath = ""
@@ -750,6 +1375,24 @@ func (s *Siggo) sendNotification(title, content, iconPath string) {
 	}
 }
 
+// loadTheme loads the configured theme, or the default theme if it can't be
+func (s *Siggo) loadTheme() {
+	theme, err := LoadTheme(s.config.Theme)
+	if err != nil {
+		log.Errorf("failed to load theme: %v", err)
+		theme = DefaultTheme()
+	}
+	s.theme = theme
+}
+
+// SpoilerGlyph returns the glyph spoilers are hidden behind in the configured theme
+func (s *Siggo) SpoilerGlyph() string {
+	if s.theme == nil {
+		return DefaultTheme().SpoilerGlyph
+	}
+	return s.theme.SpoilerGlyph
+}
+
 // Conversations returns the current converstation book
 func (s *Siggo) Conversations() map[*Contact]*Conversation {
 	return s.conversations
@@ -767,48 +1410,138 @@ func (s *Siggo) Config() Config {
 
 // SaveConversations saves all conversations to disk
 func (s *Siggo) SaveConversations() {
+	if s.store == nil {
+		return
+	}
+	s.convMu.Lock()
+	defer s.convMu.Unlock()
 	for _, conv := range s.conversations {
-		err := conv.Save()
+		err := conv.Save(s.store)
 		if err != nil {
 			log.Errorf("failed to save conversation: %v", err)
 		}
 	}
 }
 
-// Quit does any cleanup we want to do at exit.
+// journal saves a conversation as soon as a message arrives, so that it isn't lost if we crash.
+// New messages are only appended, so this is cheap. The caller holds convMu.
+func (s *Siggo) journal(conv *Conversation) {
+	if s.store == nil || s.offline {
+		return
+	}
+	if err := conv.Save(s.store); err != nil {
+		log.Errorf("failed to save conversation: %v", err)
+	}
+}
+
+// StartAutosave saves every conversation with unsaved changes (receipts, for example) every
+// AutosaveInterval seconds, until we quit. The saves are handed to Queue.
+func (s *Siggo) StartAutosave() {
+	if s.store == nil || s.config.AutosaveInterval <= 0 || s.stopAutosave != nil {
+		return
+	}
+	s.stopAutosave = make(chan struct{})
+	ticker := time.NewTicker(time.Duration(s.config.AutosaveInterval) * time.Second)
+	go func(stop chan struct{}) {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Queue(s.SaveConversations)
+			case <-stop:
+				return
+			}
+		}
+	}(s.stopAutosave)
+}
+
 func (s *Siggo) Quit() {
+	if s.stopAutosave != nil {
+		close(s.stopAutosave)
+		s.stopAutosave = nil
+	}
 	if s.config.SaveMessages {
 		s.SaveConversations()
 	}
+	if s.store != nil {
+		if err := s.store.Close(); err != nil {
+			log.Errorf("failed to close message store: %v", err)
+		}
+	}
 	s.signal.Close() // kills the signal-cli daemon
 }
 
 // NewSiggo creates a new model
 func NewSiggo(sig SignalAPI, config *Config) *Siggo {
+	// our number is part of the key of every message we send, so make sure it is consistent
+	if config.UserNumber != "" && !strings.HasPrefix(config.UserNumber, "+") {
+		config.UserNumber = fmt.Sprintf("+%s", config.UserNumber)
+	}
 	s := &Siggo{
 		config: config,
 		signal: sig,
+		outbox: NewOutbox(OutboxPath(), config.Cipher()),
+
+		receipts: newReceiptBuffer(),
 
 		NewInfo:    func(*Conversation) {}, // noop
 		ErrorEvent: func(error) {},         // noop
+		Queue:      func(f func()) { f() },
 	}
 	s.init()
 	//sig.OnMessage(s.?)
 
-	sig.OnSent(s.onSent)
-	sig.OnReceived(s.onReceived)
-	sig.OnReceipt(s.onReceipt)
+	sig.OnSent(s.locked(s.onSent))
+	sig.OnReceived(s.locked(s.onReceived))
+	sig.OnReceipt(s.locked(s.onReceipt))
 	sig.OnError(s.handleError)
 	return s
 }
 
+// locked wraps a signal-cli callback so that it holds convMu while it runs
+func (s *Siggo) locked(callback func(*signal.Message) error) func(*signal.Message) error {
+	return func(msg *signal.Message) error {
+		s.convMu.Lock()
+		defer s.convMu.Unlock()
+		return callback(msg)
+	}
+}
+
 func (s *Siggo) init() {
+	s.loadTheme()
 	//load contacts and conversations for the first time
 	s.contacts = s.getContacts()
 	if self, ok := s.contacts[s.config.UserNumber]; ok {
 		self.Name = s.config.UserName
 	}
+	if s.config.Locked() {
+		// never write anything in the clear when we are supposed to be encrypting it
+		log.Errorf("message history is locked, messages won't be loaded or saved")
+		s.outbox = NewOutbox("", nil)
+	} else if s.config.SaveMessages {
+		store, err := OpenStore(s.config.MessageStore, s.config.Cipher())
+		if err != nil {
+			log.Errorf("failed to open message store, messages won't be saved: %v", err)
+		} else {
+			s.store = store
+		}
+	}
+	if s.config.ArchiveAttachments && s.config.EncryptMessages {
+		log.Warnf("attachments aren't archived when messages are encrypted")
+	} else if s.config.ArchiveAttachments && s.store != nil {
+		s.archive = NewAttachmentArchive(AttachmentFolder(),
+			int64(s.config.AttachmentMaxSize)*1024*1024,
+			time.Duration(s.config.AttachmentMaxAge)*24*time.Hour)
+		go func() {
+			if n, err := s.archive.Prune(); err != nil {
+				log.Errorf("failed to prune attachment archive: %v", err)
+			} else if n > 0 {
+				log.Infof("pruned %d attachments from the archive", n)
+			}
+		}()
+	}
 	s.conversations = s.getConversations()
+	s.loadOutbox()
 }
 
 // getContacts reads a fresh contact list from disk for the configured user
@@ -825,15 +1558,21 @@ func (s *Siggo) getContacts() ContactList {
 	}
 	for _, c := range contacts {
 		if c.InboxPosition != nil {
+			name := c.Name
+			if name == "" {
+				// no name in our address book, use the name they gave their profile
+				name = c.ProfileName
+			}
 			alias := ""
 			if s.config.ContactAliases != nil {
-				alias = s.config.ContactAliases[c.Name]
+				alias = s.config.ContactAliases[name]
 			}
 			// check if we have a color for this contact
-			color := s.config.ContactColors[c.Name]
+			color := s.config.ContactColors[name]
 			contact := &Contact{
 				Number: c.Number,
-				Name:   c.Name,
+				Name:   name,
+				UUID:   c.UUID,
 				Index:  *c.InboxPosition,
 				alias:  alias,
 				color:  color,
@@ -877,9 +1616,8 @@ func (s *Siggo) getConversations() map[*Contact]*Conversation {
 		log.Debugf("Adding conversation for: %+v\n", contact)
 		conv := NewConversation(contact)
 		// check if we have a conversation file for this contact
-		if s.config.SaveMessages {
-			convPath := filepath.Join(ConversationFolder(), contact.Number)
-			err := conv.Load(convPath, s.config) // if we fail to load, oh well
+		if s.store != nil {
+			err := conv.Load(s.store, s.config) // if we fail to load, oh well
 			if err == nil {
 				log.Infof("loaded conversation from: %s", contact.Name)
 			}
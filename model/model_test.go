@@ -0,0 +1,146 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/derricw/siggo/signal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSiggo() *Siggo {
+	cfg := DefaultConfig()
+	cfg.UserNumber = "+15559999999"
+	return &Siggo{
+		config:        cfg,
+		contacts:      make(ContactList),
+		conversations: make(map[*Contact]*Conversation),
+		outbox:        NewOutbox("", nil),
+		receipts:      newReceiptBuffer(),
+		NewInfo:       func(*Conversation) {},
+		ErrorEvent:    func(error) {},
+	}
+}
+
+func TestGroupMessagesSameTimestamp(t *testing.T) {
+	s := newTestSiggo()
+	for _, source := range []string{"+15550000001", "+15550000002"} {
+		err := s.onReceived(&signal.Message{Envelope: &signal.Envelope{
+			Source: source,
+			DataMessage: &signal.DataMessage{
+				Timestamp: 1000,
+				Message:   "multipass",
+				GroupInfo: &signal.GroupInfo{GroupID: "Z3JvdXA=", Name: "Fifth Element"},
+			},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	conv := s.conversations[s.contacts["Z3JvdXA="]]
+	assert.Len(t, conv.MessageOrder, 2)
+	assert.Equal(t, "+15550000002", conv.LastMessage().Author)
+}
+
+func TestReceiptBeforeMessage(t *testing.T) {
+	s := newTestSiggo()
+	receipt := &signal.Message{Envelope: &signal.Envelope{
+		Source: "+15550000001",
+		ReceiptMessage: &signal.ReceiptMessage{
+			IsDelivery: true,
+			Timestamps: []int64{1000},
+		},
+	}}
+	if err := s.onReceipt(receipt); err != nil {
+		t.Fatal(err)
+	}
+	sent := &signal.Message{Envelope: &signal.Envelope{
+		Source: s.config.UserNumber,
+		SyncMessage: &signal.SyncMessage{SentMessage: &signal.SentMessage{
+			Timestamp:   1000,
+			Message:     "big badaboom",
+			Destination: "+15550000001",
+		}},
+	}}
+	if err := s.onSent(sent); err != nil {
+		t.Fatal(err)
+	}
+	conv := s.conversations[s.contacts["+15550000001"]]
+	assert.True(t, conv.LastMessage().IsDelivered)
+	assert.False(t, conv.LastMessage().IsRead)
+}
+
+func TestMigrateMessage(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.UserNumber = "+15559999999"
+	contact := &Contact{Number: "+15550000001"}
+	conv := NewConversation(contact)
+
+	self := &Message{Timestamp: 1, FromSelf: true}
+	conv.migrateMessage(self, cfg)
+	assert.Equal(t, cfg.UserNumber, self.Author)
+
+	old := &Message{Timestamp: 1}
+	conv.migrateMessage(old, cfg)
+	assert.Equal(t, contact.Number, old.Author)
+}
+
+func TestImport(t *testing.T) {
+	s := newTestSiggo()
+	sig := signal.NewSignal(s.config.UserNumber)
+	sig.OnSent(s.onSent)
+	sig.OnReceived(s.onReceived)
+	sig.OnReceipt(s.onReceipt)
+	s.SetOffline(true)
+	recorded := `{"envelope":{"source":"+15550000001","timestamp":1000,"dataMessage":{"timestamp":1000,"message":"multipass"}}}
+not json
+{"envelope":{"source":"+15559999999","timestamp":2000,"syncMessage":{"sentMessage":{"timestamp":2000,"message":"big badaboom","destination":"+15550000001"}}}}
+{"envelope":{"source":"+15550000001","timestamp":3000,"receiptMessage":{"isDelivery":true,"timestamps":[2000]}}}
+`
+	stats, skipped, err := s.Import(strings.NewReader(recorded), sig.ProcessWire)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, skipped)
+	contact := s.contacts["+15550000001"]
+	assert.Equal(t, &ImportStats{Messages: 2, Receipts: 1}, stats[contact])
+
+	// importing again doesn't duplicate anything
+	stats, _, err = s.Import(strings.NewReader(recorded), sig.ProcessWire)
+	assert.NoError(t, err)
+	assert.Empty(t, stats)
+	assert.Len(t, s.conversations[contact].MessageOrder, 2)
+
+	// older messages go before the ones we have, not at the end
+	older := `{"envelope":{"source":"+15550000001","timestamp":500,"dataMessage":{"timestamp":500,"message":"leeloo"}}}`
+	_, _, err = s.Import(strings.NewReader(older), sig.ProcessWire)
+	assert.NoError(t, err)
+	order := s.conversations[contact].MessageOrder
+	if assert.Len(t, order, 3) {
+		assert.Equal(t, int64(500), order[0].Timestamp)
+		assert.Equal(t, int64(2000), order[2].Timestamp)
+	}
+}
+
+func TestFuzzyFind(t *testing.T) {
+	cl := ContactList{
+		"+15550000001": {Number: "+15550000001", Name: "Leeloo Dallas", Index: 0},
+		"+15550000002": {Number: "+15550000002", Name: "Korben Dallas", Index: 1, alias: "Major"},
+		"+15550000003": {Number: "+15550000003", Name: "Ruby Rhod", Index: 2},
+		"Z3JvdXA=":     {Number: "Z3JvdXA=", Name: "Fifth Element", Index: 3, isGroup: true},
+	}
+	names := func(contacts []*Contact) []string {
+		out := make([]string, 0, len(contacts))
+		for _, c := range contacts {
+			out = append(out, c.Name)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"Leeloo Dallas", "Korben Dallas", "Ruby Rhod", "Fifth Element"},
+		names(cl.FuzzyFind("")))
+	// word starts beat letters in the middle of a word
+	assert.Equal(t, []string{"Fifth Element", "Leeloo Dallas", "Korben Dallas"},
+		names(cl.FuzzyFind("el")))
+	assert.Equal(t, []string{"Korben Dallas"}, names(cl.FuzzyFind("maj")))
+	assert.Equal(t, []string{"Ruby Rhod"}, names(cl.FuzzyFind("0003")))
+	assert.Equal(t, []string{"Fifth Element"}, names(cl.FuzzyFind("#fifth")))
+	assert.Empty(t, cl.FuzzyFind("zorg"))
+}
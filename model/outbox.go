@@ -0,0 +1,298 @@
+package model
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxSendAttempts is how many times we automatically try to send a message before giving up
+	// and waiting for the user to retry manually
+	maxSendAttempts = 8
+	// firstRetryDelay is how long we wait before the first automatic retry. It doubles every
+	// attempt after that.
+	firstRetryDelay = 5 * time.Second
+	maxRetryDelay   = 10 * time.Minute
+)
+
+// retryDelay returns how long to wait before the next attempt, given how many attempts were made
+func retryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+	return delay
+}
+
+// OutboxEntry is an outgoing message that hasn't been sent successfully yet
+type OutboxEntry struct {
+	Contact     PhoneNumber `json:"contact"`
+	IsGroup     bool        `json:"is_group"`
+	Message     *Message    `json:"message"`
+	Attachments []string    `json:"attachments"`
+	Attempts    int         `json:"attempts"`
+	LastError   string      `json:"last_error"`
+
+	timer *time.Timer
+}
+
+// stopRetry cancels any scheduled automatic retry
+func (e *OutboxEntry) stopRetry() {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+}
+
+// Outbox holds outgoing messages until they are sent. Messages that fail to send stay here (and
+// visible in their conversation) until they are retried successfully. The outbox is written to
+// disk on every change so that unsent messages survive a restart.
+type Outbox struct {
+	mu      sync.Mutex
+	entries []*OutboxEntry
+	path    string
+	cipher  *Cipher
+}
+
+// Add puts a new message in the outbox
+func (o *Outbox) Add(entry *OutboxEntry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.entries = append(o.entries, entry)
+}
+
+// Remove takes a message out of the outbox, usually because it was sent
+func (o *Outbox) Remove(entry *OutboxEntry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, e := range o.entries {
+		if e == entry {
+			entry.stopRetry()
+			o.entries = append(o.entries[:i], o.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Entries returns the messages currently in the outbox, oldest first
+func (o *Outbox) Entries() []*OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := make([]*OutboxEntry, len(o.entries))
+	copy(out, o.entries)
+	return out
+}
+
+// Len returns the number of messages in the outbox
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Save writes the outbox to disk. An empty outbox removes the file.
+func (o *Outbox) Save() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.path == "" {
+		return nil
+	}
+	if len(o.entries) == 0 {
+		err := os.Remove(o.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(o.path), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(o.path, 0600, func(f *os.File) error {
+		for _, entry := range o.entries {
+			line, err := encodeRecord(entry, o.cipher)
+			if err != nil {
+				return err
+			}
+			f.WriteString(line)
+			f.Write([]byte{'\n'})
+		}
+		return nil
+	})
+}
+
+// Load reads the outbox from disk. It is not an error for there to be no outbox file.
+func (o *Outbox) Load() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.path == "" {
+		return nil
+	}
+	f, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		entry := &OutboxEntry{}
+		if err := decodeRecord(s.Text(), entry, o.cipher); err != nil {
+			return err
+		}
+		if entry.Message == nil {
+			continue
+		}
+		o.entries = append(o.entries, entry)
+	}
+	return s.Err()
+}
+
+// NewOutbox creates an empty outbox that is saved @ `path`, encrypted with `c` if it is not nil.
+// An outbox without a path is never saved.
+func NewOutbox(path string, c *Cipher) *Outbox {
+	return &Outbox{
+		entries: make([]*OutboxEntry, 0),
+		path:    path,
+		cipher:  c,
+	}
+}
+
+// attemptSend tries to send a message from the outbox once. On failure the message is marked as
+// failed and, if we are retrying automatically, another attempt is scheduled.
+func (s *Siggo) attemptSend(entry *OutboxEntry, contact *Contact, conv *Conversation) error {
+	message := entry.Message
+	s.convMu.Lock()
+	if message.IsDeleted {
+		// deleted while it waited, so it mustn't go out
+		s.unqueue(message)
+		s.convMu.Unlock()
+		return nil
+	}
+	entry.Attempts++
+	message.IsPending = true
+	message.IsFailed = false
+	s.convMu.Unlock()
+	s.NewInfo(conv)
+
+	ID, err := s.send(contact, message.Content, message.Styles, message.Quote, entry.Attachments...)
+	s.convMu.Lock()
+	defer s.convMu.Unlock()
+	message.IsPending = false
+	if err != nil {
+		log.Errorf("failed to send message (attempt %d): %v", entry.Attempts, err)
+		message.IsFailed = true
+		entry.LastError = err.Error()
+		s.saveOutbox()
+		s.scheduleRetry(entry, contact, conv)
+		s.NewInfo(conv)
+		return err
+	}
+	s.outbox.Remove(entry)
+	s.saveOutbox()
+	// use the official timestamp on success
+	conv.updateTimestamp(message, ID)
+	s.applyBufferedReceipts(message)
+	s.NewInfo(conv)
+	s.journal(conv)
+	log.Infof("successfully sent message %s with timestamp: %d", message.Content, message.Timestamp)
+	return nil
+}
+
+// scheduleRetry schedules the next automatic attempt to send a failed message
+func (s *Siggo) scheduleRetry(entry *OutboxEntry, contact *Contact, conv *Conversation) {
+	if !s.retrying || entry.Attempts >= maxSendAttempts {
+		return
+	}
+	delay := retryDelay(entry.Attempts)
+	log.Infof("retrying message to %v in %s", contact, delay)
+	entry.stopRetry()
+	entry.timer = time.AfterFunc(delay, func() {
+		s.attemptSend(entry, contact, conv)
+	})
+}
+
+// RetryOutbox starts automatically retrying any failed messages with backoff, including those left
+// over from a previous session. Until this is called, failed messages just wait in the outbox.
+func (s *Siggo) RetryOutbox() {
+	s.retrying = true
+	for _, entry := range s.outbox.Entries() {
+		if entry.Message.IsFailed {
+			contact := s.outboxContact(entry)
+			s.scheduleRetry(entry, contact, s.OpenConversation(contact))
+		}
+	}
+}
+
+// RetryFailed immediately retries every failed message to `contact`. Automatic retries start over
+// from the first backoff step. Returns the number of messages retried.
+func (s *Siggo) RetryFailed(contact *Contact) int {
+	n := 0
+	for _, entry := range s.outbox.Entries() {
+		if entry.Contact != contact.Number || !entry.Message.IsFailed {
+			continue
+		}
+		entry.stopRetry()
+		entry.Attempts = 0
+		conv := s.OpenConversation(contact)
+		go s.attemptSend(entry, contact, conv)
+		n++
+	}
+	return n
+}
+
+// Outbox returns the messages that haven't been sent yet
+func (s *Siggo) Outbox() *Outbox {
+	return s.outbox
+}
+
+// unqueue takes `message` out of the outbox and stops any retries of it. The caller holds convMu.
+func (s *Siggo) unqueue(message *Message) {
+	for _, entry := range s.outbox.Entries() {
+		if entry.Message == message {
+			entry.stopRetry()
+			s.outbox.Remove(entry)
+			s.saveOutbox()
+		}
+	}
+}
+
+func (s *Siggo) saveOutbox() {
+	if err := s.outbox.Save(); err != nil {
+		log.Errorf("failed to save outbox: %v", err)
+	}
+}
+
+// outboxContact finds the contact an outbox entry is addressed to
+func (s *Siggo) outboxContact(entry *OutboxEntry) *Contact {
+	if c, ok := s.contacts[entry.Contact]; ok {
+		return c
+	}
+	c := s.newContact(entry.Contact)
+	c.isGroup = entry.IsGroup
+	return c
+}
+
+// loadOutbox reads unsent messages from disk and puts them back into their conversations. Anything
+// that was still in flight when we last quit is treated as failed.
+func (s *Siggo) loadOutbox() {
+	if err := s.outbox.Load(); err != nil {
+		log.Errorf("failed to load outbox: %v", err)
+		return
+	}
+	for _, entry := range s.outbox.Entries() {
+		entry.Message.IsPending = false
+		entry.Message.IsFailed = true
+		entry.Message.Author = s.config.UserNumber
+		conv := s.Conversation(s.outboxContact(entry))
+		conv.addMessage(entry.Message)
+	}
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-outbox-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "outbox")
+
+	outbox := NewOutbox(path, nil)
+	entry := &OutboxEntry{
+		Contact:  "+15550000001",
+		Message:  &Message{Content: "multipass", Timestamp: 1, FromSelf: true, IsFailed: true},
+		Attempts: 2,
+	}
+	outbox.Add(entry)
+	if err := outbox.Save(); err != nil {
+		t.Fatalf("failed to save outbox: %v", err)
+	}
+
+	loaded := NewOutbox(path, nil)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("failed to load outbox: %v", err)
+	}
+	assert.Equal(t, 1, loaded.Len())
+	assert.Equal(t, "multipass", loaded.Entries()[0].Message.Content)
+	assert.Equal(t, 2, loaded.Entries()[0].Attempts)
+
+	// an empty outbox removes the file
+	loaded.Remove(loaded.Entries()[0])
+	if err := loaded.Save(); err != nil {
+		t.Fatalf("failed to save empty outbox: %v", err)
+	}
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, firstRetryDelay, retryDelay(1))
+	assert.Equal(t, 2*firstRetryDelay, retryDelay(2))
+	assert.Equal(t, maxRetryDelay, retryDelay(100))
+	assert.True(t, retryDelay(maxSendAttempts) <= 10*time.Minute)
+}
+
+func TestDeleteUnsent(t *testing.T) {
+	s := newTestSiggo()
+	contact := s.newContact("+15550000001")
+	conv := s.Conversation(contact)
+	failed := &Message{Content: "multipass", Timestamp: 1, FromSelf: true, IsFailed: true,
+		Author: s.config.UserNumber}
+	entry := &OutboxEntry{Contact: contact.Number, Message: failed, Attachments: []string{"pass.jpg"}}
+	s.outbox.Add(entry)
+	conv.addMessage(failed)
+
+	// there's no signal to send with, so this fails loudly if anything is sent
+	assert.NoError(t, s.Delete(contact, failed))
+	assert.True(t, failed.IsDeleted)
+	assert.Equal(t, 0, s.outbox.Len())
+	assert.Equal(t, 0, s.RetryFailed(contact))
+
+	// a retry that was already on its way doesn't send it either
+	s.outbox.Add(entry)
+	assert.NoError(t, s.attemptSend(entry, contact, conv))
+	assert.Equal(t, 0, s.outbox.Len())
+	assert.Equal(t, 0, entry.Attempts)
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"net/url"
+
+	"github.com/derricw/siggo/signal"
+)
+
+// Preview is the link preview that came with a message, as the sender's app made it. siggo never
+// fetches previews itself.
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Image is a thumbnail of the page, if there is one
+	Image *Attachment `json:"image,omitempty"`
+}
+
+// Label returns the title of the previewed page, or its URL if it has none
+func (p *Preview) Label() string {
+	if p.Title != "" {
+		return p.Title
+	}
+	return p.URL
+}
+
+// Site returns the host name of the previewed page, like "example.com"
+func (p *Preview) Site() string {
+	u, err := url.Parse(p.URL)
+	if err != nil || u.Host == "" {
+		return p.URL
+	}
+	return u.Host
+}
+
+// convertPreviews converts link previews from signal-cli. Previews are made by the sender, so any
+// of a link that isn't in the message `content` are dropped, rather than show a page nobody sent.
+func convertPreviews(previews []*signal.Preview, content string, timestamp int64, fromSelf bool) []*Preview {
+	if len(previews) == 0 {
+		return nil
+	}
+	links := linkSet(content)
+	out := make([]*Preview, 0, len(previews))
+	for _, p := range previews {
+		if !links[p.URL] {
+			continue
+		}
+		preview := &Preview{URL: p.URL, Title: p.Title, Description: p.Description}
+		if p.Image != nil {
+			preview.Image = NewAttachmentFromWire(p.Image, timestamp, fromSelf)
+		}
+		out = append(out, preview)
+	}
+	return out
+}
+
+// linkSet returns the links in `content`
+func linkSet(content string) map[string]bool {
+	links := make(map[string]bool)
+	for _, link := range linkPattern.FindAllString(content, -1) {
+		links[link] = true
+	}
+	return links
+}
+
+// LinkPreviews returns the previews of links in the message. Any that aren't of one, which could
+// have been saved before they were dropped when converted, are left out.
+func (m *Message) LinkPreviews() []*Preview {
+	if m.IsDeleted || len(m.Previews) == 0 {
+		return nil
+	}
+	links := linkSet(m.Content)
+	out := make([]*Preview, 0, len(m.Previews))
+	for _, p := range m.Previews {
+		if links[p.URL] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Preview returns the preview of `link` that came with the message, nil if there isn't one
+func (m *Message) Preview(link string) *Preview {
+	for _, p := range m.LinkPreviews() {
+		if p.URL == link {
+			return p
+		}
+	}
+	return nil
+}
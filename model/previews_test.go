@@ -0,0 +1,66 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/derricw/siggo/signal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviews(t *testing.T) {
+	wire := `{"envelope": {"source": "+15550000001", "timestamp": 1000, "dataMessage": {
+		"timestamp": 1000,
+		"message": "look https://example.com/multipass and https://example.org",
+		"previews": [
+			{"url": "https://example.com/multipass", "title": "Multipass", "description": "Leeloo Dallas",
+			 "image": {"contentType": "image/jpeg", "id": "abc.jpg", "size": 42}},
+			{"url": "https://example.org", "title": "", "description": ""},
+			{"url": "", "title": "nothing to preview"},
+			{"url": "https://example.net", "title": "not in the message"}
+		]
+	}}}`
+	msg := &signal.Message{}
+	if err := json.Unmarshal([]byte(wire), msg); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSiggo()
+	if err := s.onReceived(msg); err != nil {
+		t.Fatal(err)
+	}
+	m := s.conversations[s.contacts["+15550000001"]].LastMessage()
+	if !assert.Len(t, m.Previews, 2) {
+		return
+	}
+	multipass := m.Preview("https://example.com/multipass")
+	assert.Equal(t, "Multipass", multipass.Label())
+	assert.Equal(t, "example.com", multipass.Site())
+	assert.Equal(t, "image/jpeg", multipass.Image.ContentType)
+	assert.Equal(t, "https://example.org", m.Preview("https://example.org").Label())
+	assert.Nil(t, m.Preview("https://example.net"))
+
+	previews := make([]string, 0)
+	for _, span := range m.Spans() {
+		if span.Kind == PreviewSpan {
+			previews = append(previews, span.Text)
+		}
+	}
+	assert.Equal(t, []string{"Multipass", "https://example.org"}, previews)
+
+	// they are saved with the message
+	b, err := json.Marshal(m)
+	assert.NoError(t, err)
+	saved := &Message{}
+	assert.NoError(t, json.Unmarshal(b, saved))
+	assert.Equal(t, m.Previews, saved.Previews)
+
+	// a preview of a link that isn't in the message, saved before they were dropped, isn't shown
+	saved.Previews = append(saved.Previews, &Preview{URL: "https://example.net", Title: "Zorg"})
+	assert.Len(t, saved.LinkPreviews(), 2)
+	assert.Nil(t, saved.Preview("https://example.net"))
+	for _, span := range saved.Spans() {
+		assert.NotEqual(t, "Zorg", span.Text)
+	}
+	saved.IsDeleted = true
+	assert.Empty(t, saved.LinkPreviews())
+}
@@ -0,0 +1,199 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/derricw/siggo/signal"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxQuoteLength is how much of a quoted message we show in a reply
+const maxQuoteLength = 40
+
+// Quote is the message a reply is replying to
+type Quote struct {
+	Author    PhoneNumber `json:"author"`
+	Timestamp int64       `json:"timestamp"`
+	Text      string      `json:"text"`
+}
+
+// Key returns the key of the quoted message
+func (q *Quote) Key() MessageKey {
+	return MessageKey{Author: q.Author, Timestamp: q.Timestamp}
+}
+
+// String returns the start of the quoted text
+func (q *Quote) String() string {
+	text := strings.Join(strings.Fields(q.Text), " ")
+	if runes := []rune(text); len(runes) > maxQuoteLength {
+		text = string(runes[:maxQuoteLength-1]) + "…"
+	}
+	return text
+}
+
+// convertQuote converts a quote from signal-cli, which can be nil
+func convertQuote(q *signal.Quote) *Quote {
+	if q == nil {
+		return nil
+	}
+	return &Quote{Author: q.Author, Timestamp: q.ID, Text: q.Text}
+}
+
+// applyReaction records `author`'s reaction, or removes it. Everyone gets one reaction per message.
+func (m *Message) applyReaction(author PhoneNumber, emoji string, remove bool) {
+	if remove {
+		if m.Reactions[author] == emoji {
+			delete(m.Reactions, author)
+		}
+		return
+	}
+	if m.Reactions == nil {
+		m.Reactions = make(map[PhoneNumber]string)
+	}
+	m.Reactions[author] = emoji
+}
+
+// ReactionSummary returns the reactions to the message with their counts, like "👍2 ❤️1", most
+// popular first
+func (m *Message) ReactionSummary() string {
+	counts := make(map[string]int)
+	for _, emoji := range m.Reactions {
+		counts[emoji]++
+	}
+	emojis := make([]string, 0, len(counts))
+	for emoji := range counts {
+		emojis = append(emojis, emoji)
+	}
+	sort.Slice(emojis, func(i, j int) bool {
+		if counts[emojis[i]] != counts[emojis[j]] {
+			return counts[emojis[i]] > counts[emojis[j]]
+		}
+		return emojis[i] < emojis[j]
+	})
+	parts := make([]string, 0, len(emojis))
+	for _, emoji := range emojis {
+		parts = append(parts, fmt.Sprintf("%s%d", emoji, counts[emoji]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// markDeleted throws away what the message said, keeping a note that it was there
+func (m *Message) markDeleted() {
+	m.IsDeleted = true
+	m.Content = ""
+	m.Attachments = nil
+	m.Quote = nil
+	m.Reactions = nil
+}
+
+// StageReply makes the next message sent in the conversation a reply to `msg`. nil cancels it.
+func (c *Conversation) StageReply(msg *Message) {
+	c.stagedReply = msg
+}
+
+// StagedReply returns the message the next message replies to, if any
+func (c *Conversation) StagedReply() *Message {
+	return c.stagedReply
+}
+
+// recipient returns where to send things for `contact`
+func (s *Siggo) recipient(contact *Contact) signal.Recipient {
+	return signal.Recipient{Dest: contact.Number, Group: contact.isGroup, Dbus: !s.noDaemon}
+}
+
+// React reacts to a message in the conversation with `contact`. Reacting with the emoji we already
+// reacted with, or with "", takes our reaction back.
+func (s *Siggo) React(contact *Contact, msg *Message, emoji string) error {
+	if s.offline {
+		return fmt.Errorf("can't react while offline")
+	}
+	if msg.IsDeleted {
+		return fmt.Errorf("can't react to a deleted message")
+	}
+	self := s.config.UserNumber
+	old, reacted := msg.Reactions[self]
+	remove := emoji == "" || (reacted && old == emoji)
+	if remove {
+		if !reacted {
+			return nil
+		}
+		emoji = old
+	}
+	reaction := &signal.Reaction{
+		Emoji:               emoji,
+		TargetAuthor:        msg.Author,
+		TargetSentTimestamp: msg.Timestamp,
+		IsRemove:            remove,
+	}
+	if _, err := s.signal.SendReaction(s.recipient(contact), reaction); err != nil {
+		return err
+	}
+	s.convMu.Lock()
+	defer s.convMu.Unlock()
+	conv := s.Conversation(contact)
+	msg.applyReaction(self, emoji, remove)
+	conv.markChanged(msg.Key())
+	s.NewInfo(conv)
+	s.journal(conv)
+	return nil
+}
+
+// Delete deletes a message from the conversation with `contact`. Our own messages are deleted for
+// everyone, anybody else's only here. Our messages that haven't been sent yet are taken out of the
+// outbox, so they never are.
+func (s *Siggo) Delete(contact *Contact, msg *Message) error {
+	if msg.IsDeleted {
+		return nil
+	}
+	if msg.FromSelf && !msg.IsPending && !msg.IsFailed {
+		if s.offline {
+			return fmt.Errorf("can't delete for everyone while offline")
+		}
+		if _, err := s.signal.RemoteDelete(s.recipient(contact), msg.Timestamp); err != nil {
+			return err
+		}
+	}
+	s.convMu.Lock()
+	defer s.convMu.Unlock()
+	if msg.FromSelf && (msg.IsPending || msg.IsFailed) {
+		s.unqueue(msg)
+	}
+	conv := s.Conversation(contact)
+	msg.markDeleted()
+	conv.markChanged(msg.Key())
+	s.NewInfo(conv)
+	s.journal(conv)
+	return nil
+}
+
+// onReaction applies a reaction from `author` to whichever message it is for
+func (s *Siggo) onReaction(author PhoneNumber, reaction *signal.Reaction) error {
+	key := MessageKey{Author: reaction.TargetAuthor, Timestamp: reaction.TargetSentTimestamp}
+	message, conv := s.findMessage(key, nil)
+	if message == nil {
+		log.Debugf("ignoring reaction to a message we don't have: %s", key)
+		return nil
+	}
+	message.applyReaction(author, reaction.Emoji, reaction.IsRemove)
+	conv.markChanged(key)
+	s.NewInfo(conv)
+	s.journal(conv)
+	return nil
+}
+
+// onRemoteDelete deletes a message `author` sent
+func (s *Siggo) onRemoteDelete(author PhoneNumber, timestamp int64) error {
+	key := MessageKey{Author: author, Timestamp: timestamp}
+	message, conv := s.findMessage(key, nil)
+	if message == nil {
+		log.Debugf("ignoring deletion of a message we don't have: %s", key)
+		return nil
+	}
+	message.markDeleted()
+	conv.markChanged(key)
+	s.NewInfo(conv)
+	s.journal(conv)
+	return nil
+}
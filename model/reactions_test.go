@@ -0,0 +1,62 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/derricw/siggo/signal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReactionsAndDeletes(t *testing.T) {
+	s := newTestSiggo()
+	s.signal = signal.NewMockSignal(s.config.UserNumber, nil)
+	receive := func(dm *signal.DataMessage) {
+		err := s.onReceived(&signal.Message{Envelope: &signal.Envelope{
+			Source:      "+15550000001",
+			DataMessage: dm,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive(&signal.DataMessage{Timestamp: 1000, Message: "multipass"})
+	receive(&signal.DataMessage{
+		Timestamp: 2000,
+		Message:   "big badaboom",
+		Quote:     &signal.Quote{ID: 1000, Author: "+15550000001", Text: "multipass"},
+	})
+	// reactions and deletes aren't messages of their own
+	receive(&signal.DataMessage{Timestamp: 3000, Reaction: &signal.Reaction{
+		Emoji: "👍", TargetAuthor: "+15550000001", TargetSentTimestamp: 1000,
+	}})
+	err := s.onSent(&signal.Message{Envelope: &signal.Envelope{
+		Source: s.config.UserNumber,
+		SyncMessage: &signal.SyncMessage{SentMessage: &signal.SentMessage{
+			Timestamp:   4000,
+			Destination: "+15550000001",
+			Reaction: &signal.Reaction{
+				Emoji: "👍", TargetAuthor: "+15550000001", TargetSentTimestamp: 1000,
+			},
+		}},
+	}})
+	assert.NoError(t, err)
+	contact := s.contacts["+15550000001"]
+	conv := s.conversations[contact]
+	assert.Len(t, conv.MessageOrder, 2)
+	first := conv.Messages[MessageKey{"+15550000001", 1000}]
+	assert.Equal(t, "👍2", first.ReactionSummary())
+	reply := conv.Messages[MessageKey{"+15550000001", 2000}]
+	assert.Equal(t, first.Key(), reply.Quote.Key())
+
+	// reacting again with the same emoji takes it back
+	assert.NoError(t, s.React(contact, first, "👍"))
+	assert.Equal(t, "👍1", first.ReactionSummary())
+	assert.NoError(t, s.React(contact, first, "❤️"))
+	assert.Equal(t, "❤️1 👍1", first.ReactionSummary())
+
+	receive(&signal.DataMessage{Timestamp: 5000, RemoteDelete: &signal.RemoteDelete{Timestamp: 1000}})
+	assert.True(t, first.IsDeleted)
+	assert.Empty(t, first.Content)
+	assert.Empty(t, first.Reactions)
+	assert.Len(t, conv.MessageOrder, 2)
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// receiptTTL is how long we hang on to a receipt for a message we haven't seen. Anything older
+// than this is probably for a message we will never see, for example one sent before siggo was
+// set up.
+const receiptTTL = 10 * time.Minute
+
+type bufferedReceipt struct {
+	isDelivery bool
+	isRead     bool
+	received   time.Time
+}
+
+// receiptBuffer holds receipts that arrive before the message they are for
+type receiptBuffer struct {
+	mu       sync.Mutex
+	receipts map[MessageKey]*bufferedReceipt
+}
+
+// add buffers a receipt, merging it with any we already have for the same message
+func (b *receiptBuffer) add(key MessageKey, isDelivery, isRead bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+	r, ok := b.receipts[key]
+	if !ok {
+		r = &bufferedReceipt{}
+		b.receipts[key] = r
+	}
+	r.isDelivery = r.isDelivery || isDelivery
+	r.isRead = r.isRead || isRead
+	r.received = time.Now()
+}
+
+// take removes and returns the buffered receipt for a message, if there is one
+func (b *receiptBuffer) take(key MessageKey) (*bufferedReceipt, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r, ok := b.receipts[key]
+	if ok {
+		delete(b.receipts, key)
+	}
+	return r, ok
+}
+
+// expire drops receipts older than receiptTTL. Must be called with the lock held.
+func (b *receiptBuffer) expire() {
+	now := time.Now()
+	for key, r := range b.receipts {
+		if now.Sub(r.received) > receiptTTL {
+			log.Debugf("dropping receipt for message we never saw: %s", key)
+			delete(b.receipts, key)
+		}
+	}
+}
+
+func newReceiptBuffer() *receiptBuffer {
+	return &receiptBuffer{
+		receipts: make(map[MessageKey]*bufferedReceipt),
+	}
+}
+
+// applyBufferedReceipts applies any receipts that arrived before `message` did
+func (s *Siggo) applyBufferedReceipts(message *Message) {
+	if r, ok := s.receipts.take(message.Key()); ok {
+		log.Debugf("applying buffered receipt to message: %s", message.Key())
+		message.applyReceipt(r.isDelivery, r.isRead)
+	}
+}
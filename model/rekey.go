@@ -0,0 +1,155 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Rekeying writes the new key and everything it re-encrypts next to the files they replace, with
+// rekeySuffix added to their names. Once all of it is on disk a marker is written, and only then
+// is it moved into place, the key last. A crash before the marker leaves the old key and history
+// untouched, and one after it is finished by FinishRekey.
+const rekeySuffix = ".rekey"
+
+// rekeyMarkerPath returns the path of the file that says a rekey is ready to be moved into place
+func rekeyMarkerPath() string {
+	return filepath.Join(FindDataFolder(), "rekey-commit")
+}
+
+// Rekey re-encrypts every conversation in the message store `name` and the outbox with `to`. They
+// are read with `from`, which is nil if they aren't encrypted. The key file is replaced with the
+// one for `to`. Returns the number of conversations and messages re-encrypted.
+func Rekey(name string, from, to *Cipher) (int, int, error) {
+	// finish or throw away whatever an earlier rekey left behind
+	if err := FinishRekey(); err != nil {
+		return 0, 0, err
+	}
+	src, err := OpenStore(name, from)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+	convs, err := src.Conversations()
+	if err != nil {
+		return 0, 0, err
+	}
+	// read everything before writing anything, so that a wrong key can't leave us half done
+	saved := make(map[PhoneNumber][]*Message, len(convs))
+	for _, conv := range convs {
+		if saved[conv], err = src.Range(conv, 0, math.MaxInt64); err != nil {
+			return 0, 0, fmt.Errorf("failed to read conversation %s: %v", conv, err)
+		}
+	}
+	outbox := NewOutbox(OutboxPath(), from)
+	if err := outbox.Load(); err != nil {
+		return 0, 0, fmt.Errorf("failed to read outbox: %v", err)
+	}
+
+	if err := to.SaveKey(KeyPath() + rekeySuffix); err != nil {
+		return 0, 0, fmt.Errorf("failed to save key: %v", err)
+	}
+	staged, err := openRekeyStore(name, to)
+	if err != nil {
+		return 0, 0, err
+	}
+	total := 0
+	for conv, msgs := range saved {
+		if err := staged.Replace(conv, msgs); err != nil {
+			staged.Close()
+			return 0, 0, fmt.Errorf("failed to rewrite conversation %s: %v", conv, err)
+		}
+		total += len(msgs)
+	}
+	if err := staged.Close(); err != nil {
+		return 0, 0, err
+	}
+	rekeyed := NewOutbox(OutboxPath()+rekeySuffix, to)
+	for _, entry := range outbox.Entries() {
+		rekeyed.Add(entry)
+	}
+	if err := rekeyed.Save(); err != nil {
+		return 0, 0, fmt.Errorf("failed to rewrite outbox: %v", err)
+	}
+
+	// from here on, the new key and history replace the old ones even if we crash
+	if err := writeFileAtomic(rekeyMarkerPath(), 0600, func(*os.File) error { return nil }); err != nil {
+		return 0, 0, err
+	}
+	return len(convs), total, FinishRekey()
+}
+
+// openRekeyStore opens a store of the kind `name` where a rekey stages its files
+func openRekeyStore(name string, c *Cipher) (Store, error) {
+	switch name {
+	case "", JSONLStoreName:
+		return NewJSONLStore(ConversationFolder()+rekeySuffix, c), nil
+	case SQLiteStoreName:
+		return NewSQLiteStore(SQLiteStorePath()+rekeySuffix, c)
+	}
+	return nil, fmt.Errorf("unknown message store: %s", name)
+}
+
+// FinishRekey deals with a rekey that was interrupted. If it got as far as writing everything
+// out, the new files are moved into place. If not, they are removed and the old key and history
+// stay as they were.
+func FinishRekey() error {
+	if _, err := os.Stat(rekeyMarkerPath()); os.IsNotExist(err) {
+		return discardRekey()
+	} else if err != nil {
+		return err
+	}
+	folder := ConversationFolder()
+	files, err := ioutil.ReadDir(folder + rekeySuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(files) > 0 {
+		if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err := os.Rename(filepath.Join(folder+rekeySuffix, f.Name()), filepath.Join(folder, f.Name())); err != nil {
+			return err
+		}
+	}
+	if len(files) > 0 {
+		if err := syncDir(folder); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(folder + rekeySuffix); err != nil {
+		return err
+	}
+	// the key goes last, so the old one is only gone once nothing it encrypted is left
+	for _, path := range []string{SQLiteStorePath(), OutboxPath(), KeyPath()} {
+		err := os.Rename(path+rekeySuffix, path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := syncDir(FindDataFolder()); err != nil {
+		return err
+	}
+	if err := os.Remove(rekeyMarkerPath()); err != nil {
+		return err
+	}
+	return syncDir(FindDataFolder())
+}
+
+// discardRekey removes whatever a rekey that didn't get to write its marker left behind
+func discardRekey() error {
+	if err := os.RemoveAll(ConversationFolder() + rekeySuffix); err != nil {
+		return err
+	}
+	for _, path := range []string{SQLiteStorePath(), OutboxPath(), KeyPath()} {
+		err := os.Remove(path + rekeySuffix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRekey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-rekey-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_DATA_HOME", dir)
+	defer os.Unsetenv("XDG_DATA_HOME")
+
+	conv := "+15550000001"
+	if err := NewJSONLStore(ConversationFolder(), nil).Append(conv, &Message{Content: "korben", Timestamp: 1, Author: conv}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := NewCipher("multipass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	convs, total, err := Rekey(JSONLStoreName, nil, first)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, convs)
+	assert.Equal(t, 1, total)
+	readAll := func(c *Cipher) ([]*Message, error) {
+		return NewJSONLStore(ConversationFolder(), c).Range(conv, 0, math.MaxInt64)
+	}
+	_, err = readAll(nil)
+	assert.Equal(t, ErrLocked, err)
+	loaded, err := LoadCipher(KeyPath(), "multipass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := readAll(loaded)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	// a crash before everything was staged leaves the old key and history alone
+	second, err := NewCipher("big badda boom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.SaveKey(KeyPath() + rekeySuffix); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, FinishRekey())
+	_, err = os.Stat(KeyPath() + rekeySuffix)
+	assert.True(t, os.IsNotExist(err))
+	_, err = LoadCipher(KeyPath(), "multipass")
+	assert.NoError(t, err)
+
+	// a crash after it is finished
+	if err := second.SaveKey(KeyPath() + rekeySuffix); err != nil {
+		t.Fatal(err)
+	}
+	staged, _ := openRekeyStore(JSONLStoreName, second)
+	if err := staged.Replace(conv, msgs); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(rekeyMarkerPath(), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, FinishRekey())
+	_, err = LoadCipher(KeyPath(), "multipass")
+	assert.Equal(t, ErrWrongPassphrase, err)
+	loaded, err = LoadCipher(KeyPath(), "big badda boom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err = readAll(loaded)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	_, err = os.Stat(rekeyMarkerPath())
+	assert.True(t, os.IsNotExist(err))
+}
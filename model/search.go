@@ -0,0 +1,286 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// messageRef points at a message in a conversation
+type messageRef struct {
+	Conv PhoneNumber
+	Key  MessageKey
+}
+
+// SearchIndex is an inverted index from the words in messages to the messages that contain them.
+// It only keeps references, messages are looked up again when a search finds them.
+type SearchIndex struct {
+	mu    sync.RWMutex
+	terms map[string]map[messageRef]bool
+	refs  map[messageRef]bool
+}
+
+// tokenize splits text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Add indexes a message. Adding a message twice does nothing.
+func (ix *SearchIndex) Add(conv PhoneNumber, msg *Message) {
+	ref := messageRef{conv, msg.Key()}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.refs[ref] {
+		return
+	}
+	ix.refs[ref] = true
+	words := tokenize(msg.Content)
+	for _, a := range msg.Attachments {
+		words = append(words, tokenize(a.DisplayName())...)
+	}
+	for _, word := range words {
+		refs, ok := ix.terms[word]
+		if !ok {
+			refs = make(map[messageRef]bool)
+			ix.terms[word] = refs
+		}
+		refs[ref] = true
+	}
+}
+
+// Len returns the number of messages in the index
+func (ix *SearchIndex) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.refs)
+}
+
+// lookup returns the messages containing every word of `query`, newest first. The last word
+// also matches as a prefix, so results show up while it is still being typed.
+func (ix *SearchIndex) lookup(query string) []messageRef {
+	words := tokenize(query)
+	if len(words) == 0 {
+		return []messageRef{}
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var matches map[messageRef]bool
+	for i, word := range words {
+		found := make(map[messageRef]bool)
+		if i == len(words)-1 {
+			for term, refs := range ix.terms {
+				if strings.HasPrefix(term, word) {
+					for ref := range refs {
+						found[ref] = true
+					}
+				}
+			}
+		} else {
+			for ref := range ix.terms[word] {
+				found[ref] = true
+			}
+		}
+		if matches == nil {
+			matches = found
+			continue
+		}
+		for ref := range matches {
+			if !found[ref] {
+				delete(matches, ref)
+			}
+		}
+	}
+	out := make([]messageRef, 0, len(matches))
+	for ref := range matches {
+		out = append(out, ref)
+	}
+	sort.Slice(out, func(i, j int) bool { return keyBefore(out[j].Key, out[i].Key) })
+	return out
+}
+
+// NewSearchIndex creates an empty index
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		terms: make(map[string]map[messageRef]bool),
+		refs:  make(map[messageRef]bool),
+	}
+}
+
+// SearchResult is a message found by a search
+type SearchResult struct {
+	Contact *Contact
+	Message *Message
+}
+
+// SearchOptions narrow down a search
+type SearchOptions struct {
+	// Contact limits the search to one conversation
+	Contact *Contact
+	// Since and Until limit the search to messages sent in between (in ms), 0 for no limit
+	Since int64
+	Until int64
+	// Limit is the maximum number of results, 0 for no limit
+	Limit int
+}
+
+// Index returns the search index, building it from every saved message the first time
+func (s *Siggo) Index() (*SearchIndex, error) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if s.index != nil {
+		return s.index, nil
+	}
+	ix := NewSearchIndex()
+	if s.store != nil {
+		convs, err := s.store.Conversations()
+		if err != nil {
+			return nil, err
+		}
+		for _, conv := range convs {
+			msgs, err := s.store.Range(conv, 0, math.MaxInt64)
+			if err != nil {
+				return nil, err
+			}
+			for _, msg := range msgs {
+				if msg.Author == "" {
+					msg.Author = legacyAuthor(msg, s.config, &Contact{Number: conv})
+				}
+				ix.Add(conv, msg)
+			}
+		}
+	}
+	s.index = ix
+	return ix, nil
+}
+
+// SearchSnapshot is a copy of the messages loaded in every conversation, so that they can be
+// searched on another goroutine while the conversations keep changing
+type SearchSnapshot struct {
+	s        *Siggo
+	contacts map[PhoneNumber]*Contact
+	messages map[messageRef]*Message
+}
+
+// Snapshot copies what a search needs from the conversations. Call it where the conversations are
+// changed (the UI goroutine), and search the snapshot wherever.
+func (s *Siggo) Snapshot() *SearchSnapshot {
+	s.convMu.Lock()
+	defer s.convMu.Unlock()
+	snap := &SearchSnapshot{
+		s:        s,
+		contacts: make(map[PhoneNumber]*Contact, len(s.contacts)),
+		messages: make(map[messageRef]*Message),
+	}
+	for number, contact := range s.contacts {
+		snap.contacts[number] = contact
+	}
+	for contact, conv := range s.conversations {
+		for key, msg := range conv.Messages {
+			copied := *msg
+			snap.messages[messageRef{contact.Number, key}] = &copied
+		}
+	}
+	return snap
+}
+
+// Search finds messages, saved or not, that contain every word in `query`, newest first
+func (s *Siggo) Search(query string, opts SearchOptions) ([]*SearchResult, error) {
+	return s.Snapshot().Search(query, opts)
+}
+
+// Search finds messages, in the snapshot or saved, that contain every word in `query`, newest
+// first
+func (snap *SearchSnapshot) Search(query string, opts SearchOptions) ([]*SearchResult, error) {
+	ix, err := snap.s.Index()
+	if err != nil {
+		return nil, err
+	}
+	// anything that arrived since the index was built
+	for ref, msg := range snap.messages {
+		ix.Add(ref.Conv, msg)
+	}
+	until := opts.Until
+	if until <= 0 {
+		until = math.MaxInt64
+	}
+	out := make([]*SearchResult, 0)
+	for _, ref := range ix.lookup(query) {
+		if opts.Limit > 0 && len(out) >= opts.Limit {
+			break
+		}
+		if ref.Key.Timestamp < opts.Since || ref.Key.Timestamp > until {
+			continue
+		}
+		if opts.Contact != nil && ref.Conv != opts.Contact.Number {
+			continue
+		}
+		result, err := snap.findRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		// the index still has the words of deleted messages
+		if result != nil && !result.Message.IsDeleted {
+			out = append(out, result)
+		}
+	}
+	return out, nil
+}
+
+// findRef looks up a message found in the index, in the snapshot if it was loaded or in the store
+// if not
+func (snap *SearchSnapshot) findRef(ref messageRef) (*SearchResult, error) {
+	s := snap.s
+	contact, ok := snap.contacts[ref.Conv]
+	if !ok {
+		contact = &Contact{Number: ref.Conv}
+	}
+	if msg, ok := snap.messages[ref]; ok {
+		return &SearchResult{contact, msg}, nil
+	}
+	if s.store == nil {
+		return nil, nil
+	}
+	msgs, err := s.store.Range(ref.Conv, ref.Key.Timestamp, ref.Key.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		if msg.Author == "" {
+			msg.Author = legacyAuthor(msg, s.config, contact)
+		}
+		if msg.Key() != ref.Key {
+			continue
+		}
+		if msg.FromContact != nil {
+			msg.FromContact.Configure(s.config)
+		} else if !msg.FromSelf {
+			msg.FromContact = contact
+		}
+		return &SearchResult{contact, msg}, nil
+	}
+	return nil, nil
+}
+
+// GotoMessage makes sure the message with `key` is loaded in `conv`, paging in older messages
+// until it is found. Returns false if it isn't in the conversation.
+func (s *Siggo) GotoMessage(conv *Conversation, key MessageKey) (bool, error) {
+	s.convMu.Lock()
+	defer s.convMu.Unlock()
+	for {
+		if _, ok := conv.Messages[key]; ok {
+			return true, nil
+		}
+		if s.store == nil || conv.LoadedAll() {
+			return false, nil
+		}
+		if n, err := s.loadOlder(conv); err != nil {
+			return false, err
+		} else if n == 0 {
+			return false, nil
+		}
+	}
+}
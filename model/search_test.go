@@ -0,0 +1,51 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/derricw/siggo/signal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	s := newTestSiggo()
+	for i, text := range []string{"multipass", "big badaboom", "Big Bada Boom!", "leeloo dallas multipass"} {
+		err := s.onReceived(&signal.Message{Envelope: &signal.Envelope{
+			Source: "+15550000001",
+			DataMessage: &signal.DataMessage{
+				Timestamp: int64(1000 * (i + 1)),
+				Message:   text,
+			},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := s.Search("MULTIPASS", SearchOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		// newest first
+		assert.Equal(t, "leeloo dallas multipass", results[0].Message.Content)
+		assert.Equal(t, "multipass", results[1].Message.Content)
+	}
+
+	// every word has to match, the last one can be a prefix
+	results, err = s.Search("big bada bo", SearchOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "Big Bada Boom!", results[0].Message.Content)
+	}
+
+	results, err = s.Search("multipass", SearchOptions{Since: 2000})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	results, err = s.Search("multipass", SearchOptions{Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	results, err = s.Search("zorg", SearchOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
@@ -0,0 +1,131 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+)
+
+// options are the settings that can be changed while siggo is running, by their name in the config
+func (c *Config) options() map[string]*bool {
+	return map[string]*bool{
+		"hide_panel_titles":                  &c.HidePanelTitles,
+		"hide_phone_numbers":                 &c.HidePhoneNumbers,
+		"desktop_notifications":              &c.DesktopNotifications,
+		"desktop_notifications_show_message": &c.DesktopNotificationsShowMessage,
+		"desktop_notifications_show_avatar":  &c.DesktopNotificationsShowAvatar,
+		"terminal_bell_notifications":        &c.TerminalBellNotifications,
+		"text_formatting":                    &c.TextFormatting,
+		"contact_previews":                   &c.Layout.ContactPreviews,
+	}
+}
+
+// optionPath returns the path of the key of an option in the config file
+func optionPath(name string) []string {
+	if name == "contact_previews" {
+		return []string{"layout", name}
+	}
+	return []string{name}
+}
+
+// Options returns the names of the settings that can be changed with SetOption, sorted
+func (s *Siggo) Options() []string {
+	names := make([]string, 0)
+	for name := range s.config.options() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Option returns the value of a setting
+func (s *Siggo) Option(name string) (bool, error) {
+	opt, ok := s.config.options()[name]
+	if !ok {
+		return false, fmt.Errorf("unknown option: %s", name)
+	}
+	return *opt, nil
+}
+
+// SetOption changes a setting until siggo quits, or the config is saved with SaveConfig
+func (s *Siggo) SetOption(name string, value bool) error {
+	opt, ok := s.config.options()[name]
+	if !ok {
+		return fmt.Errorf("unknown option: %s", name)
+	}
+	*opt = value
+	s.config.edit(value, optionPath(name)...)
+	return nil
+}
+
+// SetAlias sets the alias of `contact`, "" removes it. Like in the config, aliases go by name, so
+// every contact with the same name gets it.
+func (s *Siggo) SetAlias(contact *Contact, alias string) error {
+	if contact.Name == "" {
+		return fmt.Errorf("can't alias %s, aliases go by name and it has none", contact.Number)
+	}
+	if s.config.ContactAliases == nil {
+		s.config.ContactAliases = make(map[string]string)
+	}
+	if alias == "" {
+		delete(s.config.ContactAliases, contact.Name)
+		s.config.edit(nil, "contact_aliases", contact.Name)
+	} else {
+		s.config.ContactAliases[contact.Name] = alias
+		s.config.edit(alias, "contact_aliases", contact.Name)
+	}
+	s.reconfigure(contact.Name)
+	return nil
+}
+
+// SetColor sets the color of messages from `contact`, "" removes it. Colors go by name, like
+// aliases.
+func (s *Siggo) SetColor(contact *Contact, color string) error {
+	if contact.Name == "" {
+		return fmt.Errorf("can't color %s, colors go by name and it has none", contact.Number)
+	}
+	if s.config.ContactColors == nil {
+		s.config.ContactColors = make(map[string]string)
+	}
+	if color == "" {
+		delete(s.config.ContactColors, contact.Name)
+		s.config.edit(nil, "contact_colors", contact.Name)
+	} else {
+		s.config.ContactColors[contact.Name] = color
+		s.config.edit(color, "contact_colors", contact.Name)
+	}
+	s.reconfigure(contact.Name)
+	return nil
+}
+
+// reconfigure applies the config to every contact named `name`, and to the theme
+func (s *Siggo) reconfigure(name string) {
+	s.loadTheme()
+	for _, contact := range s.contacts {
+		if contact.Name == name {
+			contact.Configure(s.config)
+			if conv, ok := s.conversations[contact]; ok {
+				s.NewInfo(conv)
+			}
+		}
+	}
+}
+
+// SaveConfig writes the changes made to the configuration while running to the config file
+func (s *Siggo) SaveConfig() error {
+	return s.config.SaveChanges(ConfigPath())
+}
+
+// AddGroupMembers adds `members` to `group`
+func (s *Siggo) AddGroupMembers(group *Contact, members ...PhoneNumber) error {
+	if !group.isGroup {
+		return fmt.Errorf("%s is not a group", group)
+	}
+	if len(members) == 0 {
+		return fmt.Errorf("no members to add")
+	}
+	if s.offline {
+		return fmt.Errorf("can't change groups while offline")
+	}
+	_, err := s.signal.UpdateGroup(s.recipient(group), members...)
+	return err
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettings(t *testing.T) {
+	s := newTestSiggo()
+	ruby := &Contact{Number: "+15550000001", Name: "Ruby Rhod"}
+	again := &Contact{Number: "+15550000002", Name: "Ruby Rhod"}
+	s.contacts[ruby.Number] = ruby
+	s.contacts[again.Number] = again
+
+	assert.NoError(t, s.SetOption("hide_phone_numbers", true))
+	on, err := s.Option("hide_phone_numbers")
+	assert.NoError(t, err)
+	assert.True(t, on)
+	assert.Error(t, s.SetOption("save_messages", true))
+
+	// aliases and colors go by name
+	assert.NoError(t, s.SetAlias(ruby, "Super Green"))
+	assert.Equal(t, "Super Green", again.String())
+	assert.NoError(t, s.SetColor(ruby, "green"))
+	assert.Equal(t, "green", again.Color())
+	assert.NoError(t, s.SetAlias(ruby, ""))
+	assert.Equal(t, "Ruby Rhod", again.String())
+	assert.Error(t, s.SetAlias(&Contact{Number: "+15550000003"}, "Zorg"))
+}
+
+func TestCommandHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "command_history")
+
+	h, err := LoadCommandHistory(path)
+	assert.NoError(t, err)
+	assert.Empty(t, h.Commands)
+	for _, cmd := range []string{"goto Leeloo", "set hide_phone_numbers", "goto Leeloo", " "} {
+		assert.NoError(t, h.Add(cmd))
+	}
+	loaded, err := LoadCommandHistory(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"set hide_phone_numbers", "goto Leeloo"}, loaded.Commands)
+}
+
+func TestSaveChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	original := `# my siggo config
+user_number: "15559999999"
+hide_phone_numbers: false # for now
+contact_aliases:
+  Ruby Rhod: Ruby # the loud one
+  Zorg: Mr. Shadow
+contact_colors: {}
+layout:
+  contacts_width: 30
+`
+	if err := ioutil.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// things siggo fills in for itself aren't saved
+	cfg.LogFilePath = filepath.Join(dir, "siggo.log")
+	cfg.UserNumber = "+15559999999"
+
+	s := newTestSiggo()
+	s.config = cfg
+	ruby := &Contact{Number: "+15550000001", Name: "Ruby Rhod"}
+	zorg := &Contact{Number: "+15550000002", Name: "Zorg"}
+	assert.NoError(t, s.SetOption("hide_phone_numbers", true))
+	assert.NoError(t, s.SetOption("contact_previews", true))
+	assert.NoError(t, s.SetOption("text_formatting", false))
+	assert.NoError(t, s.SetAlias(zorg, ""))
+	assert.NoError(t, s.SetAlias(ruby, "Super Green"))
+	assert.NoError(t, s.SetColor(ruby, "green"))
+	assert.NoError(t, cfg.SaveChanges(path))
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `# my siggo config
+user_number: "15559999999"
+hide_phone_numbers: true # for now
+contact_aliases:
+  Ruby Rhod: Super Green # the loud one
+contact_colors:
+  Ruby Rhod: green
+layout:
+  contacts_width: 30
+  contact_previews: true
+text_formatting: false
+`, string(b))
+	loaded, err := LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "Super Green", loaded.ContactAliases["Ruby Rhod"])
+	assert.True(t, loaded.Layout.ContactPreviews)
+	assert.Equal(t, "", loaded.LogFilePath)
+}
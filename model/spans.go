@@ -0,0 +1,153 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// linkPattern matches links in messages (stolen from suckoverflow)
+var linkPattern = regexp.MustCompile(`https?:\/\/(www\.)?[-a-zA-Z0-9@:%._\+~#=]{1,256}\.[a-zA-Z0-9()]{1,6}\b([-a-zA-Z0-9()@:%_\+.~#?&//=]*)`)
+
+// markupPattern matches whatever tview would take for a color or region tag, or for an escaped
+// one, which escaping turns into an escaped tag
+var markupPattern = regexp.MustCompile(`(\[[a-zA-Z0-9_,;: \-\."#]+\[*)\]`)
+
+// EscapeMarkup escapes `text` so that it shows up as it is in tview, rather than being taken for
+// color or region tags
+func EscapeMarkup(text string) string {
+	return markupPattern.ReplaceAllString(text, "$1[]")
+}
+
+// SpanKind is what part of a message a span is
+type SpanKind int
+
+const (
+	// SenderSpan is who sent the message, empty if it was us
+	SenderSpan SpanKind = iota
+	// QuoteSpan is the message it replies to
+	QuoteSpan
+	// TextSpan is some of the content, and LinkSpan a link in the content
+	TextSpan
+	LinkSpan
+	// ReactionSpan is everyone's reactions to the message
+	ReactionSpan
+	// PreviewSpan is the preview of a link in the message
+	PreviewSpan
+	// AttachmentSpan is one of its attachments
+	AttachmentSpan
+)
+
+// Span is part of a message as it is shown, in plain text. Turning it into markup, and escaping it,
+// is up to whoever shows it.
+type Span struct {
+	Kind SpanKind
+	Text string
+	// Styles are the text styles of text and link spans, and Start where they start in the
+	// content, in bytes
+	Styles []string
+	Start  int
+	// Attachment is the attachment of an attachment span, Preview the preview of a preview span
+	Attachment *Attachment
+	Preview    *Preview
+}
+
+// Has returns true if the span has the text style `style`
+func (s *Span) Has(style string) bool {
+	for _, st := range s.Styles {
+		if st == style {
+			return true
+		}
+	}
+	return false
+}
+
+// Spans returns the message in spans: who sent it, the message it replies to, its content split
+// into text and links, its reactions, its link previews and its attachments, in that order
+func (m *Message) Spans() []Span {
+	spans := make([]Span, 0)
+	sender := ""
+	if !m.FromSelf && m.FromContact != nil {
+		sender = m.FromContact.String()
+	}
+	spans = append(spans, Span{Kind: SenderSpan, Text: sender})
+	if m.Quote != nil {
+		spans = append(spans, Span{Kind: QuoteSpan, Text: m.Quote.String()})
+	}
+	spans = append(spans, m.contentSpans()...)
+	if len(m.Reactions) > 0 {
+		spans = append(spans, Span{Kind: ReactionSpan, Text: m.ReactionSummary()})
+	}
+	for _, p := range m.LinkPreviews() {
+		spans = append(spans, Span{Kind: PreviewSpan, Text: p.Label(), Preview: p})
+	}
+	for _, a := range m.Attachments {
+		spans = append(spans, Span{Kind: AttachmentSpan, Text: a.Filename, Attachment: a})
+	}
+	return spans
+}
+
+// contentSpans splits the content into text and link spans, which also change where the text
+// style does
+func (m *Message) contentSpans() []Span {
+	runs := m.StyledContent()
+	links := make([][]int, 0)
+	if !m.IsDeleted {
+		links = linkPattern.FindAllStringIndex(m.Content, -1)
+	}
+	spans := make([]Span, 0, len(runs))
+	for _, run := range runs {
+		start, end := run.Start, run.Start+len(run.Text)
+		for start < end {
+			kind, stop := TextSpan, end
+			for _, link := range links {
+				if link[0] <= start && start < link[1] {
+					kind = LinkSpan
+					stop = min(stop, link[1])
+				} else if start < link[0] && link[0] < stop {
+					stop = link[0]
+				}
+			}
+			spans = append(spans, Span{
+				Kind:   kind,
+				Text:   run.Text[start-run.Start : stop-run.Start],
+				Styles: run.Styles,
+				Start:  start,
+			})
+			start = stop
+		}
+	}
+	return spans
+}
+
+// Text returns the content of the message as plain text, without any styles
+func (m *Message) Text() string {
+	var b strings.Builder
+	for _, span := range m.contentSpans() {
+		b.WriteString(span.Text)
+	}
+	return b.String()
+}
+
+// MaskedText returns the content of the message as plain text, like Text, with every spoiler
+// covered up by `glyph`, one for each character. Use it wherever a message is shown outside the
+// conversation, where spoilers can't be revealed.
+func (m *Message) MaskedText(glyph string) string {
+	var b strings.Builder
+	for _, span := range m.contentSpans() {
+		if span.Has(StyleSpoiler) {
+			b.WriteString(strings.Repeat(glyph, utf8.RuneCountInString(span.Text)))
+		} else {
+			b.WriteString(span.Text)
+		}
+	}
+	return b.String()
+}
+
+// Links returns the links in the message
+func (m *Message) Links() []string {
+	if m.IsDeleted {
+		return nil
+	}
+	return linkPattern.FindAllString(m.Content, -1)
+}
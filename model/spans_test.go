@@ -0,0 +1,48 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpans(t *testing.T) {
+	leeloo := &Contact{Name: "Leeloo [red]"}
+	content, styles := ParseMarkup("see *https://example.com/multipass* [red]now[\"x\"]")
+	msg := &Message{
+		Content:     content,
+		Styles:      styles,
+		FromContact: leeloo,
+		Quote:       &Quote{Text: "where?"},
+		Attachments: []*Attachment{{Filename: "pass.jpg"}},
+	}
+	assert.Equal(t, []Span{
+		{Kind: SenderSpan, Text: "Leeloo [red]"},
+		{Kind: QuoteSpan, Text: "where?"},
+		{Kind: TextSpan, Text: "see ", Start: 0},
+		{Kind: LinkSpan, Text: "https://example.com/multipass", Styles: []string{StyleBold}, Start: 4},
+		{Kind: TextSpan, Text: " [red]now[\"x\"]", Start: 33},
+		{Kind: AttachmentSpan, Text: "pass.jpg", Attachment: msg.Attachments[0]},
+	}, msg.Spans())
+	assert.Equal(t, []string{"https://example.com/multipass"}, msg.Links())
+	assert.Equal(t, content, msg.Text())
+
+	// nothing from the message is taken for tags
+	rendered := msg.Render(DefaultTheme())
+	assert.Contains(t, rendered, `Leeloo [red[]`)
+	assert.Contains(t, rendered, `[red[]now["x"[]`)
+
+	msg.IsDeleted = true
+	assert.Empty(t, msg.Links())
+	assert.Equal(t, DeletedContent, msg.Text())
+}
+
+func TestMaskedText(t *testing.T) {
+	content, styles := ParseMarkup("the *password* is ||multipass||, ||é||!")
+	msg := &Message{Content: content, Styles: styles}
+	assert.Equal(t, "the password is ▒▒▒▒▒▒▒▒▒, ▒!", msg.MaskedText("▒"))
+	assert.Equal(t, "the password is multipass, é!", msg.Text())
+
+	msg.IsDeleted = true
+	assert.Equal(t, DeletedContent, msg.MaskedText("▒"))
+}
@@ -0,0 +1,391 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// JSONLStoreName is the default store, one file of JSON lines per conversation
+	JSONLStoreName = "jsonl"
+	// SQLiteStoreName keeps every conversation in a single SQLite database
+	SQLiteStoreName = "sqlite"
+)
+
+// Store is where conversations are saved. Conversations are identified by the number (or group ID)
+// of their contact, and messages within them by their MessageKey.
+type Store interface {
+	// Append adds new messages to the end of a conversation
+	Append(conv PhoneNumber, msgs ...*Message) error
+	// Update replaces messages that are already saved, for example after a receipt or an edit
+	Update(conv PhoneNumber, msgs ...*Message) error
+	// Replace overwrites a whole conversation
+	Replace(conv PhoneNumber, msgs []*Message) error
+	// Range returns the messages in a conversation sent between `since` and `until` (inclusive,
+	// in ms), oldest first
+	Range(conv PhoneNumber, since, until int64) ([]*Message, error)
+	// Page returns up to `limit` of the messages in a conversation that come before `before`,
+	// oldest first. A zero `before` pages back from the most recent message.
+	Page(conv PhoneNumber, before MessageKey, limit int) ([]*Message, error)
+	// Conversations lists the conversations that have saved messages
+	Conversations() ([]PhoneNumber, error)
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// OpenStore opens the store with the name `name` in its default location. If `c` is not nil,
+// messages are encrypted with it.
+func OpenStore(name string, c *Cipher) (Store, error) {
+	switch name {
+	case "", JSONLStoreName:
+		return NewJSONLStore(ConversationFolder(), c), nil
+	case SQLiteStoreName:
+		return NewSQLiteStore(SQLiteStorePath(), c)
+	}
+	return nil, fmt.Errorf("unknown message store: %s", name)
+}
+
+// keyBefore returns true if `a` sorts before `b`. Messages are ordered by time, and then by author
+// to break ties.
+func keyBefore(a, b MessageKey) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return a.Author < b.Author
+}
+
+// JSONLStore saves each conversation as a file of JSON lines, one message per line, oldest first.
+// This is the format siggo has always used, though older versions wrote messages in the order they
+// were added.
+type JSONLStore struct {
+	folder string
+	cipher *Cipher
+}
+
+func (st *JSONLStore) path(conv PhoneNumber) string {
+	return filepath.Join(st.folder, conv)
+}
+
+// Append adds messages to the end of the conversation file. If any of them are older than the last
+// message in it, the file is rewritten to keep it in order.
+func (st *JSONLStore) Append(conv PhoneNumber, msgs ...*Message) error {
+	if err := os.MkdirAll(st.folder, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(st.path(conv), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// don't glue new messages onto a line that was cut off by a crash
+	if err := trimTornLine(f, st.cipher); err != nil {
+		return err
+	}
+	last, err := lastMessage(f, st.cipher)
+	if err != nil {
+		return err
+	}
+	for i, msg := range msgs {
+		if (last != nil && keyBefore(msg.Key(), last.Key())) || (i > 0 && keyBefore(msg.Key(), msgs[i-1].Key())) {
+			saved, err := readMessagesFile(st.path(conv), st.cipher)
+			if err != nil {
+				return err
+			}
+			return st.Replace(conv, append(saved, msgs...))
+		}
+	}
+	if err := writeMessages(f, msgs, st.cipher); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Update rewrites the conversation file with the updated messages
+func (st *JSONLStore) Update(conv PhoneNumber, msgs ...*Message) error {
+	saved, err := readMessagesFile(st.path(conv), st.cipher)
+	if err != nil {
+		return err
+	}
+	updates := make(map[MessageKey]*Message, len(msgs))
+	for _, msg := range msgs {
+		updates[msg.Key()] = msg
+	}
+	for i, msg := range saved {
+		if updated, ok := updates[msg.Key()]; ok {
+			saved[i] = updated
+		}
+	}
+	return st.Replace(conv, saved)
+}
+
+// Replace overwrites the conversation file
+func (st *JSONLStore) Replace(conv PhoneNumber, msgs []*Message) error {
+	if err := os.MkdirAll(st.folder, os.ModePerm); err != nil {
+		return err
+	}
+	sorted := make([]*Message, len(msgs))
+	copy(sorted, msgs)
+	sortMessages(sorted)
+	return writeMessagesFile(st.path(conv), sorted, st.cipher)
+}
+
+// Range reads the conversation file and returns the messages between `since` and `until`. They
+// are sorted, since files written by older versions may not be.
+func (st *JSONLStore) Range(conv PhoneNumber, since, until int64) ([]*Message, error) {
+	saved, err := readMessagesFile(st.path(conv), st.cipher)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Message, 0)
+	for _, msg := range saved {
+		if msg.Timestamp >= since && msg.Timestamp <= until {
+			out = append(out, msg)
+		}
+	}
+	sortMessages(out)
+	return out, nil
+}
+
+// Page returns the page of messages before `before`. The conversation file is read backwards from
+// the end, only as far as the page goes. If the lines read are out of order, it is read in full.
+func (st *JSONLStore) Page(conv PhoneNumber, before MessageKey, limit int) ([]*Message, error) {
+	if limit <= 0 {
+		return st.pageAll(conv, before, limit)
+	}
+	f, err := os.Open(st.path(conv))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if before == (MessageKey{}) {
+		before = MessageKey{Timestamp: math.MaxInt64}
+	}
+	out := make([]*Message, 0, limit)
+	var next *Message
+	sorted := true
+	err = readMessagesBackward(f, st.cipher, func(msg *Message) bool {
+		if next != nil && keyBefore(next.Key(), msg.Key()) {
+			sorted = false
+			return false
+		}
+		next = msg
+		if keyBefore(msg.Key(), before) {
+			out = append(out, msg)
+		}
+		return len(out) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !sorted {
+		return st.pageAll(conv, before, limit)
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
+
+// pageAll reads the whole conversation file and returns the page of messages before `before`
+func (st *JSONLStore) pageAll(conv PhoneNumber, before MessageKey, limit int) ([]*Message, error) {
+	saved, err := readMessagesFile(st.path(conv), st.cipher)
+	if err != nil {
+		return nil, err
+	}
+	return pageMessages(saved, before, limit), nil
+}
+
+// Conversations lists the conversation files
+func (st *JSONLStore) Conversations() ([]PhoneNumber, error) {
+	files, err := ioutil.ReadDir(st.folder)
+	if os.IsNotExist(err) {
+		return []PhoneNumber{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	out := make([]PhoneNumber, 0, len(files))
+	for _, f := range files {
+		if !f.IsDir() {
+			out = append(out, f.Name())
+		}
+	}
+	return out, nil
+}
+
+// Close does nothing, files are only open while we use them
+func (st *JSONLStore) Close() error {
+	return nil
+}
+
+// NewJSONLStore creates a store that keeps conversation files in `folder`, encrypting each line
+// with `c` if it is not nil
+func NewJSONLStore(folder string, c *Cipher) *JSONLStore {
+	return &JSONLStore{folder: folder, cipher: c}
+}
+
+// sortMessages sorts messages oldest first
+func sortMessages(msgs []*Message) {
+	sort.SliceStable(msgs, func(i, j int) bool { return keyBefore(msgs[i].Key(), msgs[j].Key()) })
+}
+
+// pageMessages returns up to `limit` messages that sort before `before`, oldest first
+func pageMessages(msgs []*Message, before MessageKey, limit int) []*Message {
+	sorted := make([]*Message, len(msgs))
+	copy(sorted, msgs)
+	sortMessages(sorted)
+	if before == (MessageKey{}) {
+		before = MessageKey{Timestamp: math.MaxInt64}
+	}
+	end := sort.Search(len(sorted), func(i int) bool { return !keyBefore(sorted[i].Key(), before) })
+	start := end - limit
+	if limit <= 0 || start < 0 {
+		start = 0
+	}
+	return sorted[start:end]
+}
+
+// readMessagesFile reads every message in a JSON lines file. Encrypted lines need `c`. A broken
+// last line is what a crash in the middle of appending leaves behind, so it is skipped rather than
+// losing the whole conversation.
+func readMessagesFile(path string, c *Cipher) ([]*Message, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := make([]*Message, 0)
+	var lineErr error
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		if lineErr != nil {
+			// the broken line wasn't the last one
+			return nil, lineErr
+		}
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		msg := &Message{}
+		if err := decodeRecord(s.Text(), msg, c); err == ErrLocked {
+			return nil, err
+		} else if err != nil {
+			lineErr = err
+			continue
+		}
+		out = append(out, msg)
+	}
+	if lineErr != nil {
+		log.Warnf("skipping incomplete last line of %s: %v", path, lineErr)
+	}
+	return out, s.Err()
+}
+
+// readMessagesBackward calls `fn` with each message in a JSON lines file, last first, until it
+// returns false. Like readMessagesFile, a broken last line is skipped.
+func readMessagesBackward(f *os.File, c *Cipher, fn func(msg *Message) bool) error {
+	first := true
+	var decodeErr error
+	err := readLinesBackward(f, func(line []byte) bool {
+		msg := &Message{}
+		if err := decodeRecord(string(line), msg, c); err != nil {
+			if err != ErrLocked && first {
+				log.Warnf("skipping incomplete last line of %s: %v", f.Name(), err)
+				first = false
+				return true
+			}
+			decodeErr = err
+			return false
+		}
+		first = false
+		return fn(msg)
+	})
+	if err != nil {
+		return err
+	}
+	return decodeErr
+}
+
+// lastMessage returns the last message in a JSON lines file, or nil if there isn't one
+func lastMessage(f *os.File, c *Cipher) (*Message, error) {
+	var last *Message
+	err := readMessagesBackward(f, c, func(msg *Message) bool {
+		last = msg
+		return false
+	})
+	return last, err
+}
+
+// readLinesBackward calls `fn` with each non-empty line of `f`, last first, until it returns
+// false. The line is only valid until `fn` returns.
+func readLinesBackward(f *os.File, fn func(line []byte) bool) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+	// rest is the start of the file we've read that hasn't been passed to fn yet
+	var rest []byte
+	for end > 0 {
+		n := int64(64 * 1024)
+		if end < n {
+			n = end
+		}
+		end -= n
+		chunk := make([]byte, n)
+		if _, err := f.ReadAt(chunk, end); err != nil {
+			return err
+		}
+		rest = append(chunk, rest...)
+		for i := bytes.LastIndexByte(rest, '\n'); i >= 0; i = bytes.LastIndexByte(rest, '\n') {
+			if line := rest[i+1:]; len(line) > 0 && !fn(line) {
+				return nil
+			}
+			rest = rest[:i]
+		}
+	}
+	if len(rest) > 0 {
+		fn(rest)
+	}
+	return nil
+}
+
+// writeMessagesFile atomically replaces `path` with messages as JSON lines
+func writeMessagesFile(path string, msgs []*Message, c *Cipher) error {
+	return writeFileAtomic(path, 0600, func(f *os.File) error {
+		return writeMessages(f, msgs, c)
+	})
+}
+
+func writeMessages(f *os.File, msgs []*Message, c *Cipher) error {
+	w := bufio.NewWriter(f)
+	for _, msg := range msgs {
+		line, err := encodeRecord(msg, c)
+		if err != nil {
+			return err
+		}
+		w.WriteString(line)
+		w.WriteByte('\n')
+	}
+	return w.Flush()
+}
+
+// CopyConversation copies every message in a conversation from one store to another, replacing
+// whatever the destination had. Returns the number of messages copied.
+func CopyConversation(from, to Store, conv PhoneNumber) (int, error) {
+	msgs, err := from.Range(conv, 0, math.MaxInt64)
+	if err != nil {
+		return 0, err
+	}
+	if err := to.Replace(conv, msgs); err != nil {
+		return 0, err
+	}
+	return len(msgs), nil
+}
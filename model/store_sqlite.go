@@ -0,0 +1,218 @@
+package model
+
+import (
+	"database/sql"
+	"math"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite" // pure-go, so we don't need cgo
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	conversation TEXT NOT NULL,
+	author TEXT NOT NULL,
+	timestamp INTEGER NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (conversation, timestamp, author)
+);`
+
+// SQLiteStore keeps every conversation in one SQLite database. Messages are stored as JSON, keyed
+// by conversation, timestamp and author, so appends and updates don't rewrite anything else.
+type SQLiteStore struct {
+	db     *sql.DB
+	cipher *Cipher
+}
+
+// Append inserts new messages. Appending a message that is already saved replaces it.
+func (st *SQLiteStore) Append(conv PhoneNumber, msgs ...*Message) error {
+	return st.upsert(conv, msgs)
+}
+
+// Update replaces messages that are already saved. Messages that aren't saved yet are inserted,
+// rather than lost.
+func (st *SQLiteStore) Update(conv PhoneNumber, msgs ...*Message) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`UPDATE messages SET data = ?
+		WHERE conversation = ? AND timestamp = ? AND author = ?`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	missing := make([]*Message, 0)
+	for _, msg := range msgs {
+		data, err := encodeRecord(msg, st.cipher)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		res, err := stmt.Exec(data, conv, msg.Timestamp, msg.Author)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			tx.Rollback()
+			return err
+		} else if n == 0 {
+			missing = append(missing, msg)
+		}
+	}
+	if err := st.insert(tx, conv, missing); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Replace deletes a conversation and saves `msgs` in its place, all at once, so a failure leaves
+// the conversation as it was
+func (st *SQLiteStore) Replace(conv PhoneNumber, msgs []*Message) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE conversation = ?`, conv); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := st.insert(tx, conv, msgs); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (st *SQLiteStore) upsert(conv PhoneNumber, msgs []*Message) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := st.insert(tx, conv, msgs); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// insert inserts messages as part of `tx`, replacing any that are already saved
+func (st *SQLiteStore) insert(tx *sql.Tx, conv PhoneNumber, msgs []*Message) error {
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO messages (conversation, author, timestamp, data)
+		VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, msg := range msgs {
+		data, err := encodeRecord(msg, st.cipher)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(conv, msg.Author, msg.Timestamp, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Range returns the messages between `since` and `until`
+func (st *SQLiteStore) Range(conv PhoneNumber, since, until int64) ([]*Message, error) {
+	rows, err := st.db.Query(`SELECT data FROM messages
+		WHERE conversation = ? AND timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp, author`, conv, since, until)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows, false, st.cipher)
+}
+
+// Page returns up to `limit` messages before `before`
+func (st *SQLiteStore) Page(conv PhoneNumber, before MessageKey, limit int) ([]*Message, error) {
+	if before == (MessageKey{}) {
+		before = MessageKey{Timestamp: math.MaxInt64}
+	}
+	if limit <= 0 {
+		limit = -1 // no limit
+	}
+	rows, err := st.db.Query(`SELECT data FROM messages
+		WHERE conversation = ? AND (timestamp < ? OR (timestamp = ? AND author < ?))
+		ORDER BY timestamp DESC, author DESC LIMIT ?`,
+		conv, before.Timestamp, before.Timestamp, before.Author, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows, true, st.cipher)
+}
+
+// Conversations lists every conversation with saved messages
+func (st *SQLiteStore) Conversations() ([]PhoneNumber, error) {
+	rows, err := st.db.Query(`SELECT DISTINCT conversation FROM messages`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]PhoneNumber, 0)
+	for rows.Next() {
+		var conv string
+		if err := rows.Scan(&conv); err != nil {
+			return nil, err
+		}
+		out = append(out, conv)
+	}
+	return out, rows.Err()
+}
+
+// Close closes the database
+func (st *SQLiteStore) Close() error {
+	return st.db.Close()
+}
+
+// scanMessages reads messages from rows of (possibly encrypted) JSON, optionally reversing them
+func scanMessages(rows *sql.Rows, reverse bool, c *Cipher) ([]*Message, error) {
+	defer rows.Close()
+	out := make([]*Message, 0)
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		msg := &Message{}
+		if err := decodeRecord(data, msg, c); err != nil {
+			return nil, err
+		}
+		out = append(out, msg)
+	}
+	if reverse {
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+	}
+	return out, rows.Err()
+}
+
+// NewSQLiteStore opens (or creates) a SQLite store @ `path`. If `c` is not nil, the data of each
+// message is encrypted with it. Conversation, author and timestamp are kept in the clear so that
+// the database can still be indexed.
+func NewSQLiteStore(path string, c *Cipher) (*SQLiteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	// wait for other siggo processes (like `siggo search`) instead of failing with SQLITE_BUSY, and
+	// use WAL so that they can read while we write
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite doesn't do concurrent writers, so don't pretend
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db, cipher: c}, nil
+}
@@ -0,0 +1,322 @@
+package model
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T, st Store) {
+	conv := "+15550000001"
+	msgs := []*Message{
+		{Content: "one", Timestamp: 1, Author: conv},
+		{Content: "two", Timestamp: 2, Author: conv},
+		{Content: "two too", Timestamp: 2, Author: "+15559999999", FromSelf: true},
+		{Content: "three", Timestamp: 3, Author: conv},
+	}
+	if err := st.Append(conv, msgs[:2]...); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if err := st.Append(conv, msgs[2:]...); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	updated := *msgs[2]
+	updated.IsRead = true
+	if err := st.Update(conv, &updated); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+
+	got, err := st.Range(conv, 2, 3)
+	if err != nil {
+		t.Fatalf("range failed: %v", err)
+	}
+	assert.Len(t, got, 3)
+	assert.True(t, got[1].IsRead)
+
+	// an older message saved late, like one imported from a backup, still comes first
+	if err := st.Append(conv, &Message{Content: "zero", Timestamp: 0, Author: conv}); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	got, err = st.Range(conv, 0, math.MaxInt64)
+	if err != nil {
+		t.Fatalf("range failed: %v", err)
+	}
+	contents := make([]string, 0, len(got))
+	for _, msg := range got {
+		contents = append(contents, msg.Content)
+	}
+	assert.Equal(t, []string{"zero", "one", "two", "two too", "three"}, contents)
+
+	page, err := st.Page(conv, MessageKey{}, 2)
+	if err != nil {
+		t.Fatalf("page failed: %v", err)
+	}
+	assert.Len(t, page, 2)
+	assert.Equal(t, "two too", page[0].Content)
+	assert.Equal(t, "three", page[1].Content)
+
+	page, err = st.Page(conv, page[0].Key(), 10)
+	if err != nil {
+		t.Fatalf("page failed: %v", err)
+	}
+	assert.Len(t, page, 3)
+	assert.Equal(t, "zero", page[0].Content)
+	assert.Equal(t, "two", page[2].Content)
+
+	if err := st.Replace(conv, msgs); err != nil {
+		t.Fatalf("replace failed: %v", err)
+	}
+	got, err = st.Range(conv, 0, math.MaxInt64)
+	assert.NoError(t, err)
+	assert.Len(t, got, 4)
+
+	convs, err := st.Conversations()
+	if err != nil {
+		t.Fatalf("conversations failed: %v", err)
+	}
+	assert.Equal(t, []PhoneNumber{conv}, convs)
+}
+
+func TestJSONLStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testStore(t, NewJSONLStore(dir, nil))
+}
+
+func TestSQLiteStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st, err := NewSQLiteStore(filepath.Join(dir, "siggo.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	testStore(t, st)
+}
+
+func TestSQLiteStoreFailedReplace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st, err := NewSQLiteStore(filepath.Join(dir, "siggo.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	conv := "+15550000001"
+	if err := st.Append(conv, &Message{Content: "one", Timestamp: 1, Author: conv}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = st.db.Exec(`CREATE TRIGGER fail BEFORE INSERT ON messages WHEN NEW.timestamp = 3
+		BEGIN SELECT RAISE(ABORT, 'disk full'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Replace(conv, []*Message{
+		{Content: "two", Timestamp: 2, Author: conv},
+		{Content: "three", Timestamp: 3, Author: conv},
+	})
+	assert.Error(t, err)
+	// the conversation is as it was, not empty or half replaced
+	got, err := st.Range(conv, 0, math.MaxInt64)
+	assert.NoError(t, err)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "one", got[0].Content)
+	}
+}
+
+func TestLoadOlder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st := NewJSONLStore(dir, nil)
+	contact := &Contact{Number: "+15550000001"}
+	msgs := make([]*Message, 0)
+	for ts := int64(1); ts <= 5; ts++ {
+		msgs = append(msgs, &Message{Timestamp: ts, Author: contact.Number})
+	}
+	if err := st.Append(contact.Number, msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.MaxConversationLength = 2
+	conv := NewConversation(contact)
+	if err := conv.Load(st, cfg); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []MessageKey{{contact.Number, 4}, {contact.Number, 5}}, conv.MessageOrder)
+
+	n, err := conv.LoadOlder(st, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.False(t, conv.LoadedAll())
+	n, err = conv.LoadOlder(st, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.True(t, conv.LoadedAll())
+	assert.Equal(t, int64(1), conv.Messages[conv.MessageOrder[0]].Timestamp)
+	assert.Len(t, conv.MessageOrder, 5)
+}
+
+func TestJSONLStoreTornWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st := NewJSONLStore(dir, nil)
+	conv := "+15550000001"
+	if err := st.Append(conv, &Message{Timestamp: 1, Author: conv}); err != nil {
+		t.Fatal(err)
+	}
+	// a crash in the middle of appending
+	f, err := os.OpenFile(filepath.Join(dir, conv), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"content": "multi`)
+	f.Close()
+
+	msgs, err := st.Range(conv, 0, math.MaxInt64)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	if err := st.Append(conv, &Message{Timestamp: 2, Author: conv}); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err = st.Range(conv, 0, math.MaxInt64)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+
+	// a whole message that is only missing its newline is kept
+	other := "+15550000002"
+	line := `{"content": "multipass", "timestamp": 1, "author": "+15550000002"}`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, other), []byte(line), 0600))
+	if err := st.Append(other, &Message{Timestamp: 2, Author: other}); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err = st.Range(other, 0, math.MaxInt64)
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, "multipass", msgs[0].Content)
+	}
+}
+
+func TestJSONLStorePageBackward(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st := NewJSONLStore(dir, nil)
+	conv := "+15550000001"
+	// long enough that pages span the chunks the file is read in
+	msgs := make([]*Message, 0)
+	for ts := int64(1); ts <= 300; ts++ {
+		msgs = append(msgs, &Message{Content: strings.Repeat("x", 1000), Timestamp: ts, Author: conv})
+	}
+	if err := st.Append(conv, msgs...); err != nil {
+		t.Fatal(err)
+	}
+	page, err := st.Page(conv, MessageKey{conv, 200}, 100)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 100) {
+		assert.Equal(t, int64(100), page[0].Timestamp)
+		assert.Equal(t, int64(199), page[99].Timestamp)
+	}
+
+	// a file written out of order by an older version is read in full
+	if err := writeMessagesFile(st.path(conv), []*Message{msgs[0], msgs[2], msgs[1]}, nil); err != nil {
+		t.Fatal(err)
+	}
+	page, err = st.Page(conv, MessageKey{}, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 2) {
+		assert.Equal(t, int64(2), page[0].Timestamp)
+		assert.Equal(t, int64(3), page[1].Timestamp)
+	}
+}
+
+func TestRewriteKeepsHistoryUnloaded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st := NewJSONLStore(dir, nil)
+	contact := &Contact{Number: "+15550000001"}
+	for ts := int64(1); ts <= 5; ts++ {
+		if err := st.Append(contact.Number, &Message{Timestamp: ts, Author: contact.Number}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := DefaultConfig()
+	cfg.MaxConversationLength = 2
+	conv := NewConversation(contact)
+	if err := conv.Load(st, cfg); err != nil {
+		t.Fatal(err)
+	}
+	conv.updateTimestamp(conv.LastMessage(), 6)
+	assert.NoError(t, conv.Save(st))
+	// only what was loaded stays loaded
+	assert.Len(t, conv.MessageOrder, 2)
+	assert.False(t, conv.LoadedAll())
+	saved, err := st.Range(contact.Number, 0, math.MaxInt64)
+	assert.NoError(t, err)
+	timestamps := make([]int64, 0, len(saved))
+	for _, msg := range saved {
+		timestamps = append(timestamps, msg.Timestamp)
+	}
+	assert.Equal(t, []int64{1, 2, 3, 4, 6}, timestamps)
+}
+
+func TestSQLiteStoreUpdateMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st, err := NewSQLiteStore(filepath.Join(dir, "siggo.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	var mode string
+	assert.NoError(t, st.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode))
+	assert.Equal(t, "wal", mode)
+	var timeout int
+	assert.NoError(t, st.db.QueryRow(`PRAGMA busy_timeout`).Scan(&timeout))
+	assert.Equal(t, 5000, timeout)
+
+	conv := "+15550000001"
+	if err := st.Append(conv, &Message{Content: "one", Timestamp: 1, Author: conv}); err != nil {
+		t.Fatal(err)
+	}
+	// an update to a message that was never saved saves it
+	assert.NoError(t, st.Update(conv,
+		&Message{Content: "uno", Timestamp: 1, Author: conv},
+		&Message{Content: "two", Timestamp: 2, Author: conv}))
+	got, err := st.Range(conv, 0, math.MaxInt64)
+	assert.NoError(t, err)
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, "uno", got[0].Content)
+		assert.Equal(t, "two", got[1].Content)
+	}
+}
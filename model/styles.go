@@ -0,0 +1,207 @@
+package model
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/derricw/siggo/signal"
+)
+
+// The styles text can have, as Signal calls them
+const (
+	StyleBold          = signal.StyleBold
+	StyleItalic        = signal.StyleItalic
+	StyleStrikethrough = signal.StyleStrikethrough
+	StyleSpoiler       = signal.StyleSpoiler
+	StyleMonospace     = signal.StyleMonospace
+)
+
+// TextStyle styles part of a message's content. Like in Signal, Start and Length count UTF-16 code
+// units, not bytes or runes.
+type TextStyle struct {
+	Style  string `json:"style"`
+	Start  int    `json:"start"`
+	Length int    `json:"length"`
+}
+
+// StyledText is a run of a message's content and the styles it has
+type StyledText struct {
+	Text   string
+	Styles []string
+	// Start is where the run starts in the content, in bytes
+	Start int
+}
+
+// Has returns true if the run has `style`
+func (t *StyledText) Has(style string) bool {
+	for _, s := range t.Styles {
+		if s == style {
+			return true
+		}
+	}
+	return false
+}
+
+// convertTextStyles converts text styles from signal-cli
+func convertTextStyles(styles []*signal.TextStyle) []*TextStyle {
+	if len(styles) == 0 {
+		return nil
+	}
+	out := make([]*TextStyle, 0, len(styles))
+	for _, s := range styles {
+		out = append(out, &TextStyle{Style: s.Style, Start: s.Start, Length: s.Length})
+	}
+	return out
+}
+
+// wireTextStyles converts text styles for signal-cli
+func wireTextStyles(styles []*TextStyle) []*signal.TextStyle {
+	out := make([]*signal.TextStyle, 0, len(styles))
+	for _, s := range styles {
+		out = append(out, &signal.TextStyle{Style: s.Style, Start: s.Start, Length: s.Length})
+	}
+	return out
+}
+
+// runeLen16 returns how many UTF-16 code units `r` takes up
+func runeLen16(r rune) int {
+	if utf16.IsSurrogate(r) || r < 0x10000 {
+		return 1
+	}
+	return 2
+}
+
+// utf16Len returns the length of `s` in UTF-16 code units
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeLen16(r)
+	}
+	return n
+}
+
+// byteOffset returns the byte offset in `s` of the UTF-16 code unit `offset`
+func byteOffset(s string, offset int) int {
+	n := 0
+	for i, r := range s {
+		if n >= offset {
+			return i
+		}
+		n += runeLen16(r)
+	}
+	return len(s)
+}
+
+// StyledContent splits the message's content into runs of text with the same styles. A deleted
+// message has no styles.
+func (m *Message) StyledContent() []StyledText {
+	if m.IsDeleted {
+		return []StyledText{{Text: DeletedContent}}
+	}
+	return Styled(m.Content, m.Styles)
+}
+
+// Styled splits `text` into runs with the same styles
+func Styled(text string, styles []*TextStyle) []StyledText {
+	type edge struct{ start, end int }
+	edges := make([]edge, len(styles))
+	cuts := []int{0, len(text)}
+	for i, s := range styles {
+		edges[i] = edge{byteOffset(text, s.Start), byteOffset(text, s.Start+s.Length)}
+		cuts = append(cuts, edges[i].start, edges[i].end)
+	}
+	sort.Ints(cuts)
+	runs := make([]StyledText, 0)
+	for i := 0; i+1 < len(cuts); i++ {
+		start, end := cuts[i], cuts[i+1]
+		if start == end {
+			continue
+		}
+		run := StyledText{Text: text[start:end], Start: start}
+		for j, s := range styles {
+			if edges[j].start <= start && end <= edges[j].end && !run.Has(s.Style) {
+				run.Styles = append(run.Styles, s.Style)
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// markup is how each style is typed: *bold*, _italic_, ~strikethrough~, `monospace` and
+// ||spoiler||
+var markup = []struct{ delimiter, style string }{
+	{"||", StyleSpoiler},
+	{"`", StyleMonospace},
+	{"*", StyleBold},
+	{"_", StyleItalic},
+	{"~", StyleStrikethrough},
+}
+
+// ParseMarkup turns markup like *bold* and _italic_ into text styles, returning the text without
+// the markup and its styles. Markup only counts at the edges of words, so snake_case_names and
+// 2*3*4 stay as they are. Nothing inside `monospace` is markup.
+func ParseMarkup(text string) (string, []*TextStyle) {
+	var b strings.Builder
+	styles := make([]*TextStyle, 0)
+	parseMarkup(text, &b, &styles)
+	return b.String(), styles
+}
+
+func parseMarkup(text string, b *strings.Builder, styles *[]*TextStyle) {
+	for i := 0; i < len(text); {
+		if delimiter, style, end := openMarkup(text, i); end >= 0 {
+			start := utf16Len(b.String())
+			inner := text[i+len(delimiter) : end]
+			if style == StyleMonospace {
+				b.WriteString(inner)
+			} else {
+				parseMarkup(inner, b, styles)
+			}
+			*styles = append(*styles, &TextStyle{Style: style, Start: start, Length: utf16Len(b.String()) - start})
+			i = end + len(delimiter)
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		b.WriteString(text[i : i+size])
+		i += size
+	}
+}
+
+// openMarkup returns the delimiter and style of markup starting at text[i], and where its closing
+// delimiter is, -1 if there isn't any markup there
+func openMarkup(text string, i int) (string, string, int) {
+	before, _ := utf8.DecodeLastRuneInString(text[:i])
+	if i > 0 && isWordRune(before) {
+		return "", "", -1
+	}
+	for _, m := range markup {
+		if !strings.HasPrefix(text[i:], m.delimiter) {
+			continue
+		}
+		inner := i + len(m.delimiter)
+		if first, _ := utf8.DecodeRuneInString(text[inner:]); inner == len(text) || unicode.IsSpace(first) {
+			continue
+		}
+		// the closing delimiter comes after some text, and is at the end of a word
+		for j := inner + 1; j < len(text); j++ {
+			if !strings.HasPrefix(text[j:], m.delimiter) {
+				continue
+			}
+			last, _ := utf8.DecodeLastRuneInString(text[:j])
+			after, _ := utf8.DecodeRuneInString(text[j+len(m.delimiter):])
+			if !unicode.IsSpace(last) && (j+len(m.delimiter) == len(text) || !isWordRune(after)) {
+				return m.delimiter, m.style, j
+			}
+		}
+	}
+	return "", "", -1
+}
+
+// isWordRune returns true if `r` can be part of a word
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMarkup(t *testing.T) {
+	text, styles := ParseMarkup("*big* _bad_ ~wolf~ says `*hi*` and ||*boo*||")
+	assert.Equal(t, "big bad wolf says *hi* and boo", text)
+	assert.Equal(t, []*TextStyle{
+		{Style: StyleBold, Start: 0, Length: 3},
+		{Style: StyleItalic, Start: 4, Length: 3},
+		{Style: StyleStrikethrough, Start: 8, Length: 4},
+		{Style: StyleMonospace, Start: 18, Length: 4},
+		{Style: StyleBold, Start: 27, Length: 3},
+		{Style: StyleSpoiler, Start: 27, Length: 3},
+	}, styles)
+
+	// not markup
+	for _, s := range []string{"snake_case_name", "2*3*4", "a * b * c", "*open", "cp ~/a ~/b"} {
+		text, styles := ParseMarkup(s)
+		assert.Equal(t, s, text)
+		assert.Empty(t, styles)
+	}
+
+	// offsets count UTF-16 code units, and emoji take two
+	text, styles = ParseMarkup("🐺 *wolf*")
+	assert.Equal(t, "🐺 wolf", text)
+	assert.Equal(t, []*TextStyle{{Style: StyleBold, Start: 3, Length: 4}}, styles)
+	runs := Styled(text, styles)
+	assert.Equal(t, []StyledText{
+		{Text: "🐺 ", Start: 0},
+		{Text: "wolf", Styles: []string{StyleBold}, Start: 5},
+	}, runs)
+}
@@ -0,0 +1,380 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultThemeName is the theme used if none is configured
+const DefaultThemeName = "default"
+
+// maxThemeDepth is how many themes can be based on each other, so that a loop isn't endless
+const maxThemeDepth = 8
+
+// Theme is how siggo looks. Colors are W3C names or hex, like "orange" or "#ffa500". Styles are
+// tview style tags without the brackets, "foreground:background:attributes", like "red::b" or
+// "::d". Glyphs are the little markers and icons shown here and there.
+//
+// A theme is based on another theme, and anything it leaves empty comes from that one. In the
+// config, `theme` is based on a built-in theme or a theme file, and only needs what it changes.
+type Theme struct {
+	// Base is the built-in theme, or the theme file in ThemeFolder() (without .yml), this one
+	// changes
+	Base string `yaml:"base,omitempty"`
+
+	// Background, Text, Border and Title are the colors of every panel, FocusBorder the border of
+	// the panel we are typing in or moving around
+	Background  string `yaml:"background,omitempty"`
+	Text        string `yaml:"text,omitempty"`
+	Border      string `yaml:"border,omitempty"`
+	FocusBorder string `yaml:"focus_border,omitempty"`
+	Title       string `yaml:"title,omitempty"`
+
+	// Message styles. Messages from contacts with a configured color use it as the foreground.
+	SelfMessage   string `yaml:"self_message,omitempty"`
+	OtherMessage  string `yaml:"other_message,omitempty"`
+	UnreadMessage string `yaml:"unread_message,omitempty"`
+	FailedMessage string `yaml:"failed_message,omitempty"`
+	Timestamp     string `yaml:"timestamp,omitempty"`
+	Attachment    string `yaml:"attachment,omitempty"`
+	// Preview is the style of link previews under messages
+	Preview string `yaml:"preview,omitempty"`
+	// SearchMatch is the background color of the current search match
+	SearchMatch string `yaml:"search_match,omitempty"`
+	// Text styles of messages, added to the style of the message
+	BoldText          string `yaml:"bold_text,omitempty"`
+	ItalicText        string `yaml:"italic_text,omitempty"`
+	StrikethroughText string `yaml:"strikethrough_text,omitempty"`
+	MonospaceText     string `yaml:"monospace_text,omitempty"`
+	SpoilerText       string `yaml:"spoiler_text,omitempty"`
+	// LinkText is the style of links in messages
+	LinkText string `yaml:"link_text,omitempty"`
+
+	// Contact list styles. ContactPreview is the last message shown under each name.
+	CurrentContact string `yaml:"current_contact,omitempty"`
+	UnreadContact  string `yaml:"unread_contact,omitempty"`
+	ContactPreview string `yaml:"contact_preview,omitempty"`
+
+	// Glyphs. The delivery and read glyphs are shown next to each of our messages, pending or
+	// failed in place of both.
+	DeliveredGlyph     string `yaml:"delivered_glyph,omitempty"`
+	UndeliveredGlyph   string `yaml:"undelivered_glyph,omitempty"`
+	ReadGlyph          string `yaml:"read_glyph,omitempty"`
+	UnreadGlyph        string `yaml:"unread_glyph,omitempty"`
+	PendingGlyph       string `yaml:"pending_glyph,omitempty"`
+	FailedGlyph        string `yaml:"failed_glyph,omitempty"`
+	SelfGlyph          string `yaml:"self_glyph,omitempty"`
+	ReplyGlyph         string `yaml:"reply_glyph,omitempty"`
+	AttachmentGlyph    string `yaml:"attachment_glyph,omitempty"`
+	LinkGlyph          string `yaml:"link_glyph,omitempty"`
+	ClipboardGlyph     string `yaml:"clipboard_glyph,omitempty"`
+	DraftGlyph         string `yaml:"draft_glyph,omitempty"`
+	UnreadContactGlyph string `yaml:"unread_contact_glyph,omitempty"`
+	// SeparatorGlyph is repeated across the line where a new day starts, SpoilerGlyph across
+	// spoilers that haven't been revealed. PreviewGlyph runs down the side of link previews.
+	SeparatorGlyph string `yaml:"separator_glyph,omitempty"`
+	SpoilerGlyph   string `yaml:"spoiler_glyph,omitempty"`
+	PreviewGlyph   string `yaml:"preview_glyph,omitempty"`
+}
+
+// Themes are the built-in themes
+var Themes = map[string]*Theme{
+	DefaultThemeName: {
+		Background:         "black",
+		Text:               "white",
+		Border:             "white",
+		FocusBorder:        "orange",
+		Title:              "white",
+		SelfMessage:        "::d",
+		OtherMessage:       "::",
+		UnreadMessage:      "::b",
+		FailedMessage:      "red::",
+		Timestamp:          "::",
+		Attachment:         "::",
+		Preview:            "::d",
+		SearchMatch:        "orange",
+		BoldText:           "::b",
+		ItalicText:         "::u",
+		StrikethroughText:  "::d",
+		MonospaceText:      "teal::",
+		SpoilerText:        "::r",
+		LinkText:           "::u",
+		CurrentContact:     "::r",
+		UnreadContact:      "::b",
+		ContactPreview:     "::d",
+		DeliveredGlyph:     DeliveryStatus[true],
+		UndeliveredGlyph:   DeliveryStatus[false],
+		ReadGlyph:          ReadStatus[true],
+		UnreadGlyph:        ReadStatus[false],
+		PendingGlyph:       PendingStatus,
+		FailedGlyph:        FailedStatus,
+		SelfGlyph:          "~",
+		ReplyGlyph:         "↪",
+		AttachmentGlyph:    "📎",
+		LinkGlyph:          "📂",
+		ClipboardGlyph:     "📋",
+		DraftGlyph:         "~",
+		UnreadContactGlyph: "*",
+		SeparatorGlyph:     "─",
+		SpoilerGlyph:       "▒",
+		PreviewGlyph:       "▎",
+	},
+	"gruvbox": {
+		Base:          DefaultThemeName,
+		Background:    "#282828",
+		Text:          "#ebdbb2",
+		Border:        "#928374",
+		FocusBorder:   "#fe8019",
+		Title:         "#fabd2f",
+		SelfMessage:   "#a89984::",
+		OtherMessage:  "#ebdbb2::",
+		UnreadMessage: "#fbf1c7::b",
+		FailedMessage: "#fb4934::",
+		Timestamp:     "#928374::",
+		Attachment:    "#83a598::",
+		Preview:       "#a89984::",
+		SearchMatch:   "#d65d0e",
+		MonospaceText: "#8ec07c::",
+	},
+	// ascii is for terminals without emoji or unicode fonts
+	"ascii": {
+		Base:             DefaultThemeName,
+		DeliveredGlyph:   "d",
+		UndeliveredGlyph: "-",
+		ReadGlyph:        "r",
+		UnreadGlyph:      "-",
+		PendingGlyph:     "..",
+		FailedGlyph:      "!!",
+		ReplyGlyph:       ">",
+		AttachmentGlyph:  "@",
+		LinkGlyph:        "#",
+		ClipboardGlyph:   "+",
+		SeparatorGlyph:   "-",
+		SpoilerGlyph:     "#",
+		PreviewGlyph:     "|",
+	},
+}
+
+// ThemeNames returns the names of the built-in themes, sorted
+func ThemeNames() []string {
+	names := make([]string, 0, len(Themes))
+	for name := range Themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ThemeFolder returns the folder where theme files are kept
+func ThemeFolder() string {
+	return filepath.Join(FindConfigFolder(), "themes")
+}
+
+// DefaultTheme returns the default theme
+func DefaultTheme() *Theme {
+	t := *Themes[DefaultThemeName]
+	return &t
+}
+
+// LoadTheme returns the complete theme `t` describes, with everything it leaves empty filled in
+// from the themes it is based on. A nil theme is the default theme.
+func LoadTheme(t *Theme) (*Theme, error) {
+	if t == nil {
+		return DefaultTheme(), nil
+	}
+	full, err := loadTheme(t, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := full.check(); err != nil {
+		return nil, err
+	}
+	return full, nil
+}
+
+func loadTheme(t *Theme, depth int) (*Theme, error) {
+	out := *t
+	out.Base = ""
+	if t == Themes[DefaultThemeName] {
+		// every theme ends up based on the default theme, which has everything
+		return &out, nil
+	}
+	if depth > maxThemeDepth {
+		return nil, fmt.Errorf("themes are based on each other in a loop")
+	}
+	name := t.Base
+	if name == "" {
+		name = DefaultThemeName
+	}
+	base, ok := Themes[name]
+	if !ok {
+		var err error
+		if base, err = readThemeFile(filepath.Join(ThemeFolder(), name+".yml")); err != nil {
+			return nil, fmt.Errorf("no such theme: %s (%v)", name, err)
+		}
+	}
+	full, err := loadTheme(base, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	out.fill(full)
+	return &out, nil
+}
+
+// readThemeFile reads a theme file, which looks like the theme section of the config
+func readThemeFile(path string) (*Theme, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("not built in, and no theme file @ %s", path)
+		}
+		return nil, err
+	}
+	t := &Theme{}
+	if err := yaml.Unmarshal(b, t); err != nil {
+		return nil, fmt.Errorf("bad theme file @ %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// themeColors are the keys of a theme that are colors, the rest are styles or glyphs
+var themeColors = map[string]bool{
+	"background":   true,
+	"text":         true,
+	"border":       true,
+	"focus_border": true,
+	"title":        true,
+	"search_match": true,
+}
+
+// check returns an error naming the first key with a color we don't know, rather than let it
+// quietly turn into the terminal's default color
+func (t *Theme) check() error {
+	v := reflect.ValueOf(t).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		value := v.Field(i).String()
+		switch {
+		case key == "base" || strings.HasSuffix(key, "_glyph"):
+			continue
+		case themeColors[key]:
+			if !isColor(value) {
+				return fmt.Errorf("bad theme color for %s: %q", key, value)
+			}
+		default:
+			// the foreground and background of a style can also be left as is, or reset with -
+			parts := strings.SplitN(value, ":", 3)
+			for _, color := range parts[:min(len(parts), 2)] {
+				if color != "" && color != "-" && !isColor(color) {
+					return fmt.Errorf("bad theme color for %s: %q in %q", key, color, value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isColor returns whether `name` is a color name we know, a hex color like "#ffa500", or
+// "default", the terminal's own color
+func isColor(name string) bool {
+	if _, ok := tcell.ColorNames[name]; ok || name == "default" {
+		return true
+	}
+	if len(name) == 7 && name[0] == '#' {
+		_, err := strconv.ParseUint(name[1:], 16, 32)
+		return err == nil
+	}
+	return false
+}
+
+// fill fills in everything left empty from `base`
+func (t *Theme) fill(base *Theme) {
+	v := reflect.ValueOf(t).Elem()
+	b := reflect.ValueOf(base).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Kind() == reflect.String && v.Field(i).String() == "" {
+			v.Field(i).SetString(b.Field(i).String())
+		}
+	}
+}
+
+// MessageTag returns the tview tag for how a message is styled, with any other `styles` added,
+// after resetting whatever came before
+func (t *Theme) MessageTag(m *Message, styles ...string) string {
+	style := t.OtherMessage
+	if m.IsFailed {
+		style = t.FailedMessage
+	} else if m.FromSelf {
+		style = t.SelfMessage
+	} else if !m.IsRead {
+		// bold messages that haven't been read
+		style = t.UnreadMessage
+	}
+	tag := StyleTag(CombineStyles(append([]string{style}, styles...)...))
+	if !m.FromSelf && !m.IsFailed && m.FromContact.Color() != "" {
+		tag += fmt.Sprintf("[%s]", m.FromContact.Color())
+	}
+	return tag
+}
+
+// TextStyle returns the theme style for a text style, like StyleBold
+func (t *Theme) TextStyle(style string) string {
+	switch style {
+	case StyleBold:
+		return t.BoldText
+	case StyleItalic:
+		return t.ItalicText
+	case StyleStrikethrough:
+		return t.StrikethroughText
+	case StyleMonospace:
+		return t.MonospaceText
+	case StyleSpoiler:
+		return t.SpoilerText
+	}
+	return ""
+}
+
+// CombineStyles combines styles into one. Later foreground and background colors win, and the
+// attributes add up.
+func CombineStyles(styles ...string) string {
+	var fg, bg, attrs string
+	for _, style := range styles {
+		parts := strings.SplitN(style, ":", 3)
+		if parts[0] != "" {
+			fg = parts[0]
+		}
+		if len(parts) > 1 && parts[1] != "" {
+			bg = parts[1]
+		}
+		if len(parts) > 2 && parts[2] != "-" {
+			attrs += parts[2]
+		}
+	}
+	return fg + ":" + bg + ":" + attrs
+}
+
+// Status returns the glyphs for a message's delivery and read status
+func (t *Theme) Status(m *Message) string {
+	if m.IsPending {
+		return t.PendingGlyph
+	} else if m.IsFailed {
+		return t.FailedGlyph
+	}
+	delivered, read := t.UndeliveredGlyph, t.UnreadGlyph
+	if m.IsDelivered {
+		delivered = t.DeliveredGlyph
+	}
+	if m.IsRead {
+		read = t.ReadGlyph
+	}
+	return delivered + read
+}
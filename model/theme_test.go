@@ -0,0 +1,77 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTheme(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-theme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+
+	theme, err := LoadTheme(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultTheme(), theme)
+
+	// a theme only needs what it changes
+	theme, err = LoadTheme(&Theme{Base: "ascii", FocusBorder: "green"})
+	assert.NoError(t, err)
+	assert.Equal(t, "green", theme.FocusBorder)
+	assert.Equal(t, "@", theme.AttachmentGlyph)
+	assert.Equal(t, "::d", theme.SelfMessage)
+	assert.Equal(t, "dr", theme.Status(&Message{FromSelf: true, IsDelivered: true, IsRead: true}))
+
+	// theme files can be based on other themes, but not in a loop
+	assert.NoError(t, os.MkdirAll(ThemeFolder(), os.ModePerm))
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(ThemeFolder(), name+".yml"), []byte(content), 0644)
+		assert.NoError(t, err)
+	}
+	write("zorg", "base: gruvbox\nself_glyph: Z\n")
+	theme, err = LoadTheme(&Theme{Base: "zorg"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Z", theme.SelfGlyph)
+	assert.Equal(t, "#282828", theme.Background)
+	assert.Equal(t, "📎", theme.AttachmentGlyph)
+
+	write("loop", "base: loop\n")
+	_, err = LoadTheme(&Theme{Base: "loop"})
+	assert.Error(t, err)
+	_, err = LoadTheme(&Theme{Base: "mangalore"})
+	assert.Error(t, err)
+
+	// colors we don't know are an error, not the default color
+	_, err = LoadTheme(&Theme{Background: "default", FocusBorder: "#ffa500", SelfMessage: "-:#282828:b"})
+	assert.NoError(t, err)
+	_, err = LoadTheme(&Theme{FocusBorder: "ornage"})
+	assert.EqualError(t, err, `bad theme color for focus_border: "ornage"`)
+	_, err = LoadTheme(&Theme{Background: "#zzzzzz"})
+	assert.EqualError(t, err, `bad theme color for background: "#zzzzzz"`)
+	_, err = LoadTheme(&Theme{UnreadMessage: "::b", Timestamp: ":grey:"})
+	assert.NoError(t, err)
+	_, err = LoadTheme(&Theme{Timestamp: "gray:blu:"})
+	assert.EqualError(t, err, `bad theme color for timestamp: "blu" in "gray:blu:"`)
+	write("typo", "base: gruvbox\nborder: grean\n")
+	_, err = LoadTheme(&Theme{Base: "typo"})
+	assert.EqualError(t, err, `bad theme color for border: "grean"`)
+}
+
+func TestSiggoTheme(t *testing.T) {
+	s := newTestSiggo()
+	assert.Equal(t, "▒", s.SpoilerGlyph())
+	s.config.Theme = &Theme{Base: "ascii"}
+	s.loadTheme()
+	assert.Equal(t, "#", s.SpoilerGlyph())
+	s.config.Theme = &Theme{SpoilerGlyph: "*"}
+	s.reconfigure("")
+	assert.Equal(t, "*", s.SpoilerGlyph())
+}
@@ -32,6 +32,11 @@ type SentMessage struct {
 	Destination      string        `json:"destination"`
 	Mentions         interface{}   `json:"mentions"`
 	ViewOnce         bool          `json:"viewOnce"`
+	Quote            *Quote        `json:"quote"`
+	Reaction         *Reaction     `json:"reaction"`
+	RemoteDelete     *RemoteDelete `json:"remoteDelete"`
+	TextStyles       []*TextStyle  `json:"textStyles"`
+	Previews         []*Preview    `json:"previews"`
 }
 
 type DataMessage struct {
@@ -40,6 +45,58 @@ type DataMessage struct {
 	ExpiresInSeconds int64         `json:"expiresInSeconds"`
 	Attachments      []*Attachment `json:"attachments"`
 	GroupInfo        *GroupInfo    `json:"groupInfo"`
+	Quote            *Quote        `json:"quote"`
+	Reaction         *Reaction     `json:"reaction"`
+	RemoteDelete     *RemoteDelete `json:"remoteDelete"`
+	TextStyles       []*TextStyle  `json:"textStyles"`
+	Previews         []*Preview    `json:"previews"`
+}
+
+// TextStyle styles part of a message: bold, italic and so on. Start and Length count UTF-16 code
+// units.
+type TextStyle struct {
+	Style  string `json:"style"`
+	Start  int    `json:"start"`
+	Length int    `json:"length"`
+}
+
+// Preview is the link preview the sender's app made for a link in a message. Image is a thumbnail
+// of the page, if there is one.
+type Preview struct {
+	URL         string      `json:"url"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Date        int64       `json:"date"`
+	Image       *Attachment `json:"image"`
+}
+
+// The styles of a TextStyle
+const (
+	StyleBold          = "BOLD"
+	StyleItalic        = "ITALIC"
+	StyleStrikethrough = "STRIKETHROUGH"
+	StyleSpoiler       = "SPOILER"
+	StyleMonospace     = "MONOSPACE"
+)
+
+// Quote is the message a reply is replying to
+type Quote struct {
+	ID     int64  `json:"id"`
+	Author string `json:"author"`
+	Text   string `json:"text"`
+}
+
+// Reaction is an emoji reaction to a message. A reaction with IsRemove set takes it back.
+type Reaction struct {
+	Emoji               string `json:"emoji"`
+	TargetAuthor        string `json:"targetAuthor"`
+	TargetSentTimestamp int64  `json:"targetSentTimestamp"`
+	IsRemove            bool   `json:"isRemove"`
+}
+
+// RemoteDelete deletes a message we sent, for everyone
+type RemoteDelete struct {
+	Timestamp int64 `json:"timestamp"`
 }
 
 type CallMessage interface{}
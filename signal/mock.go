@@ -40,7 +40,7 @@ func (ms *MockSignal) Version() (string, error) {
 }
 
 // Send just sends a fake message, by putting it on the "wire"
-func (ms *MockSignal) Send(dest, msg string) (int64, error) {
+func (ms *MockSignal) Send(dest, msg string, attachments ...string) (int64, error) {
 	timestamp := time.Now().Unix()
 	fakeWire := fakeSendReceipt
 	fakeWire.Envelope.Timestamp = timestamp
@@ -57,6 +57,10 @@ func (ms *MockSignal) Send(dest, msg string) (int64, error) {
 	return timestamp, nil
 }
 
+func (ms *MockSignal) SendGroup(groupID, msg string, attachments ...string) (int64, error) {
+	return ms.Send(groupID, msg)
+}
+
 func (ms *MockSignal) SendDbus(dest, msg string, attachments ...string) (int64, error) {
 	return ms.Send(dest, msg)
 }
@@ -65,6 +69,27 @@ func (ms *MockSignal) SendGroupDbus(groupID, msg string, attachments ...string)
 	return ms.Send(groupID, msg)
 }
 
+func (ms *MockSignal) SendStyled(r Recipient, msg string, styles []*TextStyle, attachments ...string) (int64, error) {
+	return ms.Send(r.Dest, msg)
+}
+
+func (ms *MockSignal) SendReply(r Recipient, msg string, styles []*TextStyle, quote *Quote, attachments ...string) (int64, error) {
+	return ms.Send(r.Dest, msg)
+}
+
+// SendReaction, RemoteDelete and UpdateGroup don't put anything on the wire, siggo applies them itself
+func (ms *MockSignal) SendReaction(r Recipient, reaction *Reaction) (int64, error) {
+	return time.Now().Unix(), nil
+}
+
+func (ms *MockSignal) RemoteDelete(r Recipient, timestamp int64) (int64, error) {
+	return time.Now().Unix(), nil
+}
+
+func (ms *MockSignal) UpdateGroup(r Recipient, members ...string) (int64, error) {
+	return time.Now().Unix(), nil
+}
+
 func (ms *MockSignal) Receive() error {
 	r := bytes.NewReader(ms.exampleData)
 	scanner := bufio.NewScanner(r)
@@ -7,7 +7,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/user"
@@ -16,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/godbus/dbus/v5"
 	qr "github.com/mdp/qrterminal/v3"
 	log "github.com/sirupsen/logrus"
 )
@@ -44,17 +44,36 @@ func GetSignalAvatarsFolder() (string, error) {
 	return filepath.Join(signalFolder, "avatars"), nil
 }
 
+// GetSignalDataFolder returns the folder where signal-cli keeps account data
+func GetSignalDataFolder() (string, error) {
+	signalFolder, err := GetSignalFolder()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(signalFolder, "data"), nil
+}
+
 // SignalContact is the data signal-cli saves for each contact
 // in SignalDataDir/<phonenumber>
 type SignalContact struct {
 	Name                  string `json:"name"`
 	Number                string `json:"number"`
+	UUID                  string `json:"uuid"`
 	Color                 string `json:"color"`
 	MessageExpirationTime int    `json:"messageExpirationTime"`
 	ProfileKey            string `json:"profileKey"`
 	Blocked               bool   `json:"blocked"`
 	InboxPosition         *int   `json:"inboxPosition"`
 	Archived              bool   `json:"archived"`
+	// ProfileName is filled in from the profile store, it isn't part of the contact record
+	ProfileName string `json:"-"`
+}
+
+// SignalProfileEntry is an entry in the legacy profile store
+type SignalProfileEntry struct {
+	Name    string         `json:"name"`
+	UUID    string         `json:"uuid"`
+	Profile *SignalProfile `json:"profile"`
 }
 
 // SignalGroup is the data that signal-cli saves for each group
@@ -77,7 +96,8 @@ type SignalGroupMember struct {
 }
 
 // SignalUserData is the data signal saves for a given user
-// in SignalDataDir/<phonenumber>
+// in SignalDataDir/<phonenumber>. Newer versions of signal-cli keep it in a database instead, which
+// we read into the same structure.
 type SignalUserData struct {
 	ContactStore struct {
 		Contacts []*SignalContact `json:"contacts"`
@@ -85,6 +105,9 @@ type SignalUserData struct {
 	GroupStore struct {
 		Groups []*SignalGroup `json:"groups"`
 	} `json:"groupStore"`
+	ProfileStore struct {
+		Profiles []*SignalProfileEntry `json:"profiles"`
+	} `json:"profileStore"`
 }
 
 type MessageCallback func(*Message) error
@@ -238,69 +261,173 @@ func (s *Signal) Daemon() error {
 	return nil
 }
 
-// Send transmits a message to the specified number
-// Destination is a phone number with country code.
-// signal-cli likes to have a `+` before the number, so we add one if it isn't there.
-func (s *Signal) Send(dest, msg string) (int64, error) {
-	if !strings.HasPrefix(dest, "+") {
-		dest = fmt.Sprintf("+%s", dest)
-	}
-	cmd := exec.Command("signal-cli", "-u", s.uname, "send", dest, "-m", msg)
-	out, err := cmd.Output()
+// DaemonName is the name the signal-cli daemon claims on the dbus session bus
+const DaemonName = "org.asamk.Signal"
+
+// DaemonRunning returns true if a signal-cli daemon is available on the dbus session bus, in which
+// case we can use the SendDbus methods instead of spinning up the JVM.
+func DaemonRunning() bool {
+	conn, err := dbus.SessionBus()
 	if err != nil {
-		s.publishError(err)
-		return 0, err
+		return false
 	}
-	ID, err := strconv.Atoi(string(out[:len(out)-1])) //strip newline
+	var hasOwner bool
+	err = conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, DaemonName).Store(&hasOwner)
 	if err != nil {
-		return 0, err
+		log.Debugf("failed to query dbus for %s: %v", DaemonName, err)
+		return false
 	}
-	return int64(ID), nil
+	return hasOwner
 }
 
-// SendDbus does the same thing as Send but it goes through a running daemon.
-func (s *Signal) SendDbus(dest, msg string, attachments ...string) (int64, error) {
+// withPlus prefixes a phone number with `+`, which signal-cli likes to have.
+func withPlus(dest string) string {
 	if !strings.HasPrefix(dest, "+") {
 		dest = fmt.Sprintf("+%s", dest)
 	}
-	args := []string{"--dbus", "send", dest, "-m", msg}
+	return dest
+}
+
+// withAttachments adds attachments to signal-cli send arguments
+func withAttachments(args []string, attachments []string) []string {
 	if len(attachments) > 0 {
-		// how do I do this in one line?
 		args = append(args, "-a")
 		args = append(args, attachments...)
 	}
+	return args
+}
+
+// runSend runs a signal-cli send command and returns the timestamp of the sent message
+func (s *Signal) runSend(args []string) (int64, error) {
 	cmd := exec.Command("signal-cli", args...)
 	out, err := cmd.Output()
 	if err != nil {
 		s.publishError(err)
 		return 0, err
 	}
-	ID, err := strconv.Atoi(string(out[:len(out)-1])) //strip newline
+	ID, err := strconv.Atoi(strings.TrimSpace(string(out)))
 	if err != nil {
 		return 0, err
 	}
 	return int64(ID), nil
 }
 
+// Send transmits a message to the specified number
+// Destination is a phone number with country code.
+// signal-cli likes to have a `+` before the number, so we add one if it isn't there.
+func (s *Signal) Send(dest, msg string, attachments ...string) (int64, error) {
+	args := []string{"-u", s.uname, "send", withPlus(dest), "-m", msg}
+	return s.runSend(withAttachments(args, attachments))
+}
+
+// SendGroup does the same thing as Send but to a group
+func (s *Signal) SendGroup(groupID, msg string, attachments ...string) (int64, error) {
+	args := []string{"-u", s.uname, "send", "-g", groupID, "-m", msg}
+	return s.runSend(withAttachments(args, attachments))
+}
+
+// SendDbus does the same thing as Send but it goes through a running daemon.
+func (s *Signal) SendDbus(dest, msg string, attachments ...string) (int64, error) {
+	args := []string{"--dbus", "send", withPlus(dest), "-m", msg}
+	return s.runSend(withAttachments(args, attachments))
+}
+
 // SendGroupDbus does the same thing as SendDbus but to a group
 func (s *Signal) SendGroupDbus(groupID, msg string, attachments ...string) (int64, error) {
 	args := []string{"--dbus", "send", "-g", groupID, "-m", msg}
-	if len(attachments) > 0 {
-		// how do I do this in one line?
-		args = append(args, "-a")
-		args = append(args, attachments...)
+	return s.runSend(withAttachments(args, attachments))
+}
+
+// Recipient is who a reply, reaction or deletion goes to: a number, or a group ID if Group is set.
+// Dbus sends through a running daemon instead of invoking signal-cli directly.
+type Recipient struct {
+	Dest  string
+	Group bool
+	Dbus  bool
+}
+
+// args returns the signal-cli arguments to run `command` for the recipient
+func (r Recipient) args(uname, command string) []string {
+	args := []string{"-u", uname, command}
+	if r.Dbus {
+		args = []string{"--dbus", command}
 	}
+	if r.Group {
+		return append(args, "-g", r.Dest)
+	}
+	return append(args, withPlus(r.Dest))
+}
+
+// runCommand runs a signal-cli command that may or may not print the timestamp of what it sent,
+// depending on the version of signal-cli. Returns 0 if it doesn't.
+func (s *Signal) runCommand(args []string) (int64, error) {
 	cmd := exec.Command("signal-cli", args...)
 	out, err := cmd.Output()
 	if err != nil {
 		s.publishError(err)
 		return 0, err
 	}
-	ID, err := strconv.Atoi(string(out[:len(out)-1])) //strip newline
+	ID, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
 	if err != nil {
-		return 0, err
+		return 0, nil
 	}
-	return int64(ID), nil
+	return ID, nil
+}
+
+// withTextStyles adds text styles to signal-cli args
+func withTextStyles(args []string, styles []*TextStyle) []string {
+	if len(styles) > 0 {
+		args = append(args, "--text-style")
+		for _, style := range styles {
+			args = append(args, fmt.Sprintf("%d:%d:%s", style.Start, style.Length, style.Style))
+		}
+	}
+	return args
+}
+
+// SendStyled sends a message with text styles
+func (s *Signal) SendStyled(r Recipient, msg string, styles []*TextStyle, attachments ...string) (int64, error) {
+	args := append(r.args(s.uname, "send"), "-m", msg)
+	return s.runSend(withAttachments(withTextStyles(args, styles), attachments))
+}
+
+// SendReply sends a message that quotes another one
+func (s *Signal) SendReply(r Recipient, msg string, styles []*TextStyle, quote *Quote, attachments ...string) (int64, error) {
+	args := append(r.args(s.uname, "send"), "-m", msg,
+		"--quote-timestamp", strconv.FormatInt(quote.ID, 10),
+		"--quote-author", quote.Author,
+		"--quote-message", quote.Text)
+	return s.runSend(withAttachments(withTextStyles(args, styles), attachments))
+}
+
+// SendReaction reacts to a message with an emoji, or takes the reaction back if IsRemove is set
+func (s *Signal) SendReaction(r Recipient, reaction *Reaction) (int64, error) {
+	args := append(r.args(s.uname, "sendReaction"),
+		"-e", reaction.Emoji,
+		"-a", reaction.TargetAuthor,
+		"-t", strconv.FormatInt(reaction.TargetSentTimestamp, 10))
+	if reaction.IsRemove {
+		args = append(args, "-r")
+	}
+	return s.runCommand(args)
+}
+
+// RemoteDelete deletes a message we sent, for everyone in the conversation
+func (s *Signal) RemoteDelete(r Recipient, timestamp int64) (int64, error) {
+	args := append(r.args(s.uname, "remoteDelete"), "-t", strconv.FormatInt(timestamp, 10))
+	return s.runCommand(args)
+}
+
+// UpdateGroup adds `members` to a group
+func (s *Signal) UpdateGroup(r Recipient, members ...string) (int64, error) {
+	args := r.args(s.uname, "updateGroup")
+	if len(members) > 0 {
+		args = append(args, "-m")
+		for _, member := range members {
+			args = append(args, withPlus(member))
+		}
+	}
+	return s.runCommand(args)
 }
 
 // Link will attempt to link to an existing registered device.
@@ -324,23 +451,20 @@ func (s *Signal) Link(deviceName string) error {
 	return cmd.Wait()
 }
 
-// GetUserData returns the user data for the current user.
+// GetUserData returns the user data for the current user. It understands both the legacy
+// single-file layout and the newer accounts.json + account.db layout, and falls back to asking
+// signal-cli for contacts and groups if neither can be read.
 func (s *Signal) GetUserData() (*SignalUserData, error) {
-	usr, err := user.Current()
+	dataDir, err := GetSignalDataFolder()
 	if err != nil {
 		return nil, err
 	}
-	homeDir := usr.HomeDir
-	dataFile := fmt.Sprintf("%s/%s/%s", homeDir, SignalDataDir, s.uname)
-	b, err := ioutil.ReadFile(dataFile)
-	if err != nil {
-		return nil, err
-	}
-	userData := &SignalUserData{}
-	if err = json.Unmarshal(b, userData); err != nil {
-		return nil, err
+	userData, err := ReadUserData(dataDir, s.uname)
+	if err == nil {
+		return userData, nil
 	}
-	return userData, nil
+	log.Warnf("failed to read signal-cli data folder, asking signal-cli instead: %v", err)
+	return s.listUserData()
 }
 
 // GetContactList attempts to read an existing contact list from the signal user directory.
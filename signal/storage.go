@@ -0,0 +1,546 @@
+package signal
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite" // pure-go sqlite driver for reading account.db
+)
+
+// StorageLayout describes how signal-cli has laid out its data folder on disk.
+type StorageLayout int
+
+const (
+	// UnknownLayout means we couldn't find any account data on disk
+	UnknownLayout StorageLayout = iota
+	// LegacyLayout is the single JSON file per user @ data/<phonenumber> used by older signal-cli
+	LegacyLayout
+	// AccountsLayout is data/accounts.json with a data/<path>.d/account.db SQLite database per
+	// account, used by signal-cli >= 0.11
+	AccountsLayout
+)
+
+func (l StorageLayout) String() string {
+	switch l {
+	case LegacyLayout:
+		return "legacy"
+	case AccountsLayout:
+		return "accounts"
+	}
+	return "unknown"
+}
+
+// SignalAccount is a single entry in data/accounts.json
+type SignalAccount struct {
+	Path        string `json:"path"`
+	Environment string `json:"environment"`
+	Number      string `json:"number"`
+	UUID        string `json:"uuid"`
+}
+
+// SignalAccounts is the index of accounts that newer versions of signal-cli save in
+// data/accounts.json
+type SignalAccounts struct {
+	Accounts []*SignalAccount `json:"accounts"`
+	Version  int              `json:"version"`
+}
+
+// SignalProfile is the profile data that signal-cli keeps for a contact
+type SignalProfile struct {
+	GivenName  string `json:"givenName"`
+	FamilyName string `json:"familyName"`
+	// Name is the legacy format, given and family name separated by a NUL byte
+	Name string `json:"name"`
+}
+
+// String returns the display name for the profile
+func (p *SignalProfile) String() string {
+	if p == nil {
+		return ""
+	}
+	if p.GivenName != "" || p.FamilyName != "" {
+		return joinName(p.GivenName, p.FamilyName)
+	}
+	return strings.TrimSpace(strings.Replace(p.Name, "\x00", " ", -1))
+}
+
+func joinName(given, family string) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", given, family))
+}
+
+// DetectLayout works out which storage layout signal-cli is using in `dataDir` for user `uname`
+func DetectLayout(dataDir, uname string) StorageLayout {
+	if _, err := os.Stat(filepath.Join(dataDir, "accounts.json")); err == nil {
+		return AccountsLayout
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, uname)); err == nil {
+		return LegacyLayout
+	}
+	return UnknownLayout
+}
+
+// ReadUserData reads the contacts and groups for user `uname` from `dataDir`, whichever layout it
+// happens to be in.
+func ReadUserData(dataDir, uname string) (*SignalUserData, error) {
+	layout := DetectLayout(dataDir, uname)
+	log.Debugf("signal-cli storage layout: %s", layout)
+	switch layout {
+	case LegacyLayout:
+		return readLegacyUserData(filepath.Join(dataDir, uname))
+	case AccountsLayout:
+		return readAccountUserData(dataDir, uname)
+	}
+	return nil, fmt.Errorf("no signal-cli data found for %s in %s", uname, dataDir)
+}
+
+// readLegacyUserData reads the single JSON file that older versions of signal-cli keep per user
+func readLegacyUserData(path string) (*SignalUserData, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	userData := &SignalUserData{}
+	if err = json.Unmarshal(b, userData); err != nil {
+		return nil, err
+	}
+	// profile names live in a separate store, match them up by uuid or number
+	profiles := make(map[string]*SignalProfile)
+	for _, p := range userData.ProfileStore.Profiles {
+		if p.UUID != "" {
+			profiles[p.UUID] = p.Profile
+		}
+		if p.Name != "" {
+			profiles[p.Name] = p.Profile
+		}
+	}
+	for _, c := range userData.ContactStore.Contacts {
+		if p, ok := profiles[c.UUID]; ok {
+			c.ProfileName = p.String()
+		} else if p, ok := profiles[c.Number]; ok {
+			c.ProfileName = p.String()
+		}
+	}
+	return userData, nil
+}
+
+// findAccount finds the entry for `uname` in data/accounts.json
+func findAccount(dataDir, uname string) (*SignalAccount, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dataDir, "accounts.json"))
+	if err != nil {
+		return nil, err
+	}
+	accounts := &SignalAccounts{}
+	if err = json.Unmarshal(b, accounts); err != nil {
+		return nil, err
+	}
+	for _, a := range accounts.Accounts {
+		if a.Number == uname {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("account %s not found in accounts.json", uname)
+}
+
+// readAccountUserData reads contacts and groups from the account.db of a newer signal-cli install
+func readAccountUserData(dataDir, uname string) (*SignalUserData, error) {
+	account, err := findAccount(dataDir, uname)
+	if err != nil {
+		return nil, err
+	}
+	dbPath := filepath.Join(dataDir, fmt.Sprintf("%s.d", account.Path), "account.db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro", dbPath))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	userData := &SignalUserData{}
+	contacts, err := readRecipients(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recipients from %s: %v", dbPath, err)
+	}
+	userData.ContactStore.Contacts = contacts
+	groups, err := readGroups(db, contacts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read groups from %s: %v", dbPath, err)
+	}
+	userData.GroupStore.Groups = groups
+	return userData, nil
+}
+
+// tableColumns returns the set of columns in `table`. signal-cli has renamed and added columns
+// between versions, so we only select what is actually there.
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid          int
+			name, ctype  string
+			notNull, pk  int
+			defaultValue interface{}
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// selectColumn returns `name` if the column exists, otherwise a NULL placeholder
+func selectColumn(columns map[string]bool, names ...string) string {
+	for _, name := range names {
+		if columns[name] {
+			return name
+		}
+	}
+	return "NULL"
+}
+
+func readRecipients(db *sql.DB) ([]*SignalContact, error) {
+	columns, err := tableColumns(db, "recipient")
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("SELECT %s, %s, %s, %s, %s, %s, %s, %s FROM recipient ORDER BY _id",
+		selectColumn(columns, "number"),
+		selectColumn(columns, "aci", "uuid"),
+		selectColumn(columns, "given_name"),
+		selectColumn(columns, "family_name"),
+		selectColumn(columns, "profile_given_name"),
+		selectColumn(columns, "profile_family_name"),
+		selectColumn(columns, "blocked"),
+		selectColumn(columns, "hidden", "archived"),
+	)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := make([]*SignalContact, 0)
+	position := 0
+	for rows.Next() {
+		var number, uuid, given, family, profileGiven, profileFamily sql.NullString
+		var blocked, hidden sql.NullBool
+		err := rows.Scan(&number, &uuid, &given, &family, &profileGiven, &profileFamily,
+			&blocked, &hidden)
+		if err != nil {
+			return nil, err
+		}
+		if !number.Valid || number.String == "" {
+			// uuid-only recipients can't be messaged by number yet
+			continue
+		}
+		c := &SignalContact{
+			Name:        joinName(given.String, family.String),
+			Number:      number.String,
+			UUID:        uuid.String,
+			ProfileName: joinName(profileGiven.String, profileFamily.String),
+			Blocked:     blocked.Bool,
+			Archived:    hidden.Bool,
+		}
+		if !c.Blocked && !c.Archived {
+			// newer signal-cli doesn't track inbox positions, so use storage order
+			pos := position
+			c.InboxPosition = &pos
+			position++
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts, rows.Err()
+}
+
+func readGroups(db *sql.DB, contacts []*SignalContact) ([]*SignalGroup, error) {
+	groups := make([]*SignalGroup, 0)
+	if columns, err := tableColumns(db, "group_v1"); err == nil && len(columns) > 0 {
+		members, err := readGroupV1Members(db)
+		if err != nil {
+			return nil, err
+		}
+		query := fmt.Sprintf("SELECT _id, group_id, %s, %s FROM group_v1",
+			selectColumn(columns, "name"),
+			selectColumn(columns, "blocked"),
+		)
+		rows, err := db.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			var groupID []byte
+			var name sql.NullString
+			var blocked sql.NullBool
+			if err := rows.Scan(&id, &groupID, &name, &blocked); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			groups = append(groups, &SignalGroup{
+				GroupID: base64.StdEncoding.EncodeToString(groupID),
+				Name:    name.String,
+				Members: members[id],
+				Blocked: blocked.Bool,
+			})
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if columns, err := tableColumns(db, "group_v2"); err == nil && len(columns) > 0 {
+		// v2 members are only known by uuid, look up their numbers in the recipients
+		numbers := make(map[string]string)
+		for _, c := range contacts {
+			if c.UUID != "" {
+				numbers[c.UUID] = c.Number
+			}
+		}
+		query := fmt.Sprintf("SELECT group_id, %s, %s FROM group_v2",
+			selectColumn(columns, "group_data"),
+			selectColumn(columns, "blocked"),
+		)
+		rows, err := db.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var groupID, groupData []byte
+			var blocked sql.NullBool
+			if err := rows.Scan(&groupID, &groupData, &blocked); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			uuids := decryptedGroupMembers(groupData)
+			members := make([]interface{}, 0, len(uuids))
+			for _, uuid := range uuids {
+				members = append(members, &SignalGroupMember{UUID: uuid, Number: numbers[uuid]})
+			}
+			groups = append(groups, &SignalGroup{
+				GroupID: base64.StdEncoding.EncodeToString(groupID),
+				Name:    decryptedGroupTitle(groupData),
+				Members: members,
+				Blocked: blocked.Bool,
+			})
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// readGroupV1Members reads the members of every v1 group, keyed by the _id of the group
+func readGroupV1Members(db *sql.DB) (map[int64][]interface{}, error) {
+	members := make(map[int64][]interface{})
+	if columns, err := tableColumns(db, "group_v1_member"); err != nil || len(columns) == 0 {
+		return members, nil
+	}
+	columns, err := tableColumns(db, "recipient")
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf(`SELECT m.group_id, r.%s, r.%s FROM group_v1_member m
+		JOIN recipient r ON r._id = m.recipient_id ORDER BY m._id`,
+		selectColumn(columns, "number"),
+		selectColumn(columns, "aci", "uuid"),
+	)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var group int64
+		var number, uuid sql.NullString
+		if err := rows.Scan(&group, &number, &uuid); err != nil {
+			return nil, err
+		}
+		members[group] = append(members[group], &SignalGroupMember{UUID: uuid.String, Number: number.String})
+	}
+	return members, rows.Err()
+}
+
+// protoFields calls `fn` with each length-delimited field (strings, bytes and nested messages) of a
+// serialized protobuf, until it returns false. Pulling in a protobuf library for a couple of fields
+// seemed like overkill.
+func protoFields(data []byte, fn func(field uint64, value []byte) bool) {
+	for i := 0; i < len(data); {
+		key, n := readVarint(data[i:])
+		if n == 0 {
+			return
+		}
+		i += n
+		field, wireType := key>>3, key&0x7
+		switch wireType {
+		case 0: // varint
+			_, n = readVarint(data[i:])
+			if n == 0 {
+				return
+			}
+			i += n
+		case 1: // 64-bit
+			i += 8
+		case 2: // length-delimited
+			length, n := readVarint(data[i:])
+			if n == 0 || i+n+int(length) > len(data) {
+				return
+			}
+			i += n
+			if !fn(field, data[i:i+int(length)]) {
+				return
+			}
+			i += int(length)
+		case 5: // 32-bit
+			i += 4
+		default:
+			return
+		}
+	}
+}
+
+// decryptedGroupTitle pulls the title (field 2) out of a serialized DecryptedGroup protobuf
+func decryptedGroupTitle(data []byte) string {
+	title := ""
+	protoFields(data, func(field uint64, value []byte) bool {
+		if field == 2 {
+			title = string(value)
+			return false
+		}
+		return true
+	})
+	return title
+}
+
+// decryptedGroupMembers pulls the uuids of the members (field 7) out of a serialized DecryptedGroup
+// protobuf. Each member is a DecryptedMember, with the uuid as 16 bytes in field 1.
+func decryptedGroupMembers(data []byte) []string {
+	uuids := make([]string, 0)
+	protoFields(data, func(field uint64, member []byte) bool {
+		if field != 7 {
+			return true
+		}
+		protoFields(member, func(field uint64, value []byte) bool {
+			if field == 1 && len(value) == 16 {
+				uuids = append(uuids, fmt.Sprintf("%x-%x-%x-%x-%x",
+					value[0:4], value[4:6], value[6:8], value[8:10], value[10:16]))
+				return false
+			}
+			return true
+		})
+		return true
+	})
+	return uuids
+}
+
+// readVarint decodes a protobuf varint, returning the value and number of bytes read (0 on error)
+func readVarint(b []byte) (uint64, int) {
+	var x uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		x |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return x, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// cliContact is a contact as printed by `signal-cli -o json listContacts`
+type cliContact struct {
+	Number     string         `json:"number"`
+	UUID       string         `json:"uuid"`
+	Name       string         `json:"name"`
+	GivenName  string         `json:"givenName"`
+	FamilyName string         `json:"familyName"`
+	IsBlocked  bool           `json:"isBlocked"`
+	IsHidden   bool           `json:"isHidden"`
+	Profile    *SignalProfile `json:"profile"`
+}
+
+// cliGroup is a group as printed by `signal-cli -o json listGroups`
+type cliGroup struct {
+	ID        string               `json:"id"`
+	Name      string               `json:"name"`
+	IsBlocked bool                 `json:"isBlocked"`
+	IsMember  bool                 `json:"isMember"`
+	Members   []*SignalGroupMember `json:"members"`
+}
+
+// listUserData asks signal-cli itself for contacts and groups. This is slow (it starts the JVM
+// twice), so we only use it when we can't make sense of the data folder.
+func (s *Signal) listUserData() (*SignalUserData, error) {
+	b, err := Exec("-o", "json", "-u", s.uname, "listContacts")
+	if err != nil {
+		return nil, fmt.Errorf("signal-cli listContacts failed: %v", err)
+	}
+	var contacts []*cliContact
+	if err = json.Unmarshal(b, &contacts); err != nil {
+		return nil, err
+	}
+	b, err = Exec("-o", "json", "-u", s.uname, "listGroups")
+	if err != nil {
+		return nil, fmt.Errorf("signal-cli listGroups failed: %v", err)
+	}
+	var groups []*cliGroup
+	if err = json.Unmarshal(b, &groups); err != nil {
+		return nil, err
+	}
+
+	userData := &SignalUserData{}
+	position := 0
+	for _, c := range contacts {
+		if c.Number == "" {
+			continue
+		}
+		name := c.Name
+		if name == "" {
+			name = joinName(c.GivenName, c.FamilyName)
+		}
+		contact := &SignalContact{
+			Name:        name,
+			Number:      c.Number,
+			UUID:        c.UUID,
+			ProfileName: c.Profile.String(),
+			Blocked:     c.IsBlocked,
+			Archived:    c.IsHidden,
+		}
+		if !contact.Blocked && !contact.Archived {
+			pos := position
+			contact.InboxPosition = &pos
+			position++
+		}
+		userData.ContactStore.Contacts = append(userData.ContactStore.Contacts, contact)
+	}
+	for _, g := range groups {
+		members := make([]interface{}, 0, len(g.Members))
+		for _, m := range g.Members {
+			members = append(members, m)
+		}
+		userData.GroupStore.Groups = append(userData.GroupStore.Groups, &SignalGroup{
+			GroupID: g.ID,
+			Name:    g.Name,
+			Members: members,
+			Blocked: g.IsBlocked,
+			// groups we've left show up too, treat them as archived
+			Archived: !g.IsMember,
+		})
+	}
+	return userData, nil
+}
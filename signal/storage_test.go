@@ -0,0 +1,113 @@
+package signal
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const legacyUserData = `{
+  "contactStore": {"contacts": [
+    {"name": "Leeloo", "number": "+15550000001", "uuid": "uuid-1", "inboxPosition": 0},
+    {"name": "", "number": "+15550000002", "uuid": "uuid-2", "inboxPosition": 1}
+  ]},
+  "groupStore": {"groups": [{"groupId": "Z3JvdXA=", "name": "Fifth Element"}]},
+  "profileStore": {"profiles": [
+    {"name": "+15550000002", "uuid": "uuid-2", "profile": {"givenName": "Ruby", "familyName": "Rhod"}}
+  ]}
+}`
+
+func TestReadLegacyUserData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-signal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	uname := "+15559999999"
+	if err := ioutil.WriteFile(filepath.Join(dir, uname), []byte(legacyUserData), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, LegacyLayout, DetectLayout(dir, uname))
+	userData, err := ReadUserData(dir, uname)
+	if err != nil {
+		t.Fatalf("failed to read legacy user data: %v", err)
+	}
+	contacts := userData.ContactStore.Contacts
+	assert.Len(t, contacts, 2)
+	assert.Equal(t, "uuid-1", contacts[0].UUID)
+	assert.Equal(t, "Ruby Rhod", contacts[1].ProfileName)
+	assert.Equal(t, "Fifth Element", userData.GroupStore.Groups[0].Name)
+}
+
+func TestReadAccountUserData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-signal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	uname := "+15559999999"
+	accounts := `{"accounts": [{"path": "123456", "environment": "LIVE", "number": "+15559999999"}], "version": 2}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "accounts.json"), []byte(accounts), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "123456.d"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", filepath.Join(dir, "123456.d", "account.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := []string{
+		`CREATE TABLE recipient (_id INTEGER PRIMARY KEY, number TEXT, aci TEXT, given_name TEXT,
+			family_name TEXT, profile_given_name TEXT, profile_family_name TEXT, blocked INTEGER,
+			hidden INTEGER)`,
+		`INSERT INTO recipient VALUES (1, '+15550000001', '00000000-0000-0000-0000-000000000001', 'Leeloo', 'Dallas', NULL, NULL, 0, 0)`,
+		`INSERT INTO recipient VALUES (2, '+15550000002', 'uuid-2', NULL, NULL, 'Ruby', 'Rhod', 0, 0)`,
+		`INSERT INTO recipient VALUES (3, NULL, '00000000-0000-0000-0000-000000000003', NULL, NULL, 'Zorg', NULL, 0, 0)`,
+		`CREATE TABLE group_v1 (_id INTEGER PRIMARY KEY, group_id BLOB, name TEXT, blocked INTEGER)`,
+		`INSERT INTO group_v1 VALUES (1, X'0304', 'Fhloston', 0)`,
+		`CREATE TABLE group_v1_member (_id INTEGER PRIMARY KEY, group_id INTEGER, recipient_id INTEGER)`,
+		`INSERT INTO group_v1_member VALUES (1, 1, 2)`,
+		`CREATE TABLE group_v2 (_id INTEGER PRIMARY KEY, group_id BLOB, group_data BLOB, blocked INTEGER)`,
+		// DecryptedGroup{revision: 1, title: "Mondoshawan", members: [{aciBytes: uuid-1}, {aciBytes: uuid-3}]}
+		`INSERT INTO group_v2 VALUES (1, X'0102', X'3001120B4D6F6E646F73686177616E' ||
+			X'3A120A1000000000000000000000000000000001' || X'3A120A1000000000000000000000000000000003', 0)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("failed to set up account.db: %v", err)
+		}
+	}
+	db.Close()
+
+	assert.Equal(t, AccountsLayout, DetectLayout(dir, uname))
+	userData, err := ReadUserData(dir, uname)
+	if err != nil {
+		t.Fatalf("failed to read account user data: %v", err)
+	}
+	contacts := userData.ContactStore.Contacts
+	assert.Len(t, contacts, 2)
+	assert.Equal(t, "Leeloo Dallas", contacts[0].Name)
+	assert.Equal(t, "Ruby Rhod", contacts[1].ProfileName)
+	assert.Equal(t, 1, *contacts[1].InboxPosition)
+	groups := userData.GroupStore.Groups
+	if !assert.Len(t, groups, 2) {
+		return
+	}
+	assert.Equal(t, "AwQ=", groups[0].GroupID)
+	assert.Equal(t, "Fhloston", groups[0].Name)
+	assert.Equal(t, []interface{}{&SignalGroupMember{UUID: "uuid-2", Number: "+15550000002"}},
+		groups[0].Members)
+	assert.Equal(t, "AQI=", groups[1].GroupID)
+	assert.Equal(t, "Mondoshawan", groups[1].Name)
+	// members we only know by uuid have no number
+	assert.Equal(t, []interface{}{
+		&SignalGroupMember{UUID: "00000000-0000-0000-0000-000000000001", Number: "+15550000001"},
+		&SignalGroupMember{UUID: "00000000-0000-0000-0000-000000000003"},
+	}, groups[1].Members)
+}
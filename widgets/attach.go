@@ -28,7 +28,7 @@ func NewAttachInput(parent *ChatWindow) *CommandInput {
 		InputField: tview.NewInputField(),
 		parent:     parent,
 	}
-	ci.SetLabel("📎: ")
+	ci.SetLabel(parent.theme.AttachmentGlyph + ": ")
 	ci.SetText("~/")
 	ci.SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor)
 	ci.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
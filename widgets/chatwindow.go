@@ -7,7 +7,6 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 
@@ -27,11 +26,10 @@ const (
 	YankMode
 	OpenMode
 	LinkMode
+	SearchMode
+	SelectMode
 )
 
-// stolen from suckoverflow
-var urlRegex = regexp.MustCompile(`https?:\/\/(www\.)?[-a-zA-Z0-9@:%._\+~#=]{1,256}\.[a-zA-Z0-9()]{1,6}\b([-a-zA-Z0-9()@:%_\+.~#?&//=]*)`)
-
 // ChatWindow is the main panel for the UI.
 type ChatWindow struct {
 	// todo: maybe use Flex instead of Grid?
@@ -43,29 +41,53 @@ type ChatWindow struct {
 	sendPanel         *SendPanel
 	contactsPanel     *ContactListPanel
 	conversationPanel *ConversationPanel
-	searchPanel       tview.Primitive
-	commandPanel      tview.Primitive
-	statusBar         *StatusBar
-	app               *tview.Application
-	normalKeybinds    func(*tcell.EventKey) *tcell.EventKey
-	yankKeybinds      func(*tcell.EventKey) *tcell.EventKey
-	openKeybinds      func(*tcell.EventKey) *tcell.EventKey
-	linkKeybinds      func(*tcell.EventKey) *tcell.EventKey
-	goKeybinds        func(*tcell.EventKey) *tcell.EventKey
+	// mainPanel is the conversation, or whatever is showing in its place, and bottom the panel
+	// below everything else, like the status bar or a command input, if any
+	mainPanel      tview.Primitive
+	bottom         tview.Primitive
+	bottomHeight   int
+	statusBar      *StatusBar
+	app            *tview.Application
+	normalKeybinds func(*tcell.EventKey) *tcell.EventKey
+	yankKeybinds   func(*tcell.EventKey) *tcell.EventKey
+	selectKeybinds func(*tcell.EventKey) *tcell.EventKey
+	keymap         *Keymap
+	theme          *model.Theme
+	commandHistory *model.CommandHistory
+	// layout can be changed while running, and compact and contactsHidden are what it looks like
+	// right now
+	layout         model.Layout
+	compact        bool
+	contactsHidden bool
+}
+
+// ApplyTheme sets the colors every tview primitive starts out with from `theme`. Call it before
+// creating any.
+func ApplyTheme(theme *model.Theme) {
+	tview.Styles.PrimitiveBackgroundColor = tcell.GetColor(theme.Background)
+	tview.Styles.PrimaryTextColor = tcell.GetColor(theme.Text)
+	tview.Styles.BorderColor = tcell.GetColor(theme.Border)
+	tview.Styles.GraphicsColor = tcell.GetColor(theme.Border)
+	tview.Styles.TitleColor = tcell.GetColor(theme.Title)
+}
+
+// focusBorder is the border color of the panel we are using
+func (c *ChatWindow) focusBorder() tcell.Color {
+	return tcell.GetColor(c.theme.FocusBorder)
 }
 
 // InsertMode enters insert mode
 func (c *ChatWindow) InsertMode() {
 	log.Debug("INSERT MODE")
 	c.app.SetFocus(c.sendPanel)
-	c.sendPanel.SetBorderColor(tcell.ColorOrange)
+	c.sendPanel.SetBorderColor(c.focusBorder())
 	c.mode = InsertMode
 }
 
 // YankMode enters yank mode
 func (c *ChatWindow) YankMode() {
 	log.Debug("YANK MODE")
-	c.conversationPanel.SetBorderColor(tcell.ColorOrange)
+	c.conversationPanel.SetBorderColor(c.focusBorder())
 	c.mode = YankMode
 	c.SetInputCapture(c.yankKeybinds)
 }
@@ -88,14 +110,34 @@ func (c *ChatWindow) LinkMode() {
 	c.app.SetFocus(li)
 }
 
+// SearchMode enters search mode which lets us search the messages of every conversation
+func (c *ChatWindow) SearchMode() {
+	log.Debug("SEARCH MODE")
+	c.mode = SearchMode
+	ms := NewMessageSearch(c)
+	c.HideConversation(ms)
+	c.app.SetFocus(ms)
+}
+
+// SelectMode enters select mode, which puts a cursor on the newest message that we can move
+// around and act on
+func (c *ChatWindow) SelectMode() {
+	log.Debug("SELECT MODE")
+	c.conversationPanel.SetBorderColor(c.focusBorder())
+	c.mode = SelectMode
+	c.SetInputCapture(c.selectKeybinds)
+	c.conversationPanel.SelectLast()
+}
+
 // NormalMode enters normal mode
 func (c *ChatWindow) NormalMode() {
 	log.Debug("NORMAL MODE")
 	c.app.SetFocus(c)
+	c.conversationPanel.ClearSelection()
 
 	// clear our highlights
-	c.conversationPanel.SetBorderColor(tcell.ColorWhite)
-	c.sendPanel.SetBorderColor(tcell.ColorWhite)
+	c.conversationPanel.SetBorderColor(tview.Styles.BorderColor)
+	c.sendPanel.SetBorderColor(tview.Styles.BorderColor)
 	c.mode = NormalMode
 	c.SetInputCapture(c.normalKeybinds)
 	// save draft
@@ -110,13 +152,14 @@ func (c *ChatWindow) NormalMode() {
 // ShowConversation ensures that the conversation panel is showing. This should be called when
 // any widget is done hiding the conversation panel
 func (c *ChatWindow) ShowConversation() {
-	c.Grid.AddItem(c.conversationPanel, 0, 1, 1, 1, 0, 0, false)
+	c.mainPanel = c.conversationPanel
+	c.Relayout()
 }
 
 // HideConversation temporarily replaces the conversation panel with another widget
 func (c *ChatWindow) HideConversation(replacement tview.Primitive) {
-	c.Grid.RemoveItem(c.conversationPanel)
-	c.Grid.AddItem(replacement, 0, 1, 1, 1, 0, 0, false)
+	c.mainPanel = replacement
+	c.Relayout()
 }
 
 // YankLastMsg copies the last message of a conversation to the clipboard.
@@ -133,21 +176,43 @@ func (c *ChatWindow) YankLastMsg() {
 	}
 	var lastMsg *model.Message
 	if lastMsg = conv.LastMessage(); lastMsg == nil {
-		c.SetStatus("📋<NO MESSAGES>") // this is fine
+		c.SetStatus(c.theme.ClipboardGlyph + "<NO MESSAGES>") // this is fine
 		return
 	}
-	content := strings.TrimSpace(lastMsg.Content)
+	content := strings.TrimSpace(lastMsg.Text())
 	err = clipboard.WriteAll(content)
 	if err != nil {
 		c.SetErrorStatus(err)
 		return
 	}
-	c.SetStatus(fmt.Sprintf("📋%s", content))
+	c.SetStatus(fmt.Sprintf("%s%s", c.theme.ClipboardGlyph, content))
 }
 
+// getLinks returns every link in the current conversation, oldest first. Messages are searched
+// rather than the conversation panel, where long links are wrapped over several lines.
 func (c *ChatWindow) getLinks() []string {
-	toSearch := c.conversationPanel.GetText(true)
-	return urlRegex.FindAllString(toSearch, -1)
+	links := make([]string, 0)
+	conv, err := c.currentConversation()
+	if err != nil {
+		return links
+	}
+	for _, key := range conv.MessageOrder {
+		links = append(links, conv.Messages[key].Links()...)
+	}
+	return links
+}
+
+// getLinkTitles returns the titles of links in the current conversation that came with previews
+func (c *ChatWindow) getLinkTitles() map[string]string {
+	conv, err := c.currentConversation()
+	if err != nil {
+		return nil
+	}
+	msgs := make([]*model.Message, 0, len(conv.MessageOrder))
+	for _, key := range conv.MessageOrder {
+		msgs = append(msgs, conv.Messages[key])
+	}
+	return linkTitles(msgs...)
 }
 
 func (c *ChatWindow) getAttachments() []*model.Attachment {
@@ -177,9 +242,9 @@ func (c *ChatWindow) YankLastLink() {
 			c.SetErrorStatus(err)
 			return
 		}
-		c.SetStatus(fmt.Sprintf("📋%s", last))
+		c.SetStatus(fmt.Sprintf("%s%s", c.theme.ClipboardGlyph, last))
 	} else {
-		c.SetStatus(fmt.Sprintf("📋<NO MATCHES>"))
+		c.SetStatus(fmt.Sprintf("%s<NO MATCHES>", c.theme.ClipboardGlyph))
 	}
 }
 
@@ -192,48 +257,91 @@ func (c *ChatWindow) FocusMe() {
 func (c *ChatWindow) ShowContactSearch() {
 	log.Debug("SHOWING CONTACT SEARCH")
 	p := NewContactSearch(c)
-	c.searchPanel = p
-	c.SetRows(0, 3, p.maxHeight)
-	c.AddItem(p, 2, 0, 1, 2, 0, 0, false)
+	c.showBottom(p, p.maxHeight)
 	c.app.SetFocus(p)
 }
 
 // HideSearch hides any current search panel
 func (c *ChatWindow) HideSearch() {
 	log.Debug("HIDING SEARCH")
-	c.RemoveItem(c.searchPanel)
-	c.SetRows(0, 3)
+	c.hideBottom()
 	c.FocusMe()
 }
 
-// ShowAttachInput opens a commandPanel to choose a file to attach
+// ShowAttachInput opens a command input to choose a file to attach
 func (c *ChatWindow) ShowAttachInput() {
 	log.Debug("SHOWING CONTACT SEARCH")
 	p := NewAttachInput(c)
-	c.commandPanel = p
-	c.SetRows(0, 3, 1)
-	c.AddItem(p, 2, 0, 1, 2, 0, 0, false)
+	c.showBottom(p, 1)
 	c.app.SetFocus(p)
 }
 
+// ShowCommandLine opens a command input to enter a command, like in vim
+func (c *ChatWindow) ShowCommandLine() {
+	log.Debug("SHOWING COMMAND LINE")
+	p := NewCommandLine(c)
+	c.showBottom(p, 1)
+	c.app.SetFocus(p)
+}
+
+// ShowFindInput opens a command input to search the current conversation
+func (c *ChatWindow) ShowFindInput(backward bool) {
+	log.Debug("SHOWING FIND INPUT")
+	p := NewFindInput(c, backward)
+	c.showBottom(p, 1)
+	c.app.SetFocus(p)
+}
+
+// Find highlights the matches of `pattern` in the current conversation and jumps to the first one
+func (c *ChatWindow) Find(pattern string, backward bool) {
+	if _, err := c.conversationPanel.Find(pattern, backward); err != nil {
+		c.SetErrorStatus(fmt.Errorf("bad pattern: %v", err))
+		return
+	}
+	c.showFindStatus(c.conversationPanel.currentMatch, len(c.conversationPanel.matchRows))
+}
+
+// FindNext jumps to the next match of the last search, or the previous one if `reverse`
+func (c *ChatWindow) FindNext(reverse bool) {
+	if c.conversationPanel.SearchPattern() == "" {
+		c.SetErrorStatus(fmt.Errorf("no previous search"))
+		return
+	}
+	c.showFindStatus(c.conversationPanel.FindNext(reverse))
+}
+
+func (c *ChatWindow) showFindStatus(current, total int) {
+	prefix := "/"
+	if c.conversationPanel.backward {
+		prefix = "?"
+	}
+	pattern := prefix + c.conversationPanel.SearchPattern()
+	if total == 0 {
+		c.SetErrorStatus(fmt.Errorf("pattern not found: %s", pattern))
+		return
+	}
+	c.SetStatus(fmt.Sprintf("%s [%d/%d]", pattern, current+1, total))
+}
+
 // HideCommandInput hides any current CommandInput panel
 func (c *ChatWindow) HideCommandInput() {
 	log.Debug("HIDING COMMAND INPUT")
-	c.RemoveItem(c.commandPanel)
-	c.SetRows(0, 3)
+	c.hideBottom()
 	c.FocusMe()
 }
 
-// ShowStatusBar shows the bottom status bar
+// ShowStatusBar shows the bottom status bar, unless something else is there, like a command input
 func (c *ChatWindow) ShowStatusBar() {
-	c.SetRows(0, 3, 1)
-	c.AddItem(c.statusBar, 2, 0, 1, 2, 0, 0, false)
+	if c.bottom == nil {
+		c.showBottom(c.statusBar, 1)
+	}
 }
 
 // HideStatusBar stops showing the status bar
 func (c *ChatWindow) HideStatusBar() {
-	c.RemoveItem(c.statusBar) // do we actually need to do this?
-	c.SetRows(0, 3)
+	if c.bottom == c.statusBar {
+		c.hideBottom()
+	}
 }
 
 // SetStatus shows a status message on the status bar
@@ -270,6 +378,9 @@ func (c *ChatWindow) currentContactName() string {
 func (c *ChatWindow) SetCurrentContact(contact *model.Contact) error {
 	log.Debugf("setting current contact to: %v", contact)
 	c.currentContact = contact
+	// contacts we haven't talked to yet don't have a conversation
+	c.siggo.OpenConversation(contact)
+	c.conversationPanel.ClearHighlight()
 	c.contactsPanel.GotoContact(contact)
 	c.contactsPanel.Render()
 	conv, err := c.currentConversation()
@@ -284,6 +395,41 @@ func (c *ChatWindow) SetCurrentContact(contact *model.Contact) error {
 	return nil
 }
 
+// GotoMessage switches to the conversation with `contact` and highlights the message with `key`,
+// loading older messages until we get to it
+func (c *ChatWindow) GotoMessage(contact *model.Contact, key model.MessageKey) {
+	if err := c.SetCurrentContact(contact); err != nil {
+		c.SetErrorStatus(err)
+		return
+	}
+	conv, err := c.currentConversation()
+	if err != nil {
+		c.SetErrorStatus(err)
+		return
+	}
+	found, err := c.siggo.GotoMessage(conv, key)
+	if err != nil {
+		c.SetErrorStatus(fmt.Errorf("failed to load older messages: %v", err))
+		return
+	}
+	if !found {
+		c.SetErrorStatus(fmt.Errorf("message is no longer in the conversation with %s", contact))
+		return
+	}
+	c.conversationPanel.HighlightMessage(conv, key)
+}
+
+// LoadOlder loads older messages of the current conversation from disk when we scroll past the
+// top of it
+func (c *ChatWindow) LoadOlder() {
+	n, err := c.conversationPanel.LoadOlder()
+	if err != nil {
+		c.SetErrorStatus(fmt.Errorf("failed to load older messages: %v", err))
+	} else if n > 0 {
+		c.SetStatus(fmt.Sprintf("loaded %d older messages", n))
+	}
+}
+
 // NextUnreadMessage searches for the next conversation with unread messages and makes that the
 // active conversation.
 func (c *ChatWindow) NextUnreadMessage() error {
@@ -345,7 +491,6 @@ func (c *ChatWindow) Compose() {
 	if msg != "" {
 		msg = emoji.Sprint(msg)
 		contact := c.currentContact
-		c.ShowTempSentMsg(msg)
 		go c.siggo.Send(msg, contact)
 		log.Infof("sending message: %s to contact: %s", msg, contact)
 	}
@@ -387,20 +532,14 @@ func (c *ChatWindow) FancyAttach() {
 	}
 }
 
-// ShowTempSentMsg shows a temporary message when a message is sent but before delivery.
-// Only displayed for the second or two after a message is sent.
-func (c *ChatWindow) ShowTempSentMsg(msg string) {
-	tmpMsg := &model.Message{
-		Content:     msg,
-		From:        " ~ ",
-		Timestamp:   time.Now().Unix() * 1000,
-		IsDelivered: false,
-		IsRead:      false,
-		FromSelf:    true,
+// RetryFailed retries sending any failed messages in the current conversation
+func (c *ChatWindow) RetryFailed() {
+	n := c.siggo.RetryFailed(c.currentContact)
+	if n == 0 {
+		c.SetStatus("⟳<NO FAILED MESSAGES>")
+		return
 	}
-	// write directly to conv panel but don't add to conversation
-	// no color since its from us
-	c.conversationPanel.Write([]byte(tmpMsg.String()))
+	c.SetStatus(fmt.Sprintf("⟳retrying %d message(s)", n))
 }
 
 // Quit shuts down gracefully
@@ -410,6 +549,22 @@ func (c *ChatWindow) Quit() {
 	os.Exit(0)
 }
 
+// applyConfig shows or hides the panel titles and phone numbers, as configured
+func (c *ChatWindow) applyConfig() {
+	cfg := c.siggo.Config()
+	c.conversationPanel.hideTitle = cfg.HidePanelTitles
+	c.conversationPanel.hidePhoneNumber = cfg.HidePhoneNumbers
+	c.contactsPanel.previews = cfg.Layout.ContactPreviews
+	if cfg.HidePanelTitles {
+		c.contactsPanel.SetTitle("")
+		c.sendPanel.SetTitle("")
+		c.conversationPanel.SetTitle("")
+	} else {
+		c.contactsPanel.SetTitle("contacts")
+		c.sendPanel.SetTitle(" send: ")
+	}
+}
+
 func (c *ChatWindow) update() {
 	convs := c.siggo.Conversations()
 	if convs != nil && len(convs) > 0 {
@@ -432,18 +587,81 @@ func (c *ChatWindow) update() {
 	}
 }
 
+// SearchPanel lets us find a contact or group by typing part of its name, alias or number
 type SearchPanel struct {
 	*tview.Grid
 	list      *tview.TextView
 	input     *SearchInput
 	parent    *ChatWindow
 	maxHeight int
+	matches   []*model.Contact
+	selected  int
 }
 
 func (p *SearchPanel) Close() {
 	p.parent.HideSearch()
 }
 
+// Update finds the contacts matching `query`, best match first, and selects the first one
+func (p *SearchPanel) Update(query string) {
+	p.matches = p.parent.siggo.Contacts().FuzzyFind(query)
+	p.selected = 0
+	p.Render()
+}
+
+// Render shows as many matches as fit, scrolling so that the selected one is visible
+func (p *SearchPanel) Render() {
+	rows := p.maxHeight - 3
+	first := 0
+	if p.selected >= rows {
+		first = p.selected - rows + 1
+	}
+	data := ""
+	for i := first; i < len(p.matches) && i < first+rows; i++ {
+		c := p.matches[i]
+		line := tview.Escape(c.String())
+		if c.Number != c.String() && !c.IsGroup() {
+			line += fmt.Sprintf(" [::d]%s[::-]", c.Number)
+		}
+		if i == p.selected {
+			line = fmt.Sprintf("[%s::r]%s[-::-]", c.Color(), line)
+		} else {
+			line = fmt.Sprintf("[%s::]%s[-::]", c.Color(), line)
+		}
+		data += line + "\n"
+	}
+	p.list.SetText(data)
+	p.SetTitle(fmt.Sprintf("search contacts... (%d)", len(p.matches)))
+}
+
+// Next selects the next match
+func (p *SearchPanel) Next() {
+	if p.selected < len(p.matches)-1 {
+		p.selected++
+		p.Render()
+	}
+}
+
+// Previous selects the previous match
+func (p *SearchPanel) Previous() {
+	if p.selected > 0 {
+		p.selected--
+		p.Render()
+	}
+}
+
+// Select closes the search and switches to the selected contact
+func (p *SearchPanel) Select() {
+	if len(p.matches) == 0 {
+		return
+	}
+	contact := p.matches[p.selected]
+	p.Close()
+	if err := p.parent.SetCurrentContact(contact); err != nil {
+		p.parent.SetErrorStatus(err)
+	}
+}
+
 func NewContactSearch(parent *ChatWindow) *SearchPanel {
 	maxHeight := 10
 	p := &SearchPanel{
@@ -452,12 +670,12 @@ func NewContactSearch(parent *ChatWindow) *SearchPanel {
 		parent:    parent,
 		maxHeight: maxHeight,
 	}
-	//contactList := parent.siggo.Contacts().SortedByName()
+	p.list.SetDynamicColors(true)
 	p.input = NewSearchInput(p)
 	p.AddItem(p.list, 0, 0, 1, 1, 0, 0, false)
 	p.AddItem(p.input, 1, 0, 1, 1, 0, 0, true)
 	p.SetBorder(true)
-	p.SetTitle("search contacts...")
+	p.Update("")
 	return p
 }
 
@@ -472,6 +690,7 @@ func NewSearchInput(parent *SearchPanel) *SearchInput {
 		parent:     parent,
 	}
 	si.SetLabel("> ")
+	si.SetChangedFunc(parent.Update)
 	si.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		// Setup keys
 		log.Debugf("Key Event <SEARCH>: %v mods: %v rune: %v", event.Key(), event.Modifiers(), event.Rune())
@@ -479,6 +698,15 @@ func NewSearchInput(parent *SearchPanel) *SearchInput {
 		case tcell.KeyESC:
 			si.parent.Close()
 			return nil
+		case tcell.KeyEnter:
+			si.parent.Select()
+			return nil
+		case tcell.KeyDown, tcell.KeyCtrlJ, tcell.KeyTab:
+			si.parent.Next()
+			return nil
+		case tcell.KeyUp, tcell.KeyCtrlK, tcell.KeyBacktab:
+			si.parent.Previous()
+			return nil
 		}
 		return event
 	})
@@ -498,136 +726,121 @@ func NewStatusBar(parent *ChatWindow) *StatusBar {
 	return sb
 }
 
-func NewChatWindow(siggo *model.Siggo, app *tview.Application) *ChatWindow {
-	layout := tview.NewGrid().
-		SetRows(0, 3).
-		SetColumns(20, 0)
+// NewChatWindow creates the main panel of the UI, with keys bound as in `keymap` and styled by
+// `theme`
+func NewChatWindow(siggo *model.Siggo, app *tview.Application, keymap *Keymap, theme *model.Theme) *ChatWindow {
+	ApplyTheme(theme)
+	layout := siggo.Config().Layout
 	w := &ChatWindow{
-		Grid:  layout,
-		siggo: siggo,
-		app:   app,
+		Grid:           tview.NewGrid(),
+		siggo:          siggo,
+		app:            app,
+		keymap:         keymap,
+		theme:          theme,
+		layout:         layout,
+		compact:        layout.Compact,
+		contactsHidden: layout.Compact || layout.HideContacts,
 	}
 
-	w.conversationPanel = NewConversationPanel(siggo)
+	w.conversationPanel = NewConversationPanel(siggo, theme)
 	convInputHandler := w.conversationPanel.InputHandler()
 	w.contactsPanel = NewContactListPanel(w, siggo)
 	w.sendPanel = NewSendPanel(w, siggo)
 	w.statusBar = NewStatusBar(w)
-	// NORMAL MODE KEYBINDINGS
-	w.normalKeybinds = func(event *tcell.EventKey) *tcell.EventKey {
-		log.Debugf("Key Event <NORMAL>: %v mods: %v rune: %v", event.Key(), event.Modifiers(), event.Rune())
-		switch event.Key() {
-		case tcell.KeyRune:
-			switch event.Rune() {
-			case 106: // j
-				convInputHandler(event, func(p tview.Primitive) {})
-				return nil
-			case 107: // k
-				convInputHandler(event, func(p tview.Primitive) {})
-				return nil
-			case 74: // J
-				w.ContactDown()
-				return nil
-			case 75: // K
-				w.ContactUp()
-				return nil
-			case 105: // i
-				w.InsertMode()
-				return nil
-			case 73: // I
-				w.Compose()
-				return nil
-			case 121: // y
-				w.YankMode()
-				return nil
-			case 111: // o
-				w.OpenMode()
-				return nil
-			case 108: // l
-				w.LinkMode()
-				return nil
-			case 97: // a
-				w.ShowAttachInput()
-				return nil
-			case 65: // a
-				w.FancyAttach()
-				return nil
+	// scroll passes a key on to the conversation panel, older messages are loaded at the top
+	scroll := func(key tcell.Key, older bool) func() {
+		return func() {
+			if older {
+				w.LoadOlder()
 			}
-			// pass some events on to the conversation panel
-		case tcell.KeyCtrlQ:
-			w.Quit()
-		case tcell.KeyPgUp:
-			convInputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyPgDn:
-			convInputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyUp:
-			convInputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyDown:
-			convInputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyEnd:
-			convInputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyHome:
-			convInputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyESC:
-			w.NormalMode()
-			w.HideStatusBar()
-			return nil
-		case tcell.KeyCtrlT:
-			w.ShowContactSearch()
-			return nil
-		case tcell.KeyCtrlN:
-			w.NextUnreadMessage()
-			return nil
+			convInputHandler(tcell.NewEventKey(key, 0, tcell.ModNone), func(p tview.Primitive) {})
 		}
-		return event
 	}
-	w.yankKeybinds = func(event *tcell.EventKey) *tcell.EventKey {
-		log.Debugf("Key Event <YANK>: %v mods: %v rune: %v", event.Key(), event.Modifiers(), event.Rune())
-		switch event.Key() {
-		case tcell.KeyRune:
-			switch event.Rune() {
-			case 121: // y
-				w.YankLastMsg()
-				return nil
-			case 108: // l
-				w.YankLastLink()
-				return nil
-			}
-		case tcell.KeyCtrlQ:
-			w.Quit()
-		case tcell.KeyESC:
+	// NORMAL MODE KEYBINDINGS
+	w.normalKeybinds = keymap.Handler(KeymapNormal, map[string]func(){
+		"scroll-down":      scroll(tcell.KeyDown, false),
+		"scroll-up":        scroll(tcell.KeyUp, true),
+		"page-down":        scroll(tcell.KeyPgDn, false),
+		"page-up":          scroll(tcell.KeyPgUp, true),
+		"scroll-to-start":  scroll(tcell.KeyHome, true),
+		"scroll-to-end":    scroll(tcell.KeyEnd, false),
+		"next-contact":     w.ContactDown,
+		"previous-contact": w.ContactUp,
+		"next-unread":      func() { w.NextUnreadMessage() },
+		"insert-mode":      w.InsertMode,
+		"compose":          w.Compose,
+		"yank-mode":        w.YankMode,
+		"open-mode":        w.OpenMode,
+		"link-mode":        w.LinkMode,
+		"select-mode":      w.SelectMode,
+		"attach":           w.ShowAttachInput,
+		"fancy-attach":     w.FancyAttach,
+		"retry-failed":     w.RetryFailed,
+		"find-forward":     func() { w.ShowFindInput(false) },
+		"find-backward":    func() { w.ShowFindInput(true) },
+		"find-next":        func() { w.FindNext(false) },
+		"find-previous":    func() { w.FindNext(true) },
+		"search-messages":  w.SearchMode,
+		"search-contacts":  w.ShowContactSearch,
+		"command-line":     w.ShowCommandLine,
+		"toggle-contacts":  w.ToggleContacts,
+		"toggle-compact":   w.ToggleCompact,
+		"toggle-images":    w.conversationPanel.ToggleImages,
+		"grow-contacts":    func() { w.ResizeContacts(resizeStep) },
+		"shrink-contacts":  func() { w.ResizeContacts(-resizeStep) },
+		"reset-layout":     w.ResetLayout,
+		"clear": func() {
 			w.NormalMode()
-			return nil
+			w.HideStatusBar()
+			w.conversationPanel.ClearHighlight()
+			w.conversationPanel.ClearSearch()
+		},
+		"quit": w.Quit,
+	})
+	w.yankKeybinds = keymap.Handler(KeymapYank, map[string]func(){
+		"yank-last-message": w.YankLastMsg,
+		"yank-last-link":    w.YankLastLink,
+		"normal-mode":       w.NormalMode,
+		"quit":              w.Quit,
+	})
+	// SELECT MODE KEYBINDINGS
+	move := func(n int) func() {
+		return func() {
+			if err := w.conversationPanel.MoveSelection(n); err != nil {
+				w.SetErrorStatus(fmt.Errorf("failed to load older messages: %v", err))
+			}
 		}
-		return event
 	}
+	w.selectKeybinds = keymap.Handler(KeymapSelect, map[string]func(){
+		"select-next":     move(1),
+		"select-previous": move(-1),
+		"select-first":    w.conversationPanel.SelectFirst,
+		"select-last":     w.conversationPanel.SelectLast,
+		"yank":            w.YankSelected,
+		"open":            w.OpenSelected,
+		"link":            w.LinkSelected,
+		"reply":           w.ReplySelected,
+		"react":           w.ReactSelected,
+		"delete":          w.DeleteSelected,
+		"details":         w.ShowDetails,
+		"spoilers":        w.ToggleSpoilers,
+		"normal-mode":     w.NormalMode,
+		"quit":            w.Quit,
+	})
 	w.SetInputCapture(w.normalKeybinds)
 
-	// primitiv, row, col, rowSpan, colSpan, minGridHeight, maxGridHeight, focus)
-	// TODO: lets make some of the spans confiurable?
-	w.AddItem(w.contactsPanel, 0, 0, 2, 1, 0, 0, false)
-	//w.AddItem(w.conversationPanel, 0, 1, 1, 1, 0, 0, false)
 	w.ShowConversation()
-	w.AddItem(w.sendPanel, 1, 1, 1, 1, 0, 0, false)
 
-	if w.siggo.Config().HidePanelTitles {
-		w.contactsPanel.SetTitle("")
-		w.sendPanel.SetTitle("")
-		w.conversationPanel.SetTitle("")
-		w.conversationPanel.hideTitle = true
-	}
-	if w.siggo.Config().HidePhoneNumbers {
-		w.conversationPanel.hidePhoneNumber = true
+	w.applyConfig()
+
+	history, err := model.LoadCommandHistory(model.CommandHistoryPath())
+	if err != nil {
+		log.Errorf("failed to load command history: %v", err)
 	}
+	w.commandHistory = history
 
 	w.siggo = siggo
-	contacts := siggo.Contacts().SortedByIndex()
+	contacts := siggo.Contacts().Sorted(layout.ContactSort, siggo.Conversations())
 	log.Debugf("contacts found: %v", contacts)
 	if len(contacts) > 0 {
 		w.currentContact = contacts[0]
@@ -635,12 +848,34 @@ func NewChatWindow(siggo *model.Siggo, app *tview.Application) *ChatWindow {
 	// update gui when events happen in siggo
 	w.update()
 	w.conversationPanel.ScrollToEnd()
+	// siggo can be holding on to its conversations when it tells us about them, so don't wait for
+	// the update, which could be waiting for them
 	siggo.NewInfo = func(conv *model.Conversation) {
-		app.QueueUpdateDraw(func() {
+		go app.QueueUpdateDraw(func() {
 			w.update()
 		})
 	}
+	// autosave on the event loop, so it doesn't save a conversation we are in the middle of
+	// changing
+	siggo.Queue = func(f func()) {
+		app.QueueUpdate(f)
+	}
 	siggo.ErrorEvent = w.SetErrorStatus
+	w.conversationPanel.SetImageLoadedFunc(func() {
+		app.QueueUpdateDraw(func() {
+			if w.conversationPanel.conv != nil {
+				w.conversationPanel.Update(w.conversationPanel.conv)
+			}
+		})
+	})
+	app.SetAfterDrawFunc(w.conversationPanel.DrawImages)
+	// relative times like "5m ago" go stale even when nothing happens, so draw once a minute. The
+	// conversation is only wrapped again if it shows them.
+	go func() {
+		for range time.Tick(time.Minute) {
+			app.QueueUpdateDraw(func() {})
+		}
+	}()
 	return w
 }
 
@@ -710,7 +945,7 @@ func GetSharedPrefix(s ...string) string {
 	for i := 0; i < len(s[0]); i++ {
 		c := s[0][i]
 		for _, str := range s {
-			if str[i] != c {
+			if i >= len(str) || str[i] != c {
 				return out.String()
 			}
 		}
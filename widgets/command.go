@@ -0,0 +1,594 @@
+package widgets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/derricw/siggo/model"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	log "github.com/sirupsen/logrus"
+)
+
+// errUsage is returned by commands called with the wrong arguments, to show how to use them
+var errUsage = errors.New("wrong arguments")
+
+// exCommand is a command that can be entered on the command line
+type exCommand struct {
+	name  string
+	usage string
+	// complete returns what the argument being typed could be, `arg` being what we have of it so
+	// far. Commands without arguments to complete leave it nil. If words is set, arguments are
+	// single words and `previous` are the ones before, otherwise everything after the name is one
+	// argument.
+	complete func(c *ChatWindow, previous []string, arg string) []string
+	words    bool
+	// bang is set for commands that can be run with a ! after the name, like :export! to overwrite
+	bang bool
+	// run runs the command with the rest of the line after its name, returning errUsage if that
+	// doesn't make sense. `bang` is set if the name had a ! after it.
+	run func(c *ChatWindow, args string, bang bool) error
+}
+
+// exCommands are the commands of the command line, in the order they are completed
+var exCommands = []*exCommand{
+	{"attach", "attach <path>", completePath, false, false, runAttach},
+	{"alias", "alias [alias]", nil, false, false, runAlias},
+	{"color", "color [color]", completeColor, false, false, runColor},
+	{"export", "export[!] <path.md|html|json|txt>", completePath, false, true, runExport},
+	{"goto", "goto <contact>", completeContact, false, false, runGoto},
+	{"group", "group add <number|contact>...", completeGroup, true, false, runGroup},
+	{"set", "set [option|nooption|option!|option?]...", completeOption, true, false, runSet},
+	{"w", "w", nil, false, false, runWrite},
+	{"q", "q", nil, false, false, runQuit},
+	{"wq", "wq", nil, false, false, runWriteQuit},
+}
+
+// findExCommand finds the command called `name`, or the only one starting with it
+func findExCommand(name string) (*exCommand, error) {
+	var found []*exCommand
+	for _, cmd := range exCommands {
+		if cmd.name == name {
+			return cmd, nil
+		}
+		if strings.HasPrefix(cmd.name, name) {
+			found = append(found, cmd)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("not a command: %s", name)
+	case 1:
+		return found[0], nil
+	}
+	names := make([]string, 0, len(found))
+	for _, cmd := range found {
+		names = append(names, cmd.name)
+	}
+	return nil, fmt.Errorf("ambiguous command: %s (%s)", name, strings.Join(names, ", "))
+}
+
+// splitCommand splits a command line into the command name and the rest of it
+func splitCommand(line string) (string, string) {
+	line = strings.TrimLeft(strings.TrimSpace(line), ":")
+	i := strings.IndexFunc(line, func(r rune) bool { return r == ' ' || r == '\t' })
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i:])
+}
+
+// RunCommand runs a line entered on the command line
+func (c *ChatWindow) RunCommand(line string) error {
+	name, args := splitCommand(line)
+	bang := strings.HasSuffix(name, "!")
+	name = strings.TrimSuffix(name, "!")
+	if name == "" {
+		return nil
+	}
+	cmd, err := findExCommand(name)
+	if err != nil {
+		return err
+	}
+	if bang && !cmd.bang {
+		return fmt.Errorf("%s doesn't take a !", cmd.name)
+	}
+	if err := cmd.run(c, args, bang); err == errUsage {
+		return fmt.Errorf("usage: %s", cmd.usage)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// expandPath expands a leading ~ to the home folder
+func expandPath(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return path
+	}
+	return usr.HomeDir + path[1:]
+}
+
+// currentContactOrError returns the current contact, or an error if there isn't one
+func (c *ChatWindow) currentContactOrError() (*model.Contact, error) {
+	if c.currentContact == nil {
+		return nil, fmt.Errorf("no conversation selected")
+	}
+	return c.currentContact, nil
+}
+
+func runAttach(c *ChatWindow, args string, bang bool) error {
+	if args == "" {
+		return errUsage
+	}
+	conv, err := c.currentConversation()
+	if err != nil {
+		return err
+	}
+	path := expandPath(args)
+	if err := conv.AddAttachment(path); err != nil {
+		return fmt.Errorf("failed to attach: %s - %v", path, err)
+	}
+	c.sendPanel.Update()
+	c.InsertMode()
+	return nil
+}
+
+func runAlias(c *ChatWindow, args string, bang bool) error {
+	contact, err := c.currentContactOrError()
+	if err != nil {
+		return err
+	}
+	if err := c.siggo.SetAlias(contact, args); err != nil {
+		return err
+	}
+	if err := c.siggo.SaveConfig(); err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	c.update()
+	return nil
+}
+
+func runColor(c *ChatWindow, args string, bang bool) error {
+	contact, err := c.currentContactOrError()
+	if err != nil {
+		return err
+	}
+	color := strings.ToLower(args)
+	if color != "" && tcell.GetColor(color) == tcell.ColorDefault {
+		return fmt.Errorf("color is not valid W3C color: %s", color)
+	}
+	if err := c.siggo.SetColor(contact, color); err != nil {
+		return err
+	}
+	if err := c.siggo.SaveConfig(); err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	c.update()
+	return nil
+}
+
+func runExport(c *ChatWindow, args string, bang bool) error {
+	contact, err := c.currentContactOrError()
+	if err != nil {
+		return err
+	}
+	if args == "" {
+		return errUsage
+	}
+	path := expandPath(args)
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	if format == "" {
+		format = model.ExportMarkdown
+		path += "." + format
+	}
+	known := false
+	for _, f := range model.ExportFormats {
+		known = known || f == format
+	}
+	if !known {
+		return fmt.Errorf("unknown export format: %s (use one of %s)", format,
+			strings.Join(model.ExportFormats, ", "))
+	}
+	msgs, err := c.siggo.History(contact, 0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to read conversation: %v", err)
+	}
+	cfg := c.siggo.Config()
+	export := model.NewExport(contact, msgs, &cfg, c.theme.SpoilerGlyph, func(a *model.Attachment) string {
+		if p, err := a.Path(); err == nil {
+			return p
+		}
+		return a.DisplayName()
+	})
+	f, err := createExportFile(path, bang)
+	if err != nil {
+		return err
+	}
+	if err := export.Write(f, format); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to export: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	c.SetStatus(fmt.Sprintf("exported %d messages to %s", len(msgs), path))
+	return nil
+}
+
+// createExportFile creates the file to export to, which must not exist unless `overwrite` is set
+func createExportFile(path string, overwrite bool) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%s already exists (add ! to overwrite)", path)
+	}
+	return f, err
+}
+
+// lookupContact finds a contact or group by number, name or alias, or failing that the best fuzzy
+// match
+func (c *ChatWindow) lookupContact(query string) (*model.Contact, error) {
+	contacts := c.siggo.Contacts()
+	for _, group := range []bool{false, true} {
+		if contact, err := contacts.Lookup(query, group); err == nil {
+			return contact, nil
+		}
+	}
+	if matches := contacts.FuzzyFind(query); len(matches) > 0 {
+		return matches[0], nil
+	}
+	return nil, fmt.Errorf("no contact matching: %s", query)
+}
+
+func runGoto(c *ChatWindow, args string, bang bool) error {
+	if args == "" {
+		return errUsage
+	}
+	contact, err := c.lookupContact(args)
+	if err != nil {
+		return err
+	}
+	return c.SetCurrentContact(contact)
+}
+
+func runGroup(c *ChatWindow, args string, bang bool) error {
+	fields := strings.Fields(args)
+	if len(fields) < 2 || fields[0] != "add" {
+		return errUsage
+	}
+	group, err := c.currentContactOrError()
+	if err != nil {
+		return err
+	}
+	members := make([]model.PhoneNumber, 0, len(fields)-1)
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "+") {
+			members = append(members, field)
+			continue
+		}
+		contact, err := c.siggo.Contacts().Lookup(field, false)
+		if err != nil {
+			return err
+		}
+		members = append(members, contact.Number)
+	}
+	go func() {
+		err := c.siggo.AddGroupMembers(group, members...)
+		c.app.QueueUpdateDraw(func() {
+			if err != nil {
+				c.SetErrorStatus(fmt.Errorf("failed to add to %s: %v", group, err))
+				return
+			}
+			c.SetStatus(fmt.Sprintf("added %s to %s", strings.Join(members, ", "), group))
+		})
+	}()
+	return nil
+}
+
+func runSet(c *ChatWindow, args string, bang bool) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		settings := make([]string, 0)
+		for _, name := range c.siggo.Options() {
+			if on, _ := c.siggo.Option(name); on {
+				settings = append(settings, name)
+			} else {
+				settings = append(settings, "no"+name)
+			}
+		}
+		c.SetStatus(strings.Join(settings, " "))
+		return nil
+	}
+	shown := make([]string, 0)
+	for _, field := range fields {
+		name, value := field, true
+		toggle, show := false, false
+		switch {
+		case strings.HasSuffix(field, "!"):
+			name, toggle = strings.TrimSuffix(field, "!"), true
+		case strings.HasSuffix(field, "?"):
+			name, show = strings.TrimSuffix(field, "?"), true
+		case strings.HasPrefix(field, "no"):
+			if _, err := c.siggo.Option(field); err != nil {
+				name, value = strings.TrimPrefix(field, "no"), false
+			}
+		}
+		current, err := c.siggo.Option(name)
+		if err != nil {
+			return err
+		}
+		if show {
+			if !current {
+				name = "no" + name
+			}
+			shown = append(shown, name)
+			continue
+		}
+		if toggle {
+			value = !current
+		}
+		if err := c.siggo.SetOption(name, value); err != nil {
+			return err
+		}
+	}
+	c.applyConfig()
+	c.update()
+	if len(shown) > 0 {
+		c.SetStatus(strings.Join(shown, " "))
+	}
+	return nil
+}
+
+func runWrite(c *ChatWindow, args string, bang bool) error {
+	c.siggo.SaveConversations()
+	if err := c.siggo.SaveConfig(); err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	c.SetStatus("saved")
+	return nil
+}
+
+func runQuit(c *ChatWindow, args string, bang bool) error {
+	c.Quit()
+	return nil
+}
+
+func runWriteQuit(c *ChatWindow, args string, bang bool) error {
+	if err := runWrite(c, args, bang); err != nil {
+		return err
+	}
+	return runQuit(c, args, bang)
+}
+
+// withPrefix returns the options that start with `prefix`, ignoring case
+func withPrefix(prefix string, options []string) []string {
+	matches := make([]string, 0)
+	for _, option := range options {
+		if len(option) >= len(prefix) && strings.EqualFold(option[:len(prefix)], prefix) {
+			matches = append(matches, option)
+		}
+	}
+	return matches
+}
+
+func completePath(c *ChatWindow, previous []string, arg string) []string {
+	if arg == "" {
+		arg = "~/"
+	}
+	return []string{CompletePath(arg)}
+}
+
+func completeColor(c *ChatWindow, previous []string, arg string) []string {
+	names := make([]string, 0, len(tcell.ColorNames))
+	for name := range tcell.ColorNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return withPrefix(arg, names)
+}
+
+func completeContact(c *ChatWindow, previous []string, arg string) []string {
+	names := make([]string, 0)
+	for _, contact := range c.siggo.Contacts().SortedByName() {
+		names = append(names, contact.String())
+	}
+	return withPrefix(arg, names)
+}
+
+// completeGroup completes "add", and then contacts to add by number, since names can have spaces
+func completeGroup(c *ChatWindow, previous []string, arg string) []string {
+	if len(previous) == 0 {
+		return withPrefix(arg, []string{"add"})
+	}
+	numbers := make([]string, 0)
+	for _, contact := range c.siggo.Contacts().SortedByName() {
+		if !contact.IsGroup() && len(withPrefix(arg, []string{contact.String(), contact.Number})) > 0 {
+			numbers = append(numbers, contact.Number)
+		}
+	}
+	return numbers
+}
+
+func completeOption(c *ChatWindow, previous []string, arg string) []string {
+	options := make([]string, 0)
+	for _, name := range c.siggo.Options() {
+		options = append(options, name, "no"+name)
+	}
+	return withPrefix(arg, options)
+}
+
+// CommandLine is a command input for commands like :goto or :set, like the one in vim
+type CommandLine struct {
+	*CommandInput
+	// candidates are the completions we cycle through by hitting tab again, after `head`
+	head       string
+	candidates []string
+	candidate  int
+	// history is where we are in the command history while going through it, typed what we had
+	// typed before starting
+	history int
+	typed   string
+}
+
+// Complete completes the command name, or the last argument of the command
+func (cl *CommandLine) Complete() {
+	text := cl.GetText()
+	if len(cl.candidates) > 1 && text == cl.head+cl.candidates[cl.candidate] {
+		cl.candidate = (cl.candidate + 1) % len(cl.candidates)
+		cl.SetText(cl.head + cl.candidates[cl.candidate])
+		return
+	}
+	cl.candidates = nil
+	head, arg, candidates, complete := cl.completions(text)
+	switch {
+	case len(candidates) == 0:
+		return
+	case len(candidates) == 1:
+		cl.SetText(head + candidates[0] + complete)
+		return
+	}
+	shared := GetSharedPrefix(candidates...)
+	for !utf8.ValidString(shared) {
+		shared = shared[:len(shared)-1]
+	}
+	if len(shared) > len(arg) {
+		cl.SetText(head + shared)
+		return
+	}
+	cl.head, cl.candidates, cl.candidate = head, candidates, 0
+	cl.SetText(head + candidates[0])
+}
+
+// completions splits `text` into what comes before the part being completed, and that part, and
+// returns what it could be. complete is added after the only candidate.
+func (cl *CommandLine) completions(text string) (head, arg string, candidates []string, complete string) {
+	name, _ := splitCommand(text)
+	if !strings.ContainsAny(text, " \t") {
+		names := make([]string, 0, len(exCommands))
+		for _, cmd := range exCommands {
+			names = append(names, cmd.name)
+		}
+		return "", name, withPrefix(name, names), " "
+	}
+	cmd, err := findExCommand(strings.TrimSuffix(name, "!"))
+	if err != nil || cmd.complete == nil {
+		return "", "", nil, ""
+	}
+	if cmd.words {
+		i := strings.LastIndexAny(text, " \t")
+		head, arg = text[:i+1], text[i+1:]
+		previous := strings.Fields(strings.TrimPrefix(strings.TrimSpace(head), ":"))[1:]
+		return head, arg, cmd.complete(cl.parent, previous, arg), " "
+	}
+	rest := text[strings.Index(text, name)+len(name):]
+	arg = strings.TrimLeft(rest, " \t")
+	head = text[:len(text)-len(arg)]
+	return head, arg, cmd.complete(cl.parent, nil, arg), ""
+}
+
+// HistoryBack goes to the previous command in the history that starts with what we had typed
+func (cl *CommandLine) HistoryBack() {
+	history := cl.parent.commandHistory
+	if history == nil {
+		return
+	}
+	if cl.history == len(history.Commands) {
+		cl.typed = cl.GetText()
+	}
+	for i := cl.history - 1; i >= 0; i-- {
+		if strings.HasPrefix(history.Commands[i], cl.typed) {
+			cl.history = i
+			cl.SetText(history.Commands[i])
+			return
+		}
+	}
+}
+
+// HistoryForward goes to the next command in the history that starts with what we had typed, and
+// back to what we had typed after the last one
+func (cl *CommandLine) HistoryForward() {
+	history := cl.parent.commandHistory
+	if history == nil || cl.history == len(history.Commands) {
+		return
+	}
+	for i := cl.history + 1; i < len(history.Commands); i++ {
+		if strings.HasPrefix(history.Commands[i], cl.typed) {
+			cl.history = i
+			cl.SetText(history.Commands[i])
+			return
+		}
+	}
+	cl.history = len(history.Commands)
+	cl.SetText(cl.typed)
+}
+
+// Run runs the command that was entered, and remembers it
+func (cl *CommandLine) Run() {
+	line := cl.GetText()
+	cl.parent.HideCommandInput()
+	if history := cl.parent.commandHistory; history != nil {
+		if err := history.Add(line); err != nil {
+			log.Errorf("failed to save command history: %v", err)
+		}
+	}
+	if err := cl.parent.RunCommand(line); err != nil {
+		cl.parent.SetErrorStatus(err)
+	}
+}
+
+// NewCommandLine is a command input for commands like :goto <contact> or :set <option>
+func NewCommandLine(parent *ChatWindow) *CommandLine {
+	cl := &CommandLine{
+		CommandInput: &CommandInput{
+			InputField: tview.NewInputField(),
+			parent:     parent,
+		},
+	}
+	if parent.commandHistory != nil {
+		cl.history = len(parent.commandHistory.Commands)
+	}
+	cl.SetLabel(":")
+	cl.SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor)
+	cl.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		log.Debugf("Key Event <COMMAND>: %v mods: %v rune: %v", event.Key(), event.Modifiers(), event.Rune())
+		switch event.Key() {
+		case tcell.KeyESC:
+			cl.parent.HideCommandInput()
+			return nil
+		case tcell.KeyTAB:
+			cl.Complete()
+			return nil
+		case tcell.KeyUp, tcell.KeyCtrlP:
+			cl.HistoryBack()
+			return nil
+		case tcell.KeyDown, tcell.KeyCtrlN:
+			cl.HistoryForward()
+			return nil
+		case tcell.KeyEnter:
+			cl.Run()
+			return nil
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			// backspacing over the : leaves, like in vim
+			if cl.GetText() == "" {
+				cl.parent.HideCommandInput()
+				return nil
+			}
+		}
+		return event
+	})
+	return cl
+}
@@ -0,0 +1,119 @@
+package widgets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		line, name, args string
+	}{
+		{line: "goto Leeloo Dallas", name: "goto", args: "Leeloo Dallas"},
+		{line: ":set  hide_panel_titles ", name: "set", args: "hide_panel_titles"},
+		{line: "w", name: "w"},
+		{line: "export!\t~/leeloo.md", name: "export!", args: "~/leeloo.md"},
+		{line: "  "},
+	}
+	for _, test := range tests {
+		name, args := splitCommand(test.line)
+		assert.Equal(t, test.name, name, test.line)
+		assert.Equal(t, test.args, args, test.line)
+	}
+}
+
+func TestFindExCommand(t *testing.T) {
+	tests := []struct {
+		name, found, err string
+	}{
+		{name: "goto", found: "goto"},
+		{name: "go", found: "goto"},
+		{name: "w", found: "w"},
+		{name: "wq", found: "wq"},
+		{name: "g", err: "ambiguous command: g (goto, group)"},
+		{name: "zorg", err: "not a command: zorg"},
+	}
+	for _, test := range tests {
+		cmd, err := findExCommand(test.name)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err)
+			continue
+		}
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.found, cmd.name)
+		}
+	}
+
+	c := &ChatWindow{}
+	assert.EqualError(t, c.RunCommand("q!"), "q doesn't take a !")
+	assert.EqualError(t, c.RunCommand("zorg"), "not a command: zorg")
+	assert.NoError(t, c.RunCommand(":"))
+}
+
+func TestCommandCompletions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-complete-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "multipass.md"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cl := &CommandLine{CommandInput: &CommandInput{parent: &ChatWindow{}}}
+	tests := []struct {
+		text, head, arg string
+		candidates      []string
+		complete        string
+	}{
+		{text: "go", arg: "go", candidates: []string{"goto"}, complete: " "},
+		{text: ":g", arg: "g", candidates: []string{"goto", "group"}, complete: " "},
+		{text: "group a", head: "group ", arg: "a", candidates: []string{"add"}, complete: " "},
+		{text: "color greeny", head: "color ", arg: "greeny", candidates: []string{"greenyellow"}},
+		{
+			text:       "export! " + filepath.Join(dir, "multi"),
+			head:       "export! ",
+			arg:        filepath.Join(dir, "multi"),
+			candidates: []string{filepath.Join(dir, "multipass.md")},
+		},
+		{text: "w foo", candidates: nil},
+		{text: "zorg foo", candidates: nil},
+	}
+	for _, test := range tests {
+		head, arg, candidates, complete := cl.completions(test.text)
+		assert.Equal(t, test.head, head, test.text)
+		assert.Equal(t, test.arg, arg, test.text)
+		assert.Equal(t, test.candidates, candidates, test.text)
+		assert.Equal(t, test.complete, complete, test.text)
+	}
+}
+
+func TestCreateExportFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "leeloo.md")
+
+	f, err := createExportFile(path, false)
+	if assert.NoError(t, err) {
+		f.WriteString("multipass")
+		f.Close()
+	}
+	_, err = createExportFile(path, false)
+	assert.EqualError(t, err, path+" already exists (add ! to overwrite)")
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "multipass", string(b))
+
+	f, err = createExportFile(path, true)
+	if assert.NoError(t, err) {
+		f.Close()
+	}
+	b, _ = ioutil.ReadFile(path)
+	assert.Empty(t, b)
+}
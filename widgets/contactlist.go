@@ -2,96 +2,169 @@ package widgets
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/derricw/siggo/model"
+	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
 	log "github.com/sirupsen/logrus"
 )
 
-const DraftMarker = "~"
-
 type ContactListPanel struct {
 	*tview.TextView
-	siggo          *model.Siggo
-	parent         *ChatWindow
-	sortedContacts []*model.Contact
-	currentIndex   int
+	siggo  *model.Siggo
+	parent *ChatWindow
+	// order keeps the contacts sorted as configured, currentIndex is where the current contact is
+	// in it
+	order        *model.ContactOrder
+	currentIndex int
+	// previews shows the last message of each conversation under its name, cut to fit the width
+	// the list was rendered at
+	previews      bool
+	renderedWidth int
+}
+
+// contacts returns the contacts in the order they are listed
+func (cl *ContactListPanel) contacts() []*model.Contact {
+	return cl.order.List()
 }
 
 func (cl *ContactListPanel) Next() *model.Contact {
-	if cl.currentIndex < len(cl.sortedContacts)-1 {
-		cl.currentIndex++
-	}
-	return cl.sortedContacts[cl.currentIndex]
+	return cl.GotoIndex(min(cl.currentIndex+1, len(cl.contacts())-1))
 }
 
 func (cl *ContactListPanel) Previous() *model.Contact {
-	if cl.currentIndex > 0 {
-		cl.currentIndex--
-	}
-	return cl.sortedContacts[cl.currentIndex]
+	return cl.GotoIndex(max(cl.currentIndex-1, 0))
 }
 
 // GotoIndex goes to a particular contact index and return the Contact. Negative indexing is
 // allowed.
 func (cl *ContactListPanel) GotoIndex(index int) *model.Contact {
+	contacts := cl.contacts()
+	if len(contacts) == 0 {
+		return nil
+	}
 	if index < 0 {
-		return cl.GotoIndex(len(cl.sortedContacts) - index)
+		index += len(contacts)
 	}
-	if index >= len(cl.sortedContacts) {
-		return cl.GotoIndex(-1)
+	if index >= len(contacts) || index < 0 {
+		index = len(contacts) - 1
 	}
 	cl.currentIndex = index
-	return cl.sortedContacts[index]
+	return contacts[index]
 }
 
 // GotoContact goes to a particular contact.
-// TODO: constant time way to do this?
 func (cl *ContactListPanel) GotoContact(contact *model.Contact) {
-	for i, c := range cl.sortedContacts {
-		if contact == c {
-			cl.GotoIndex(i)
-		}
+	if i := cl.order.Position(contact); i >= 0 {
+		cl.currentIndex = i
 	}
 }
 
-// Render the contact list
+// Render the contact list. Only the contacts whose place in the order changed since the last
+// render are moved.
 func (cl *ContactListPanel) Render() {
-	data := ""
 	log.Debug("updating contact panel...")
-	// this is dumb, we re-sort every update
-	// TODO: don't
-	sorted := cl.siggo.Contacts().SortedByIndex()
 	convs := cl.siggo.Conversations()
-	log.Debugf("sorted contacts: %v", sorted)
-	for i, c := range sorted {
-		id := c.String()
-		line := fmt.Sprintf("%s", id)
-		color := c.Color()
+	cl.order.Update(cl.siggo.Contacts(), convs)
+	if i := cl.order.Position(cl.parent.currentContact); i >= 0 {
+		cl.currentIndex = i
+	}
+	_, _, cl.renderedWidth, _ = cl.GetInnerRect()
+	theme := cl.parent.theme
+	var b strings.Builder
+	for i, c := range cl.contacts() {
+		conv := convs[c]
+		line := tview.Escape(c.String())
+		style := "::"
 		if cl.currentIndex == i {
-			line = fmt.Sprintf("[%s::r]%s[-::-]", color, line)
-		} else if convs[c].HasNewMessage {
-			line = fmt.Sprintf("[%s::b]*%s[-::-]", color, line)
-		} else {
-			line = fmt.Sprintf("[%s::]%s[-::]", color, line)
+			style = theme.CurrentContact
+		} else if conv != nil && conv.HasNewMessage {
+			style = theme.UnreadContact
+			line = theme.UnreadContactGlyph + line
+			if n := conv.Unread(); n > 0 {
+				line += fmt.Sprintf(" (%d)", n)
+			}
+		}
+		if color := c.Color(); color != "" {
+			style = fmt.Sprintf("%s][%s::", style, color)
+		}
+		line = fmt.Sprintf("[%s]%s[-:-:-]", style, line)
+		if conv != nil && conv.HasStagedData() {
+			line += theme.DraftGlyph
 		}
-		if convs[c].HasStagedData() {
-			line += DraftMarker
+		b.WriteString(line + "\n")
+		if cl.previews {
+			b.WriteString(cl.preview(conv))
 		}
-		data += fmt.Sprintf("%s\n", line)
 	}
-	cl.sortedContacts = sorted
-	cl.SetText(data)
+	cl.SetText(b.String())
+	cl.scrollToCurrent()
+}
+
+// preview returns the line shown under a contact, the start of the last message in `conv`.
+// Spoilers stay hidden.
+func (cl *ContactListPanel) preview(conv *model.Conversation) string {
+	theme := cl.parent.theme
+	var msg *model.Message
+	if conv != nil {
+		msg = conv.LastMessage()
+	}
+	text := ""
+	if msg != nil {
+		text = strings.Join(strings.Fields(msg.MaskedText(theme.SpoilerGlyph)), " ")
+		if text == "" && len(msg.Attachments) > 0 {
+			text = fmt.Sprintf("%s %s", theme.AttachmentGlyph, msg.Attachments[0].DisplayName())
+		}
+		if msg.FromSelf {
+			text = fmt.Sprintf("%s %s", theme.SelfGlyph, text)
+		}
+	}
+	line := tview.Escape("  " + text)
+	if cl.renderedWidth > 1 {
+		if lines := wrap(line, cl.renderedWidth-1, cl.renderedWidth-1); len(lines) > 1 {
+			line = lines[0] + "…"
+		}
+	}
+	return fmt.Sprintf("[%s]%s[-:-:-]\n", theme.ContactPreview, line)
+}
+
+// scrollToCurrent scrolls the list so that the current contact is in view
+func (cl *ContactListPanel) scrollToCurrent() {
+	rows := 1
+	if cl.previews {
+		rows = 2
+	}
+	row := cl.currentIndex * rows
+	top, _ := cl.GetScrollOffset()
+	_, _, _, height := cl.GetInnerRect()
+	if row < top {
+		cl.ScrollTo(row, 0)
+	} else if height > 0 && row+rows > top+height {
+		cl.ScrollTo(row+rows-height, 0)
+	}
+}
+
+// Draw draws the contact list, rendering it again first if previews are shown and the panel
+// changed width
+func (cl *ContactListPanel) Draw(screen tcell.Screen) {
+	if _, _, width, _ := cl.GetInnerRect(); cl.previews && width != cl.renderedWidth {
+		cl.Render()
+	}
+	cl.TextView.Draw(screen)
 }
 
-// NewContactListPanel creates a new contact list widget
+// NewContactListPanel creates a new contact list widget, sorted as configured
 func NewContactListPanel(parent *ChatWindow, siggo *model.Siggo) *ContactListPanel {
 	c := &ContactListPanel{
 		TextView: tview.NewTextView(),
 		siggo:    siggo,
 		parent:   parent,
+		order:    model.NewContactOrder(siggo.Config().Layout.ContactSort),
 	}
 	c.SetDynamicColors(true)
+	// no wrapping, so we know which rows each contact is on
+	c.SetWrap(false)
 	c.SetTitle("contacts")
 	c.SetTitleAlign(0)
 	c.SetBorder(true)
@@ -2,25 +2,63 @@ package widgets
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/derricw/siggo/model"
+	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
 )
 
 type ConversationPanel struct {
 	*tview.TextView
-	hideTitle       bool
+	siggo     *model.Siggo
+	theme     *model.Theme
+	renderer  *Renderer
+	conv      *model.Conversation
+	hideTitle bool
+	// highlighted is the message found by a search and selected the one under the cursor in select
+	// mode, if any
+	highlighted     model.MessageKey
+	selected        model.MessageKey
 	hidePhoneNumber bool
+	// messageRows is the row each message starts on and rows the number of rows of all of them
+	messageRows []int
+	rows        int
+	// renderedWidth is the width the conversation was wrapped to, and renderedAt when
+	renderedWidth int
+	renderedAt    time.Time
+	// search is the pattern from the last / or ? search, matchRows the row each match is on and
+	// currentMatch the one we jumped to (-1 for none yet)
+	search       *regexp.Regexp
+	backward     bool
+	matchRows    []int
+	currentMatch int
+	// images are the conversations thumbnails are turned on or off in, the rest follow the config.
+	// placements are where thumbnails go, in rows of the whole conversation, visible the ones on
+	// screen when the panel was last drawn, and shown the ones drawn there.
+	images     map[*model.Conversation]bool
+	placements []imagePlacement
+	visible    []imagePlacement
+	drawn      bool
+	shown      []imagePlacement
 }
 
 func (p *ConversationPanel) Update(conv *model.Conversation) {
+	if conv != p.conv {
+		// the search carries over, but we haven't jumped to any of the matches here yet
+		p.currentMatch = -1
+	}
+	p.conv = conv
 	p.Clear()
-	p.SetText(conv.String())
+	p.SetText(p.render(conv))
+	p.highlightAll()
 	if !p.hideTitle {
 		if !p.hidePhoneNumber {
-			p.SetTitle(fmt.Sprintf("%s <%s>", conv.Contact.String(), conv.Contact.Number))
+			p.SetTitle(fmt.Sprintf("%s <%s>", tview.Escape(conv.Contact.String()), conv.Contact.Number))
 		} else {
-			p.SetTitle(conv.Contact.String())
+			p.SetTitle(tview.Escape(conv.Contact.String()))
 		}
 	}
 	conv.HasNewMessage = false
@@ -30,11 +68,460 @@ func (p *ConversationPanel) Clear() {
 	p.SetText("")
 }
 
-func NewConversationPanel(siggo *model.Siggo) *ConversationPanel {
+// messageRegion is the region of the message at index i of the conversation
+func messageRegion(i int) string {
+	return fmt.Sprintf("msg-%d", i)
+}
+
+// matchRegion is the region of the nth search match
+func matchRegion(n int) string {
+	return fmt.Sprintf("match-%d", n)
+}
+
+// regionTag matches region tags, which don't take up any space
+var regionTag = regexp.MustCompile(`\["[a-zA-Z0-9_,;: \-\.]*"\]`)
+
+// render renders the conversation, putting each message and any search matches in regions
+func (p *ConversationPanel) render(conv *model.Conversation) string {
+	p.matchRows = p.matchRows[:0]
+	p.messageRows = p.messageRows[:0]
+	_, _, p.renderedWidth, _ = p.GetInnerRect()
+	p.renderedAt = time.Now()
+	p.placements = p.placements[:0]
+	p.renderer.Prepare(conv, p.renderedWidth, p.ShowsImages(conv))
+	var b strings.Builder
+	var prev *model.Message
+	rows := 0
+	for i, key := range conv.MessageOrder {
+		msg := conv.Messages[key]
+		if sep := p.renderer.Separator(prev, msg); sep != "" {
+			rows += p.countLines(sep)
+			b.WriteString(sep)
+		}
+		region := messageRegion(i)
+		text := fmt.Sprintf(`["%s"]%s[""]`, region, p.renderMessage(prev, msg, region, rows))
+		p.messageRows = append(p.messageRows, rows)
+		for _, img := range p.renderer.Images() {
+			img.row += rows
+			p.placements = append(p.placements, img)
+		}
+		rows += p.countLines(text)
+		b.WriteString(text)
+		prev = msg
+	}
+	p.rows = rows
+	return b.String()
+}
+
+// renderMessage renders a message that follows `prev` and starts at `row`, putting every search
+// match in its content in a region of its own. Regions don't nest, so the message's `region` picks
+// up again after each.
+func (p *ConversationPanel) renderMessage(prev, msg *model.Message, region string, row int) string {
+	if p.search == nil || msg.IsDeleted {
+		return p.renderer.Message(prev, msg, region, nil)
+	}
+	first := len(p.matchRows)
+	highlights := make([]Highlight, 0)
+	for _, loc := range p.search.FindAllStringIndex(msg.Content, -1) {
+		if loc[0] == loc[1] {
+			// nothing to show for empty matches
+			continue
+		}
+		n := len(p.matchRows)
+		p.matchRows = append(p.matchRows, row)
+		highlights = append(highlights, Highlight{
+			Start:   loc[0],
+			End:     loc[1],
+			Region:  matchRegion(n),
+			Current: n == p.currentMatch,
+		})
+	}
+	text := p.renderer.Message(prev, msg, region, highlights)
+	// now we know where the matches ended up
+	for n := first; n < len(p.matchRows); n++ {
+		i := strings.Index(text, fmt.Sprintf(`["%s"]`, matchRegion(n)))
+		p.matchRows[n] = row + p.countLines(text[:i]+"x") - 1
+	}
+	return text
+}
+
+// ToggleSpoilers reveals or hides the spoilers of the message with `key`
+func (p *ConversationPanel) ToggleSpoilers(key model.MessageKey) {
+	p.renderer.ToggleSpoilers(key)
+	if p.conv != nil {
+		p.Update(p.conv)
+	}
+}
+
+// ShowsImages returns true if thumbnails of images are shown in `conv`
+func (p *ConversationPanel) ShowsImages(conv *model.Conversation) bool {
+	if on, ok := p.images[conv]; ok {
+		return on
+	}
+	return p.siggo.Config().Messages.Images
+}
+
+// ToggleImages shows or hides thumbnails of images in the conversation
+func (p *ConversationPanel) ToggleImages() {
+	if p.conv == nil {
+		return
+	}
+	p.images[p.conv] = !p.ShowsImages(p.conv)
+	p.Update(p.conv)
+}
+
+// SetImageLoadedFunc sets a function to call when an image has been decoded in the background,
+// which is when its thumbnail can be shown
+func (p *ConversationPanel) SetImageLoadedFunc(f func()) {
+	p.renderer.thumbs.mu.Lock()
+	defer p.renderer.thumbs.mu.Unlock()
+	p.renderer.thumbs.loaded = f
+}
+
+// DrawImages draws the thumbnails that are on screen with kitty graphics or sixels, over the room
+// left for them. It is called once everything else is drawn, and only draws when they have moved.
+func (p *ConversationPanel) DrawImages(screen tcell.Screen) {
+	var visible []imagePlacement
+	if p.drawn {
+		visible = p.visible
+	}
+	p.drawn = false
+	evicted := p.renderer.thumbs.Evicted()
+	if p.renderer.protocol == model.ImagesBlocks || (len(evicted) == 0 && samePlacements(visible, p.shown)) {
+		return
+	}
+	shown := p.shown
+	p.shown = append([]imagePlacement(nil), visible...)
+	// what is under the images has to be on the screen first
+	screen.Show()
+	drawImages(screen, p.renderer.protocol, visible, shown, evicted, len(shown) > 0)
+}
+
+// samePlacements returns true if `a` and `b` put the same images in the same places
+func samePlacements(a, b []imagePlacement) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// visibleImages returns where the thumbnails that fit on screen are, in screen rows and columns
+func (p *ConversationPanel) visibleImages() []imagePlacement {
+	x, y, width, height := p.GetInnerRect()
+	top, _ := p.GetScrollOffset()
+	visible := p.visible[:0]
+	for _, img := range p.placements {
+		if img.row < top || img.row+img.rows > top+height || img.col+img.cols > width {
+			continue
+		}
+		img.row += y - top
+		img.col += x
+		visible = append(visible, img)
+	}
+	return visible
+}
+
+// indexOf returns the index of the message with `key` in the conversation, -1 if it isn't there
+func (p *ConversationPanel) indexOf(key model.MessageKey) int {
+	if p.conv == nil || key == (model.MessageKey{}) {
+		return -1
+	}
+	for i := len(p.conv.MessageOrder) - 1; i >= 0; i-- {
+		if p.conv.MessageOrder[i] == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// highlightAll highlights the found message, the selected message and every search match
+func (p *ConversationPanel) highlightAll() {
+	regions := make([]string, 0, len(p.matchRows)+2)
+	for _, key := range []model.MessageKey{p.highlighted, p.selected} {
+		if i := p.indexOf(key); i >= 0 {
+			regions = append(regions, messageRegion(i))
+		}
+	}
+	for n := range p.matchRows {
+		regions = append(regions, matchRegion(n))
+	}
+	p.Highlight(regions...)
+}
+
+// Find highlights everything in the conversation matching the regular expression `pattern` and
+// jumps to the first match below the top of the view, or above the bottom of it if `backward`.
+// The search ignores case unless the pattern has upper case letters in it. Returns the number of
+// matches.
+func (p *ConversationPanel) Find(pattern string, backward bool) (int, error) {
+	if strings.ToLower(pattern) == pattern {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return 0, err
+	}
+	p.search = re
+	p.backward = backward
+	p.currentMatch = -1
+	if p.conv == nil {
+		return 0, nil
+	}
+	p.Update(p.conv)
+	p.FindNext(false)
+	return len(p.matchRows), nil
+}
+
+// FindNext jumps to the next match in the direction of the last search, or the other way if
+// `reverse`, wrapping around at the ends. Returns the index of the match we are on and the number of
+// matches.
+func (p *ConversationPanel) FindNext(reverse bool) (int, int) {
+	total := len(p.matchRows)
+	if p.search == nil || p.conv == nil || total == 0 {
+		return -1, total
+	}
+	backward := p.backward != reverse
+	next := p.currentMatch
+	if next < 0 || next >= total {
+		// start from what we are looking at
+		top, _ := p.GetScrollOffset()
+		_, _, _, height := p.GetInnerRect()
+		if backward {
+			next = total - 1
+			for next >= 0 && p.matchRows[next] >= top+height {
+				next--
+			}
+			if next < 0 {
+				next = total - 1
+			}
+		} else {
+			next = 0
+			for next < total && p.matchRows[next] < top {
+				next++
+			}
+			if next == total {
+				next = 0
+			}
+		}
+	} else if backward {
+		next = (next - 1 + total) % total
+	} else {
+		next = (next + 1) % total
+	}
+	p.currentMatch = next
+	p.Update(p.conv)
+	p.scrollToRow(p.matchRows[next])
+	return next, total
+}
+
+// scrollToRow scrolls so that `row` is in the middle of the panel
+func (p *ConversationPanel) scrollToRow(row int) {
+	_, _, _, height := p.GetInnerRect()
+	row -= height / 2
+	if row < 0 {
+		row = 0
+	}
+	p.ScrollTo(row, 0)
+}
+
+// SearchPattern returns the pattern of the last search, "" if there isn't one
+func (p *ConversationPanel) SearchPattern() string {
+	if p.search == nil {
+		return ""
+	}
+	return strings.TrimPrefix(p.search.String(), "(?i)")
+}
+
+// ClearSearch stops highlighting search matches
+func (p *ConversationPanel) ClearSearch() {
+	if p.search == nil {
+		return
+	}
+	p.search = nil
+	p.currentMatch = -1
+	if p.conv != nil {
+		p.Update(p.conv)
+	}
+}
+
+// HighlightMessage shows the message with `key` highlighted and scrolls to it
+func (p *ConversationPanel) HighlightMessage(conv *model.Conversation, key model.MessageKey) {
+	p.highlighted = key
+	p.Update(conv)
+	if i := p.indexOf(key); i >= 0 {
+		p.scrollToRow(p.messageRows[i])
+	}
+}
+
+// ClearHighlight stops highlighting a message
+func (p *ConversationPanel) ClearHighlight() {
+	if p.highlighted == (model.MessageKey{}) {
+		return
+	}
+	p.highlighted = model.MessageKey{}
+	if p.conv != nil {
+		p.Update(p.conv)
+	}
+}
+
+// Select puts the cursor on the message with `key` and scrolls it into view
+func (p *ConversationPanel) Select(key model.MessageKey) {
+	p.selected = key
+	if p.conv == nil {
+		return
+	}
+	p.Update(p.conv)
+	i := p.indexOf(key)
+	if i < 0 {
+		return
+	}
+	start, end := p.messageRows[i], p.rows
+	if i+1 < len(p.messageRows) {
+		end = p.messageRows[i+1]
+	}
+	top, _ := p.GetScrollOffset()
+	_, _, _, height := p.GetInnerRect()
+	if start < top || end-start > height {
+		p.ScrollTo(start, 0)
+	} else if end > top+height {
+		p.ScrollTo(end-height, 0)
+	}
+}
+
+// SelectFirst puts the cursor on the oldest message we have loaded
+func (p *ConversationPanel) SelectFirst() {
+	if p.conv == nil || len(p.conv.MessageOrder) == 0 {
+		return
+	}
+	p.Select(p.conv.MessageOrder[0])
+}
+
+// SelectLast puts the cursor on the newest message
+func (p *ConversationPanel) SelectLast() {
+	if p.conv == nil || len(p.conv.MessageOrder) == 0 {
+		return
+	}
+	p.Select(p.conv.MessageOrder[len(p.conv.MessageOrder)-1])
+}
+
+// MoveSelection moves the cursor `n` messages down, or up if `n` is negative, loading older
+// messages from disk when it goes past the top
+func (p *ConversationPanel) MoveSelection(n int) error {
+	if p.conv == nil || len(p.conv.MessageOrder) == 0 {
+		return nil
+	}
+	i := p.indexOf(p.selected)
+	if i < 0 {
+		i = len(p.conv.MessageOrder) - 1
+	}
+	i += n
+	if i < 0 && !p.conv.LoadedAll() {
+		loaded, err := p.siggo.LoadOlder(p.conv)
+		if err != nil {
+			return err
+		}
+		i += loaded
+	}
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(p.conv.MessageOrder) {
+		i = len(p.conv.MessageOrder) - 1
+	}
+	p.Select(p.conv.MessageOrder[i])
+	return nil
+}
+
+// Selected returns the message under the cursor, nil if there isn't one
+func (p *ConversationPanel) Selected() *model.Message {
+	if p.conv == nil || p.selected == (model.MessageKey{}) {
+		return nil
+	}
+	return p.conv.Messages[p.selected]
+}
+
+// ClearSelection takes the cursor away
+func (p *ConversationPanel) ClearSelection() {
+	if p.selected == (model.MessageKey{}) {
+		return
+	}
+	p.selected = model.MessageKey{}
+	if p.conv != nil {
+		p.Update(p.conv)
+	}
+}
+
+// LoadOlder pages in older messages from disk if we are scrolled to the top of the conversation.
+// The view stays where it was, so the older messages appear above it. Returns the number of
+// messages loaded.
+func (p *ConversationPanel) LoadOlder() (int, error) {
+	if p.conv == nil || p.conv.LoadedAll() {
+		return 0, nil
+	}
+	if row, _ := p.GetScrollOffset(); row > 0 {
+		return 0, nil
+	}
+	n, err := p.siggo.LoadOlder(p.conv)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	p.Update(p.conv)
+	row := p.rows
+	if n < len(p.messageRows) {
+		row = p.messageRows[n]
+	}
+	p.ScrollTo(row, 0)
+	return n, nil
+}
+
+// countLines returns the number of rows `text` takes up once it is wrapped to fit the panel
+func (p *ConversationPanel) countLines(text string) int {
+	_, _, width, _ := p.GetInnerRect()
+	rows := 0
+	text = regionTag.ReplaceAllString(text, "")
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		w := tview.TaggedStringWidth(line)
+		if width <= 0 || w <= width {
+			rows++
+		} else {
+			rows += (w + width - 1) / width
+		}
+	}
+	return rows
+}
+
+// Draw draws the conversation, wrapping it again first if the panel changed width, or if it shows
+// relative times and a minute has gone by
+func (p *ConversationPanel) Draw(screen tcell.Screen) {
+	if p.conv != nil {
+		_, _, width, _ := p.GetInnerRect()
+		stale := p.siggo.Config().Messages.TimestampFormat == model.RelativeTime &&
+			time.Since(p.renderedAt) > time.Minute
+		if width != p.renderedWidth || stale {
+			p.SetText(p.render(p.conv))
+			p.highlightAll()
+		}
+	}
+	p.TextView.Draw(screen)
+	p.visible = p.visibleImages()
+	p.drawn = true
+}
+
+func NewConversationPanel(siggo *model.Siggo, theme *model.Theme) *ConversationPanel {
 	c := &ConversationPanel{
-		TextView: tview.NewTextView(),
+		TextView:     tview.NewTextView(),
+		siggo:        siggo,
+		theme:        theme,
+		renderer:     NewRenderer(theme, siggo.Config().Messages),
+		currentMatch: -1,
+		images:       make(map[*model.Conversation]bool),
 	}
 	c.SetDynamicColors(true)
+	c.SetRegions(true)
 	c.SetTitle("<name of contact>")
 	c.SetTitleAlign(0)
 	c.SetBorder(true)
@@ -0,0 +1,43 @@
+package widgets
+
+import (
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewFindInput is a command input that searches the current conversation for a regular expression,
+// towards newer messages or, if `backward`, older ones. An empty pattern repeats the last search.
+func NewFindInput(parent *ChatWindow, backward bool) *CommandInput {
+	ci := &CommandInput{
+		InputField: tview.NewInputField(),
+		parent:     parent,
+	}
+	if backward {
+		ci.SetLabel("?")
+	} else {
+		ci.SetLabel("/")
+	}
+	ci.SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor)
+	ci.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		log.Debugf("Key Event <FIND>: %v mods: %v rune: %v", event.Key(), event.Modifiers(), event.Rune())
+		switch event.Key() {
+		case tcell.KeyESC:
+			ci.parent.HideCommandInput()
+			return nil
+		case tcell.KeyEnter:
+			pattern := ci.GetText()
+			ci.parent.HideCommandInput()
+			if pattern == "" {
+				pattern = ci.parent.conversationPanel.SearchPattern()
+			}
+			if pattern == "" {
+				return nil
+			}
+			ci.parent.Find(pattern, backward)
+			return nil
+		}
+		return event
+	})
+	return ci
+}
@@ -0,0 +1,421 @@
+package widgets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	_ "image/gif" // decode gif attachments
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/derricw/siggo/model"
+	"github.com/gdamore/tcell"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// cellWidth and cellHeight are about how many pixels a terminal cell is, for drawing images
+	// with kitty graphics or sixels
+	cellWidth  = 10
+	cellHeight = 20
+	// maxImageSize is the largest image we keep decoded, in pixels on each side
+	maxImageSize = 640
+	// maxImagePixels and maxImageBytes are the largest image we decode at all, so that an
+	// attachment can't make us decode something huge
+	maxImagePixels = 50000000
+	maxImageBytes  = 50 << 20
+	// maxThumbnails is how many decoded images are cached
+	maxThumbnails = 64
+	// kittyChunk is how much of an image is sent to kitty at a time
+	kittyChunk = 4096
+)
+
+// imageTypes are the attachments we can show thumbnails of
+var imageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// imageProtocol returns how to draw images, working out what the terminal can do if `protocol` is
+// ImagesAuto
+func imageProtocol(protocol string) string {
+	if protocol != model.ImagesAuto {
+		return protocol
+	}
+	term := os.Getenv("TERM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty":
+		return model.ImagesKitty
+	case strings.Contains(term, "sixel") || term == "foot" || term == "mlterm":
+		return model.ImagesSixel
+	}
+	return model.ImagesBlocks
+}
+
+// thumbnail is a decoded image attachment, and what we have drawn it as so far
+type thumbnail struct {
+	img image.Image
+	// id is the id kitty knows it by, once it has been sent there
+	id     uint32
+	sent   bool
+	blocks map[[2]int][]string
+	sixels map[[2]int][]byte
+}
+
+// size returns how many columns and rows the thumbnail takes up when it is `rows` high, but no
+// wider than `maxCols`
+func (t *thumbnail) size(rows, maxCols int) (int, int) {
+	b := t.img.Bounds()
+	// cells are about twice as high as they are wide
+	cols := (b.Dx()*rows*2 + b.Dy() - 1) / b.Dy()
+	if cols > maxCols {
+		rows = rows * maxCols / cols
+		cols = maxCols
+	}
+	if cols < 1 || rows < 1 {
+		return 0, 0
+	}
+	return cols, rows
+}
+
+// Blocks returns the thumbnail drawn with half-block characters, `cols` wide and `rows` high, a
+// line of markup for each row. The top half of each cell is one pixel, the bottom half another.
+func (t *thumbnail) Blocks(cols, rows int) []string {
+	if lines, ok := t.blocks[[2]int{cols, rows}]; ok {
+		return lines
+	}
+	img := scale(t.img, cols, rows*2)
+	lines := make([]string, rows)
+	for y := 0; y < rows; y++ {
+		var b strings.Builder
+		// nothing of the message's style carries over
+		b.WriteString("[::-]")
+		last := ""
+		for x := 0; x < cols; x++ {
+			tag := fmt.Sprintf("[%s:%s]", hexColor(img.At(x, 2*y)), hexColor(img.At(x, 2*y+1)))
+			if tag != last {
+				b.WriteString(tag)
+				last = tag
+			}
+			b.WriteString("▀")
+		}
+		b.WriteString("[-:-]")
+		lines[y] = b.String()
+	}
+	t.blocks[[2]int{cols, rows}] = lines
+	return lines
+}
+
+// Sixels returns the thumbnail as sixels, `cols` wide and `rows` high
+func (t *thumbnail) Sixels(cols, rows int) []byte {
+	if data, ok := t.sixels[[2]int{cols, rows}]; ok {
+		return data
+	}
+	data := encodeSixels(scale(t.img, cols*cellWidth, rows*cellHeight))
+	t.sixels[[2]int{cols, rows}] = data
+	return data
+}
+
+// hexColor returns a color as a tview color, like #ffa500
+func hexColor(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
+
+// scale scales `img` to `width` by `height` pixels, averaging the pixels that go into each one
+func scale(img image.Image, width, height int) *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	b := img.Bounds()
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/height, b.Min.Y+(y+1)*b.Dy()/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/width, b.Min.X+(x+1)*b.Dx()/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+cr, g+cg, bl+cb, a+ca, n+1
+				}
+			}
+			out.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return out
+}
+
+// encodeSixels encodes an image as sixels, in the web safe palette
+func encodeSixels(img image.Image) []byte {
+	b := img.Bounds()
+	pal := image.NewPaletted(b, palette.WebSafe)
+	draw.FloydSteinberg.Draw(pal, b, img, b.Min)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "\x1bPq\"1;1;%d;%d", b.Dx(), b.Dy())
+	for i, c := range palette.WebSafe {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+	for y := 0; y < b.Dy(); y += 6 {
+		used := make(map[uint8]bool)
+		for x := 0; x < b.Dx(); x++ {
+			for dy := 0; dy < 6 && y+dy < b.Dy(); dy++ {
+				used[pal.ColorIndexAt(b.Min.X+x, b.Min.Y+y+dy)] = true
+			}
+		}
+		for i := range used {
+			fmt.Fprintf(&out, "#%d", i)
+			var last byte
+			run := 0
+			flush := func() {
+				if run > 3 {
+					fmt.Fprintf(&out, "!%d%c", run, last)
+				} else {
+					out.Write(bytes.Repeat([]byte{last}, run))
+				}
+			}
+			for x := 0; x < b.Dx(); x++ {
+				var bits byte
+				for dy := 0; dy < 6 && y+dy < b.Dy(); dy++ {
+					if pal.ColorIndexAt(b.Min.X+x, b.Min.Y+y+dy) == i {
+						bits |= 1 << uint(dy)
+					}
+				}
+				if c := 63 + bits; c == last {
+					run++
+				} else {
+					flush()
+					last, run = c, 1
+				}
+			}
+			flush()
+			out.WriteByte('$')
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\")
+	return out.Bytes()
+}
+
+// Thumbnails decodes image attachments in the background and keeps the most recent ones
+type Thumbnails struct {
+	mu      sync.Mutex
+	cache   map[string]*thumbnail
+	order   []string
+	loading map[string]bool
+	nextID  uint32
+	// evicted are the thumbnails dropped from the cache, for kitty to forget
+	evicted []*thumbnail
+	// loaded is called from the background when an image has been decoded
+	loaded func()
+}
+
+// NewThumbnails returns an empty thumbnail cache
+func NewThumbnails() *Thumbnails {
+	return &Thumbnails{
+		cache:   make(map[string]*thumbnail),
+		loading: make(map[string]bool),
+	}
+}
+
+// Get returns the thumbnail of the image at `path`, or nil if it isn't decoded yet (which starts
+// decoding it) or can't be
+func (t *Thumbnails) Get(path string) *thumbnail {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if thumb, ok := t.cache[path]; ok {
+		return thumb
+	}
+	if !t.loading[path] {
+		t.loading[path] = true
+		go t.load(path)
+	}
+	return nil
+}
+
+// Evicted returns the thumbnails dropped from the cache since it was last called
+func (t *Thumbnails) Evicted() []*thumbnail {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	evicted := t.evicted
+	t.evicted = nil
+	return evicted
+}
+
+// load decodes the image at `path`. Images that can't be are cached as nil, so we don't keep trying.
+func (t *Thumbnails) load(path string) {
+	thumb, err := decodeThumbnail(path)
+	if err != nil {
+		log.Debugf("can't show thumbnail of %s: %v", path, err)
+	}
+	t.mu.Lock()
+	delete(t.loading, path)
+	if len(t.order) >= maxThumbnails {
+		if old := t.cache[t.order[0]]; old != nil {
+			t.evicted = append(t.evicted, old)
+		}
+		delete(t.cache, t.order[0])
+		t.order = t.order[1:]
+	}
+	if thumb != nil {
+		t.nextID++
+		thumb.id = t.nextID
+	}
+	t.cache[path] = thumb
+	t.order = append(t.order, path)
+	loaded := t.loaded
+	t.mu.Unlock()
+	if thumb != nil && loaded != nil {
+		loaded()
+	}
+}
+
+// decodeThumbnail decodes the image at `path`, shrinking it to no more than maxImageSize. Images
+// bigger than maxImageBytes or maxImagePixels aren't decoded.
+func decodeThumbnail(path string) (*thumbnail, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxImageBytes {
+		return nil, fmt.Errorf("image too big: %d bytes", info.Size())
+	}
+	// the header says how big it is, before we decode any of it
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, fmt.Errorf("image too big: %dx%d", config.Width, config.Height)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+	if b.Dx() > maxImageSize || b.Dy() > maxImageSize {
+		w, h := maxImageSize, b.Dy()*maxImageSize/b.Dx()
+		if b.Dy() > b.Dx() {
+			w, h = b.Dx()*maxImageSize/b.Dy(), maxImageSize
+		}
+		img = scale(img, max(w, 1), max(h, 1))
+	}
+	return &thumbnail{
+		img:    img,
+		blocks: make(map[[2]int][]string),
+		sixels: make(map[[2]int][]byte),
+	}, nil
+}
+
+// imagePlacement is where a thumbnail goes in the conversation panel, for drawing it with kitty
+// graphics or sixels over the empty space left for it
+type imagePlacement struct {
+	thumb      *thumbnail
+	row, col   int
+	cols, rows int
+}
+
+// tty is where images are drawn, the terminal tcell draws to
+var tty io.Writer
+
+// terminal returns the terminal to draw images to
+func terminal() io.Writer {
+	if tty == nil {
+		if f, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+			tty = f
+		} else {
+			tty = os.Stdout
+		}
+	}
+	return tty
+}
+
+// drawImages draws thumbnails with kitty graphics or sixels at `placements`, which are on the screen
+// already, in place of the ones at `shown`. Kitty forgets the `evicted` thumbnails.
+func drawImages(screen tcell.Screen, protocol string, placements, shown []imagePlacement,
+	evicted []*thumbnail, moved bool) {
+	var out bytes.Buffer
+	// save the cursor, and put it back once we are done
+	out.WriteString("\x1b7")
+	switch protocol {
+	case model.ImagesKitty:
+		for _, t := range evicted {
+			if t.sent {
+				fmt.Fprintf(&out, "\x1b_Ga=d,d=I,i=%d,q=2\x1b\\", t.id)
+				t.sent = false
+			}
+		}
+		// take down our images, but keep them for placing again, and put up what is showing now
+		for _, p := range shown {
+			fmt.Fprintf(&out, "\x1b_Ga=d,d=i,i=%d,q=2\x1b\\", p.thumb.id)
+		}
+		for _, p := range placements {
+			if !p.thumb.sent {
+				sendKitty(&out, p.thumb)
+			}
+			fmt.Fprintf(&out, "\x1b[%d;%dH\x1b_Ga=p,i=%d,c=%d,r=%d,C=1,q=2\x1b\\", p.row+1, p.col+1, p.thumb.id,
+				p.cols, p.rows)
+		}
+	case model.ImagesSixel:
+		if moved {
+			// sixels stay where they were drawn until something is drawn over them
+			screen.Sync()
+		}
+		for _, p := range placements {
+			fmt.Fprintf(&out, "\x1b[%d;%dH", p.row+1, p.col+1)
+			out.Write(p.thumb.Sixels(p.cols, p.rows))
+		}
+	}
+	out.WriteString("\x1b8")
+	if _, err := out.WriteTo(terminal()); err != nil {
+		log.Debugf("failed to draw images: %v", err)
+	}
+}
+
+// sendKitty sends a thumbnail to kitty, as a PNG, so that it can be placed
+func sendKitty(out *bytes.Buffer, t *thumbnail) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, t.img); err != nil {
+		log.Debugf("failed to encode image: %v", err)
+		return
+	}
+	data := base64.StdEncoding.EncodeToString(img.Bytes())
+	for i := 0; i < len(data); i += kittyChunk {
+		end := min(i+kittyChunk, len(data))
+		more := 0
+		if end < len(data) {
+			more = 1
+		}
+		if i == 0 {
+			fmt.Fprintf(out, "\x1b_Ga=t,f=100,i=%d,q=2,m=%d;%s\x1b\\", t.id, more, data[i:end])
+		} else {
+			fmt.Fprintf(out, "\x1b_Gm=%d;%s\x1b\\", more, data[i:end])
+		}
+	}
+	t.sent = true
+}
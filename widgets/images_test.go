@@ -0,0 +1,169 @@
+package widgets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/derricw/siggo/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScale(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.White)
+	img.Set(0, 1, color.White)
+	img.Set(1, 0, color.Black)
+	img.Set(1, 1, color.Black)
+
+	// shrinking averages
+	out := scale(img, 1, 1)
+	assert.Equal(t, image.Rect(0, 0, 1, 1), out.Bounds())
+	assert.Equal(t, color.RGBA{127, 127, 127, 255}, out.RGBAAt(0, 0))
+
+	// growing repeats
+	out = scale(img, 4, 2)
+	assert.Equal(t, image.Rect(0, 0, 4, 2), out.Bounds())
+	for y := 0; y < 2; y++ {
+		assert.Equal(t, color.RGBA{255, 255, 255, 255}, out.RGBAAt(1, y))
+		assert.Equal(t, color.RGBA{0, 0, 0, 255}, out.RGBAAt(2, y))
+	}
+
+	// images don't have to start at 0, 0
+	out = scale(img.SubImage(image.Rect(1, 0, 2, 2)), 1, 1)
+	assert.Equal(t, color.RGBA{0, 0, 0, 255}, out.RGBAAt(0, 0))
+}
+
+func TestThumbnailSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		rows, maxCols int
+		cols, outRows int
+	}{
+		{name: "wide", width: 100, height: 50, rows: 5, maxCols: 40, cols: 20, outRows: 5},
+		{name: "too wide", width: 100, height: 50, rows: 5, maxCols: 10, cols: 10, outRows: 2},
+		{name: "tall", width: 10, height: 100, rows: 5, maxCols: 40, cols: 1, outRows: 5},
+		{name: "square", width: 64, height: 64, rows: 4, maxCols: 40, cols: 8, outRows: 4},
+		{name: "no room", width: 100, height: 50, rows: 5, maxCols: 0},
+	}
+	for _, test := range tests {
+		thumb := &thumbnail{img: image.NewRGBA(image.Rect(0, 0, test.width, test.height))}
+		cols, rows := thumb.size(test.rows, test.maxCols)
+		assert.Equal(t, test.cols, cols, test.name)
+		assert.Equal(t, test.outRows, rows, test.name)
+	}
+}
+
+func TestEncodeSixels(t *testing.T) {
+	black := image.NewRGBA(image.Rect(0, 0, 5, 1))
+	for x := 0; x < 5; x++ {
+		black.Set(x, 0, color.Black)
+	}
+	out := string(encodeSixels(black))
+	assert.True(t, strings.HasPrefix(out, "\x1bPq\"1;1;5;1#0;2;0;0;0#1;2;0;0;20"), out[:40])
+	// black is the first color of the palette, and the top pixel of a sixel is its lowest bit.
+	// runs longer than 3 are repeated with !
+	assert.True(t, strings.HasSuffix(out, "#0!5@$-\x1b\\"))
+
+	// a white pixel over a black one, a band for each color
+	img := image.NewRGBA(image.Rect(0, 0, 1, 2))
+	img.Set(0, 0, color.White)
+	img.Set(0, 1, color.Black)
+	out = string(encodeSixels(img))
+	assert.Contains(t, out, "#215@$")
+	assert.Contains(t, out, "#0A$")
+	assert.True(t, strings.HasSuffix(out, "$-\x1b\\"))
+
+	// every 6 rows is another line of sixels
+	out = string(encodeSixels(image.NewRGBA(image.Rect(0, 0, 1, 7))))
+	assert.Equal(t, 2, strings.Count(out, "-"))
+}
+
+// pngHeader returns the start of a PNG that says it is `width` by `height`, which is all it takes to
+// decode its size
+func pngHeader(width, height uint32) []byte {
+	var b bytes.Buffer
+	b.WriteString("\x89PNG\r\n\x1a\n")
+	chunk := make([]byte, 0, 17)
+	chunk = append(chunk, "IHDR"...)
+	chunk = binary.BigEndian.AppendUint32(chunk, width)
+	chunk = binary.BigEndian.AppendUint32(chunk, height)
+	chunk = append(chunk, 8, 2, 0, 0, 0)
+	binary.Write(&b, binary.BigEndian, uint32(len(chunk)-4))
+	b.Write(chunk)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return b.Bytes()
+}
+
+func TestDecodeThumbnail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "siggo-images-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewRGBA(image.Rect(0, 0, 1280, 10))); err != nil {
+		t.Fatal(err)
+	}
+	small := filepath.Join(dir, "small.png")
+	assert.NoError(t, ioutil.WriteFile(small, b.Bytes(), 0644))
+	thumb, err := decodeThumbnail(small)
+	if assert.NoError(t, err) {
+		assert.Equal(t, image.Rect(0, 0, maxImageSize, 5), thumb.img.Bounds())
+	}
+
+	// too many pixels is turned down before anything is decoded
+	bomb := filepath.Join(dir, "bomb.png")
+	assert.NoError(t, ioutil.WriteFile(bomb, pngHeader(100000, 100000), 0644))
+	_, err = decodeThumbnail(bomb)
+	assert.EqualError(t, err, "image too big: 100000x100000")
+
+	big := filepath.Join(dir, "big.png")
+	assert.NoError(t, ioutil.WriteFile(big, b.Bytes(), 0644))
+	assert.NoError(t, os.Truncate(big, maxImageBytes+1))
+	_, err = decodeThumbnail(big)
+	assert.EqualError(t, err, "image too big: 52428801 bytes")
+
+	empty := filepath.Join(dir, "empty.png")
+	assert.NoError(t, ioutil.WriteFile(empty, nil, 0644))
+	_, err = decodeThumbnail(empty)
+	assert.Error(t, err)
+}
+
+func TestDrawKitty(t *testing.T) {
+	var out bytes.Buffer
+	defer func(w io.Writer) { tty = w }(tty)
+	tty = &out
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	shown := &thumbnail{img: img, id: 1, sent: true}
+	placed := &thumbnail{img: img, id: 2}
+	evicted := &thumbnail{img: img, id: 3, sent: true}
+	drawImages(nil, model.ImagesKitty,
+		[]imagePlacement{{thumb: placed, row: 4, col: 2, cols: 3, rows: 2}},
+		[]imagePlacement{{thumb: shown}},
+		[]*thumbnail{evicted, {img: img, id: 4}}, true)
+
+	drawn := out.String()
+	// only our own images are taken down, and the evicted ones freed
+	assert.NotContains(t, drawn, "d=a")
+	assert.Contains(t, drawn, "\x1b_Ga=d,d=I,i=3,q=2\x1b\\")
+	assert.NotContains(t, drawn, "i=4")
+	assert.False(t, evicted.sent)
+	assert.Contains(t, drawn, "\x1b_Ga=d,d=i,i=1,q=2\x1b\\")
+	// new images are sent, then placed
+	assert.Contains(t, drawn, "\x1b_Ga=t,f=100,i=2,q=2,m=0;")
+	assert.Contains(t, drawn, "\x1b[5;3H\x1b_Ga=p,i=2,c=3,r=2,C=1,q=2\x1b\\")
+	assert.True(t, placed.sent)
+}
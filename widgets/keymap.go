@@ -0,0 +1,468 @@
+package widgets
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell"
+	log "github.com/sirupsen/logrus"
+)
+
+// The modes that have keymaps
+const (
+	KeymapNormal = "normal"
+	KeymapYank   = "yank"
+	KeymapSelect = "select"
+	KeymapOpen   = "open"
+	KeymapLink   = "link"
+)
+
+// KeymapModes are the modes that have their own keymap, in the order we show them
+var KeymapModes = []string{KeymapNormal, KeymapYank, KeymapSelect, KeymapOpen, KeymapLink}
+
+// Actions is every action that can be bound to a key, with a description, by mode
+var Actions = map[string]map[string]string{
+	KeymapNormal: {
+		"scroll-down":      "Scroll the conversation down",
+		"scroll-up":        "Scroll the conversation up, loading older messages at the top",
+		"page-down":        "Scroll the conversation down a page",
+		"page-up":          "Scroll the conversation up a page, loading older messages at the top",
+		"scroll-to-start":  "Scroll to the start of the conversation",
+		"scroll-to-end":    "Scroll to the end of the conversation",
+		"next-contact":     "Go to the next conversation",
+		"previous-contact": "Go to the previous conversation",
+		"next-unread":      "Go to the next conversation with unread messages",
+		"insert-mode":      "Start typing a message",
+		"compose":          "Write a message in $EDITOR",
+		"yank-mode":        "Yank Mode",
+		"open-mode":        "Open Mode",
+		"link-mode":        "Link Mode",
+		"select-mode":      "Select Mode",
+		"attach":           "Attach a file",
+		"fancy-attach":     "Attach a file with fzf",
+		"retry-failed":     "Retry sending failed messages",
+		"find-forward":     "Search the conversation towards newer messages",
+		"find-backward":    "Search the conversation towards older messages",
+		"find-next":        "Go to the next search match",
+		"find-previous":    "Go to the previous search match",
+		"search-messages":  "Search messages in all conversations",
+		"search-contacts":  "Search contacts and groups",
+		"command-line":     "Enter a command, like :goto or :set",
+		"toggle-contacts":  "Show or hide the contact list",
+		"toggle-compact":   "Turn compact mode on or off",
+		"toggle-images":    "Show or hide image thumbnails in this conversation",
+		"grow-contacts":    "Make the contact list wider",
+		"shrink-contacts":  "Make the contact list narrower",
+		"reset-layout":     "Go back to the configured layout",
+		"clear":            "Clear the status bar and any highlights",
+		"quit":             "Quit",
+	},
+	KeymapYank: {
+		"yank-last-message": "Yank the last message to the clipboard",
+		"yank-last-link":    "Yank the last link to the clipboard",
+		"normal-mode":       "Back to Normal Mode",
+		"quit":              "Quit",
+	},
+	KeymapSelect: {
+		"select-next":     "Select the next message",
+		"select-previous": "Select the previous message, loading older messages at the top",
+		"select-first":    "Select the first message",
+		"select-last":     "Select the last message",
+		"yank":            "Yank the selected message to the clipboard",
+		"open":            "Open the attachment of the selected message",
+		"link":            "Open the link in the selected message",
+		"reply":           "Reply to the selected message",
+		"react":           "React to the selected message",
+		"delete":          "Delete the selected message",
+		"details":         "Show the details of the selected message",
+		"spoilers":        "Reveal or hide the spoilers of the selected message",
+		"normal-mode":     "Back to Normal Mode",
+		"quit":            "Quit",
+	},
+	KeymapOpen: {
+		"next":          "Select the next attachment",
+		"previous":      "Select the previous attachment",
+		"page-down":     "Scroll down a page",
+		"page-up":       "Scroll up a page",
+		"first":         "Select the first attachment",
+		"last":          "Select the last attachment",
+		"open-selected": "Open the selected attachment",
+		"open-last":     "Open the last attachment",
+		"close":         "Back to Normal Mode",
+	},
+	KeymapLink: {
+		"next":          "Select the next link",
+		"previous":      "Select the previous link",
+		"page-down":     "Scroll down a page",
+		"page-up":       "Scroll up a page",
+		"first":         "Select the first link",
+		"last":          "Select the last link",
+		"open-selected": "Open the selected link in the browser",
+		"open-last":     "Open the last link in the browser",
+		"yank-selected": "Yank the selected link to the clipboard",
+		"close":         "Back to Normal Mode",
+	},
+}
+
+// DefaultKeymap is the keymap siggo ships with. Keys set in the config are merged into it.
+var DefaultKeymap = map[string]map[string]string{
+	KeymapNormal: {
+		"j":         "scroll-down",
+		"<Down>":    "scroll-down",
+		"k":         "scroll-up",
+		"<Up>":      "scroll-up",
+		"<PgDn>":    "page-down",
+		"<PgUp>":    "page-up",
+		"<Home>":    "scroll-to-start",
+		"<End>":     "scroll-to-end",
+		"J":         "next-contact",
+		"K":         "previous-contact",
+		"<C-n>":     "next-unread",
+		"i":         "insert-mode",
+		"I":         "compose",
+		"y":         "yank-mode",
+		"o":         "open-mode",
+		"l":         "link-mode",
+		"v":         "select-mode",
+		"a":         "attach",
+		"A":         "fancy-attach",
+		"r":         "retry-failed",
+		"/":         "find-forward",
+		"?":         "find-backward",
+		"n":         "find-next",
+		"N":         "find-previous",
+		"<C-f>":     "search-messages",
+		"<C-t>":     "search-contacts",
+		":":         "command-line",
+		"<C-w>o":    "toggle-contacts",
+		"<C-w>c":    "toggle-compact",
+		"<C-w>i":    "toggle-images",
+		"<C-w>>":    "grow-contacts",
+		"<C-w><lt>": "shrink-contacts",
+		"<C-w>=":    "reset-layout",
+		"<Esc>":     "clear",
+		"<C-q>":     "quit",
+	},
+	KeymapYank: {
+		"y":     "yank-last-message",
+		"l":     "yank-last-link",
+		"<Esc>": "normal-mode",
+		"<C-q>": "quit",
+	},
+	KeymapSelect: {
+		"j":       "select-next",
+		"<Down>":  "select-next",
+		"k":       "select-previous",
+		"<Up>":    "select-previous",
+		"g":       "select-first",
+		"G":       "select-last",
+		"y":       "yank",
+		"o":       "open",
+		"l":       "link",
+		"r":       "reply",
+		"e":       "react",
+		"d":       "delete",
+		"<Enter>": "details",
+		"s":       "spoilers",
+		"<Esc>":   "normal-mode",
+		"<C-q>":   "quit",
+	},
+	KeymapOpen: {
+		"j":       "next",
+		"k":       "previous",
+		"<PgDn>":  "page-down",
+		"<PgUp>":  "page-up",
+		"<Home>":  "first",
+		"<End>":   "last",
+		"<Enter>": "open-selected",
+		"o":       "open-last",
+		"<Esc>":   "close",
+	},
+	KeymapLink: {
+		"j":       "next",
+		"k":       "previous",
+		"<PgDn>":  "page-down",
+		"<PgUp>":  "page-up",
+		"<Home>":  "first",
+		"<End>":   "last",
+		"<Enter>": "open-selected",
+		"l":       "open-last",
+		"y":       "yank-selected",
+		"<Esc>":   "close",
+	},
+}
+
+// namedKeys are the keys written as <Name>, by lower case name
+var namedKeys = map[string]tcell.Key{
+	"enter":     tcell.KeyEnter,
+	"cr":        tcell.KeyEnter,
+	"esc":       tcell.KeyESC,
+	"tab":       tcell.KeyTab,
+	"s-tab":     tcell.KeyBacktab,
+	"bs":        tcell.KeyBackspace2,
+	"del":       tcell.KeyDelete,
+	"up":        tcell.KeyUp,
+	"down":      tcell.KeyDown,
+	"left":      tcell.KeyLeft,
+	"right":     tcell.KeyRight,
+	"pgup":      tcell.KeyPgUp,
+	"pgdn":      tcell.KeyPgDn,
+	"home":      tcell.KeyHome,
+	"end":       tcell.KeyEnd,
+	"insert":    tcell.KeyInsert,
+	"backspace": tcell.KeyBackspace2,
+}
+
+// keyNames are the names we write keys with, the reverse of namedKeys
+var keyNames = map[tcell.Key]string{
+	tcell.KeyEnter:      "<Enter>",
+	tcell.KeyESC:        "<Esc>",
+	tcell.KeyTab:        "<Tab>",
+	tcell.KeyBacktab:    "<S-Tab>",
+	tcell.KeyBackspace:  "<BS>",
+	tcell.KeyBackspace2: "<BS>",
+	tcell.KeyDelete:     "<Del>",
+	tcell.KeyUp:         "<Up>",
+	tcell.KeyDown:       "<Down>",
+	tcell.KeyLeft:       "<Left>",
+	tcell.KeyRight:      "<Right>",
+	tcell.KeyPgUp:       "<PgUp>",
+	tcell.KeyPgDn:       "<PgDn>",
+	tcell.KeyHome:       "<Home>",
+	tcell.KeyEnd:        "<End>",
+	tcell.KeyInsert:     "<Insert>",
+}
+
+// keyToken returns how we write a key event in a keymap, "" for keys that can't be bound
+func keyToken(key tcell.Key, r rune) string {
+	if key == tcell.KeyRune {
+		switch r {
+		case ' ':
+			return "<Space>"
+		case '<':
+			return "<lt>"
+		}
+		return string(r)
+	}
+	if name, ok := keyNames[key]; ok {
+		return name
+	}
+	if key >= tcell.KeyCtrlA && key <= tcell.KeyCtrlZ {
+		return fmt.Sprintf("<C-%c>", 'a'+rune(key-tcell.KeyCtrlA))
+	}
+	return ""
+}
+
+// parseKeys splits a key sequence like "gg", "<C-f>" or "<Esc>" into the tokens of each key
+func parseKeys(keys string) ([]string, error) {
+	tokens := make([]string, 0)
+	for len(keys) > 0 {
+		if keys[0] == '<' {
+			end := strings.IndexByte(keys, '>')
+			if end < 0 {
+				return nil, fmt.Errorf("missing > in %s", keys)
+			}
+			token, err := parseNamedKey(keys[1:end])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token)
+			keys = keys[end+1:]
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(keys)
+		tokens = append(tokens, keyToken(tcell.KeyRune, r))
+		keys = keys[size:]
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no keys")
+	}
+	return tokens, nil
+}
+
+// parseNamedKey parses what's inside a <...> key
+func parseNamedKey(name string) (string, error) {
+	lower := strings.ToLower(name)
+	switch lower {
+	case "space":
+		return keyToken(tcell.KeyRune, ' '), nil
+	case "lt":
+		return keyToken(tcell.KeyRune, '<'), nil
+	}
+	if key, ok := namedKeys[lower]; ok {
+		return keyToken(key, 0), nil
+	}
+	if strings.HasPrefix(lower, "c-") && len(lower) == 3 && lower[2] >= 'a' && lower[2] <= 'z' {
+		// some of these are the same as named keys, <C-i> is <Tab>
+		return keyToken(tcell.KeyCtrlA+tcell.Key(lower[2]-'a'), 0), nil
+	}
+	return "", fmt.Errorf("unknown key <%s>", name)
+}
+
+// Keymap is the key sequences bound to each action, by mode
+type Keymap struct {
+	bindings map[string]map[string]string
+}
+
+// NewKeymap merges `overrides` (from the config) into the default keymap. An empty action unbinds
+// a key. It is an error to bind an action that doesn't exist or a sequence that starts with
+// another one, like "g" and "gg", since the longer one could never be used.
+func NewKeymap(overrides map[string]map[string]string) (*Keymap, error) {
+	km := &Keymap{bindings: make(map[string]map[string]string)}
+	errs := make([]string, 0)
+	bind := func(mode, keys, action string) {
+		tokens, err := parseKeys(keys)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s: %v", mode, keys, err))
+			return
+		}
+		seq := strings.Join(tokens, "")
+		if action == "" {
+			delete(km.bindings[mode], seq)
+			return
+		}
+		if _, ok := Actions[mode][action]; !ok {
+			errs = append(errs, fmt.Sprintf("%s: %s: no such action: %s", mode, keys, action))
+			return
+		}
+		km.bindings[mode][seq] = action
+	}
+	for mode, keys := range DefaultKeymap {
+		km.bindings[mode] = make(map[string]string)
+		for k, action := range keys {
+			bind(mode, k, action)
+		}
+	}
+	// user bindings replace the defaults, but not each other
+	userSet := make(map[string]map[string]string)
+	for mode, keys := range overrides {
+		if _, ok := Actions[mode]; !ok {
+			errs = append(errs, fmt.Sprintf("no such keymap mode: %s", mode))
+			continue
+		}
+		userSet[mode] = make(map[string]string)
+		for _, k := range sortedKeys(keys) {
+			if tokens, err := parseKeys(k); err == nil {
+				seq := strings.Join(tokens, "")
+				if other, ok := userSet[mode][seq]; ok && keys[other] != keys[k] {
+					errs = append(errs, fmt.Sprintf("%s: %s and %s are the same keys, bound to %s and %s",
+						mode, other, k, keys[other], keys[k]))
+					continue
+				}
+				userSet[mode][seq] = k
+			}
+			bind(mode, k, keys[k])
+		}
+	}
+	for _, mode := range sortedKeys(km.bindings) {
+		seqs := sortedKeys(km.bindings[mode])
+		for _, a := range seqs {
+			for _, b := range seqs {
+				if a != b && strings.HasPrefix(b, a) {
+					errs = append(errs, fmt.Sprintf("%s: %s (%s) hides %s (%s)",
+						mode, a, km.bindings[mode][a], b, km.bindings[mode][b]))
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("bad keymap:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return km, nil
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m interface{}) []string {
+	keys := make([]string, 0)
+	switch m := m.(type) {
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Bindings returns the key sequences bound to each action in `mode`, sorted
+func (km *Keymap) Bindings(mode string) map[string][]string {
+	out := make(map[string][]string)
+	for _, seq := range sortedKeys(km.bindings[mode]) {
+		action := km.bindings[mode][seq]
+		out[action] = append(out[action], seq)
+	}
+	return out
+}
+
+// Keys returns the key sequences bound to `action` in `mode`, joined for showing to the user
+func (km *Keymap) Keys(mode, action string) string {
+	return strings.Join(km.Bindings(mode)[action], ", ")
+}
+
+// sequenceTimeout is how long a key that starts a sequence waits for the rest of it
+var sequenceTimeout = time.Second
+
+// Handler returns an input capture function that runs `actions` for the keys bound to them in
+// `mode`. Keys that start a sequence wait for the rest of it, up to sequenceTimeout, keys that
+// aren't bound pass through.
+func (km *Keymap) Handler(mode string, actions map[string]func()) func(*tcell.EventKey) *tcell.EventKey {
+	bindings := km.bindings[mode]
+	prefixes := make(map[string]bool)
+	for seq := range bindings {
+		tokens, _ := parseKeys(seq)
+		for i := 1; i < len(tokens); i++ {
+			prefixes[strings.Join(tokens[:i], "")] = true
+		}
+	}
+	pending := ""
+	var pendingSince time.Time
+	return func(event *tcell.EventKey) *tcell.EventKey {
+		log.Debugf("Key Event <%s>: %v mods: %v rune: %v", strings.ToUpper(mode), event.Key(),
+			event.Modifiers(), event.Rune())
+		if pending != "" && time.Since(pendingSince) > sequenceTimeout {
+			pending = ""
+		}
+		token := keyToken(event.Key(), event.Rune())
+		if token == "" {
+			pending = ""
+			return event
+		}
+		seq := pending + token
+		pending = ""
+		if action, ok := bindings[seq]; ok {
+			if run, ok := actions[action]; ok {
+				run()
+				return nil
+			}
+			log.Errorf("no %s action %s", mode, action)
+			return event
+		}
+		if prefixes[seq] {
+			pending = seq
+			pendingSince = time.Now()
+			return nil
+		}
+		if seq != token {
+			// the sequence broke off, try the last key on its own
+			if action, ok := bindings[token]; ok {
+				if run, ok := actions[action]; ok {
+					run()
+					return nil
+				}
+			}
+			if prefixes[token] {
+				pending = token
+				pendingSince = time.Now()
+				return nil
+			}
+		}
+		return event
+	}
+}
@@ -0,0 +1,171 @@
+package widgets
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		keys   string
+		tokens []string
+		err    bool
+	}{
+		{keys: "j", tokens: []string{"j"}},
+		{keys: "gg", tokens: []string{"g", "g"}},
+		{keys: "<C-f>", tokens: []string{"<C-f>"}},
+		{keys: "<c-F>", tokens: []string{"<C-f>"}},
+		{keys: "<C-w>o", tokens: []string{"<C-w>", "o"}},
+		{keys: "<Esc>", tokens: []string{"<Esc>"}},
+		{keys: "<cr>", tokens: []string{"<Enter>"}},
+		{keys: "<Space>", tokens: []string{"<Space>"}},
+		{keys: "<lt>", tokens: []string{"<lt>"}},
+		{keys: "<C-i>", tokens: []string{"<Tab>"}},
+		{keys: "é", tokens: []string{"é"}},
+		{keys: "", err: true},
+		{keys: "<Esc", err: true},
+		{keys: "<Nope>", err: true},
+		{keys: "<C-1>", err: true},
+	}
+	for _, test := range tests {
+		tokens, err := parseKeys(test.keys)
+		if test.err {
+			assert.Error(t, err, test.keys)
+			continue
+		}
+		assert.NoError(t, err, test.keys)
+		assert.Equal(t, test.tokens, tokens, test.keys)
+	}
+}
+
+func TestNewKeymap(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]map[string]string
+		err       string
+	}{
+		{name: "defaults"},
+		{
+			name:      "rebind",
+			overrides: map[string]map[string]string{KeymapNormal: {"<C-d>": "page-down", "j": ""}},
+		},
+		{
+			name:      "prefix",
+			overrides: map[string]map[string]string{KeymapNormal: {"g": "scroll-to-start", "gg": "scroll-to-end"}},
+			err:       "g (scroll-to-start) hides gg (scroll-to-end)",
+		},
+		{
+			name:      "prefix of a default",
+			overrides: map[string]map[string]string{KeymapNormal: {"<C-w>": "quit"}},
+			err:       "hides <C-w>",
+		},
+		{
+			name:      "same keys",
+			overrides: map[string]map[string]string{KeymapNormal: {"<C-x>": "quit", "<c-x>": "clear"}},
+			err:       "are the same keys",
+		},
+		{
+			name:      "unknown action",
+			overrides: map[string]map[string]string{KeymapNormal: {"x": "self-destruct"}},
+			err:       "no such action: self-destruct",
+		},
+		{
+			name:      "unknown mode",
+			overrides: map[string]map[string]string{"visual": {"x": "quit"}},
+			err:       "no such keymap mode: visual",
+		},
+		{
+			name:      "bad keys",
+			overrides: map[string]map[string]string{KeymapNormal: {"<Nope>": "quit"}},
+			err:       "unknown key <Nope>",
+		},
+	}
+	for _, test := range tests {
+		km, err := NewKeymap(test.overrides)
+		if test.err != "" {
+			if assert.Error(t, err, test.name) {
+				assert.Contains(t, err.Error(), test.err, test.name)
+			}
+			continue
+		}
+		if assert.NoError(t, err, test.name) {
+			assert.NotNil(t, km, test.name)
+		}
+	}
+
+	km, err := NewKeymap(map[string]map[string]string{KeymapNormal: {"<C-d>": "page-down", "j": ""}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "<C-d>, <PgDn>", km.Keys(KeymapNormal, "page-down"))
+		assert.Equal(t, "<Down>", km.Keys(KeymapNormal, "scroll-down"))
+	}
+}
+
+func TestKeymapHandler(t *testing.T) {
+	km, err := NewKeymap(map[string]map[string]string{KeymapNormal: {"gg": "scroll-to-start"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ran := make([]string, 0)
+	actions := make(map[string]func())
+	for action := range Actions[KeymapNormal] {
+		action := action
+		actions[action] = func() { ran = append(ran, action) }
+	}
+	handle := km.Handler(KeymapNormal, actions)
+	key := func(r rune) *tcell.EventKey { return tcell.NewEventKey(tcell.KeyRune, r, 0) }
+
+	tests := []struct {
+		name   string
+		events []*tcell.EventKey
+		ran    []string
+		// passed is how many events weren't handled
+		passed int
+	}{
+		{name: "single key", events: []*tcell.EventKey{key('j')}, ran: []string{"scroll-down"}},
+		{name: "sequence", events: []*tcell.EventKey{key('g'), key('g')}, ran: []string{"scroll-to-start"}},
+		{
+			name:   "control key sequence",
+			events: []*tcell.EventKey{tcell.NewEventKey(tcell.KeyCtrlW, 0, tcell.ModCtrl), key('o')},
+			ran:    []string{"toggle-contacts"},
+		},
+		{
+			name:   "broken sequence runs the last key",
+			events: []*tcell.EventKey{key('g'), key('j')},
+			ran:    []string{"scroll-down"},
+		},
+		{name: "unbound", events: []*tcell.EventKey{key('x')}, ran: []string{}, passed: 1},
+		{
+			name:   "unbindable key clears the sequence",
+			events: []*tcell.EventKey{key('g'), tcell.NewEventKey(tcell.KeyF1, 0, 0), key('g')},
+			ran:    []string{},
+			passed: 1,
+		},
+	}
+	for _, test := range tests {
+		ran = ran[:0]
+		passed := 0
+		for _, event := range test.events {
+			if handle(event) != nil {
+				passed++
+			}
+		}
+		assert.Equal(t, test.ran, ran, test.name)
+		assert.Equal(t, test.passed, passed, test.name)
+		// start the next test fresh
+		handle(tcell.NewEventKey(tcell.KeyF1, 0, 0))
+	}
+
+	// a sequence that isn't finished in time starts over
+	defer func(timeout time.Duration) { sequenceTimeout = timeout }(sequenceTimeout)
+	sequenceTimeout = 10 * time.Millisecond
+	ran = ran[:0]
+	assert.Nil(t, handle(key('g')))
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, handle(key('g')))
+	assert.Empty(t, ran)
+	assert.Nil(t, handle(key('g')))
+	assert.Equal(t, []string{"scroll-to-start"}, ran)
+}
@@ -0,0 +1,156 @@
+package widgets
+
+import (
+	"github.com/derricw/siggo/model"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+)
+
+const (
+	// minContactsWidth and maxContactsWidth bound the width of the contact list
+	minContactsWidth = 6
+	maxContactsWidth = 60
+	// minConversationWidth is how much room resizing the contact list leaves the conversation
+	minConversationWidth = 20
+	// resizeStep is how many columns the contact list grows or shrinks by
+	resizeStep = 2
+)
+
+// contactsWidth returns how wide the contact list should be, fitting it to the longest name if no
+// width is set
+func (c *ChatWindow) contactsWidth() int {
+	width := c.layout.ContactsWidth
+	if width <= 0 {
+		for _, contact := range c.siggo.Contacts() {
+			w := tview.TaggedStringWidth(tview.Escape(contact.String()))
+			if w > width {
+				width = w
+			}
+		}
+		// room for the border and the unread and draft glyphs
+		width += 2 + tview.TaggedStringWidth(c.theme.UnreadContactGlyph+c.theme.DraftGlyph)
+	}
+	if width < minContactsWidth {
+		return minContactsWidth
+	} else if width > maxContactsWidth {
+		return maxContactsWidth
+	}
+	return width
+}
+
+// showingContacts returns true if the contact list is showing
+func (c *ChatWindow) showingContacts() bool {
+	return !c.contactsHidden
+}
+
+// columns returns the columns of the grid, and the column the conversation is in
+func (c *ChatWindow) columns() ([]int, int) {
+	if !c.showingContacts() {
+		return []int{0}, 0
+	}
+	if c.layout.ContactsPosition == model.ContactsRight {
+		return []int{0, c.contactsWidth()}, 0
+	}
+	return []int{c.contactsWidth(), 0}, 1
+}
+
+// Relayout puts every panel where it goes, after the layout or the panels showing changed
+func (c *ChatWindow) Relayout() {
+	sendHeight := c.layout.SendHeight
+	if c.compact {
+		sendHeight = 1
+	}
+	rows := []int{0, sendHeight}
+	if c.bottom != nil {
+		rows = append(rows, c.bottomHeight)
+	}
+	columns, main := c.columns()
+	for _, p := range []*tview.Box{c.contactsPanel.Box, c.conversationPanel.Box, c.sendPanel.Box} {
+		p.SetBorder(!c.compact)
+	}
+
+	c.Grid.Clear()
+	c.SetRows(rows...)
+	c.SetColumns(columns...)
+	if c.showingContacts() {
+		c.AddItem(c.contactsPanel, 0, 1-main, 2, 1, 0, 0, false)
+	}
+	c.AddItem(c.mainPanel, 0, main, 1, 1, 0, 0, false)
+	c.AddItem(c.sendPanel, 1, main, 1, 1, 0, 0, false)
+	if c.bottom != nil {
+		c.AddItem(c.bottom, 2, 0, 1, len(columns), 0, 0, false)
+	}
+}
+
+// showBottom shows a panel `height` rows high below everything else, like a command input
+func (c *ChatWindow) showBottom(p tview.Primitive, height int) {
+	c.bottom, c.bottomHeight = p, height
+	c.Relayout()
+}
+
+// hideBottom hides the panel below everything else
+func (c *ChatWindow) hideBottom() {
+	c.bottom = nil
+	c.Relayout()
+}
+
+// ToggleContacts shows or hides the contact list
+func (c *ChatWindow) ToggleContacts() {
+	c.contactsHidden = !c.contactsHidden
+	c.Relayout()
+}
+
+// SetCompact turns compact mode on or off
+func (c *ChatWindow) SetCompact(compact bool) {
+	if compact == c.compact {
+		return
+	}
+	c.compact = compact
+	c.contactsHidden = compact || c.layout.HideContacts
+	c.Relayout()
+}
+
+// ToggleCompact turns compact mode on or off, and keeps it that way however wide the terminal is
+func (c *ChatWindow) ToggleCompact() {
+	c.layout.Compact = !c.compact
+	c.layout.CompactWidth = 0
+	c.SetCompact(c.layout.Compact)
+}
+
+// ResizeContacts makes the contact list `n` columns wider, or narrower if n is negative
+func (c *ChatWindow) ResizeContacts(n int) {
+	if !c.showingContacts() {
+		return
+	}
+	width := c.contactsWidth() + n
+	if _, _, total, _ := c.GetRect(); total > 0 && total-width < minConversationWidth {
+		width = total - minConversationWidth
+	}
+	if width < minContactsWidth {
+		width = minContactsWidth
+	}
+	c.layout.ContactsWidth = width
+	c.Relayout()
+}
+
+// ResetLayout goes back to the configured layout
+func (c *ChatWindow) ResetLayout() {
+	c.layout = c.siggo.Config().Layout
+	c.compact = c.layout.Compact
+	c.contactsHidden = c.compact || c.layout.HideContacts
+	c.Relayout()
+}
+
+// Draw draws the chat window, going into or out of compact mode first if the terminal got
+// narrower or wider than the compact width, and fitting the contact list to new names
+func (c *ChatWindow) Draw(screen tcell.Screen) {
+	_, _, width, _ := c.GetRect()
+	if c.layout.CompactWidth > 0 && width > 0 {
+		c.SetCompact(c.layout.Compact || width < c.layout.CompactWidth)
+	}
+	if c.layout.ContactsWidth <= 0 {
+		columns, _ := c.columns()
+		c.SetColumns(columns...)
+	}
+	c.Grid.Draw(screen)
+}
@@ -4,9 +4,9 @@ import (
 	"fmt"
 
 	"github.com/atotto/clipboard"
+	"github.com/derricw/siggo/model"
 	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
-	log "github.com/sirupsen/logrus"
 	"github.com/skratchdot/open-golang/open"
 )
 
@@ -15,6 +15,8 @@ type LinksInput struct {
 	*tview.List
 	parent *ChatWindow
 	links  []string
+	// titles are the titles of the links that came with previews
+	titles map[string]string
 }
 
 func (li *LinksInput) Close() {
@@ -26,11 +28,33 @@ func (li *LinksInput) Close() {
 // init populates the list with links
 func (li *LinksInput) init() {
 	li.Clear()
-	l := li.parent.getLinks() // should be sorted by date
-	li.links = l
-	for _, item := range l {
-		li.AddItem(fmt.Sprintf(" %s", item), "", 0, nil)
+	li.SetLinks(li.parent.getLinks(), li.parent.getLinkTitles()) // should be sorted by date
+}
+
+// SetLinks replaces the links to choose from, showing the titles of those in `titles` next to them
+func (li *LinksInput) SetLinks(links []string, titles map[string]string) {
+	li.Clear()
+	li.links = links
+	li.titles = titles
+	for _, item := range links {
+		if title, ok := titles[item]; ok {
+			item = fmt.Sprintf("%s — %s", title, item)
+		}
+		li.AddItem(tview.Escape(fmt.Sprintf(" %s", item)), "", 0, nil)
+	}
+}
+
+// linkTitles returns the titles of links in `msgs` from their previews, by URL
+func linkTitles(msgs ...*model.Message) map[string]string {
+	titles := make(map[string]string)
+	for _, msg := range msgs {
+		for _, p := range msg.LinkPreviews() {
+			if p.Title != "" {
+				titles[p.URL] = p.Title
+			}
+		}
 	}
+	return titles
 }
 
 func (li *LinksInput) Previous() {
@@ -59,7 +83,7 @@ func (li *LinksInput) OpenLink(link string) {
 	if err != nil {
 		li.parent.SetErrorStatus(fmt.Errorf("<OPEN FAILED: %v>", err))
 	} else {
-		li.parent.SetStatus(fmt.Sprintf("📂%s", link))
+		li.parent.SetStatus(fmt.Sprintf("%s%s", li.parent.theme.LinkGlyph, link))
 	}
 }
 
@@ -76,7 +100,7 @@ func (li *LinksInput) YankSelected() {
 		li.parent.SetErrorStatus(err)
 		return
 	}
-	li.parent.SetStatus(fmt.Sprintf("📋%s", link))
+	li.parent.SetStatus(fmt.Sprintf("%s%s", li.parent.theme.ClipboardGlyph, link))
 }
 
 // OpenLast opens the most recent link
@@ -87,7 +111,7 @@ func (li *LinksInput) OpenLast() {
 		last := links[len(links)-1]
 		li.OpenLink(last)
 	} else {
-		li.parent.SetStatus(fmt.Sprintf("📂<NO MATCHES>"))
+		li.parent.SetStatus(fmt.Sprintf("%s<NO MATCHES>", li.parent.theme.LinkGlyph))
 	}
 }
 
@@ -97,61 +121,32 @@ func NewLinksInput(parent *ChatWindow) *LinksInput {
 		parent: parent,
 	}
 	inputHandler := li.List.InputHandler()
-	li.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		// Setup keys
-		log.Debugf("Key Event <LINK>: %v mods: %v rune: %v", event.Key(), event.Modifiers(), event.Rune())
-		switch event.Key() {
-		case tcell.KeyESC:
+	scroll := func(key tcell.Key) func() {
+		return func() {
+			inputHandler(tcell.NewEventKey(key, 0, tcell.ModNone), func(p tview.Primitive) {})
+		}
+	}
+	li.SetInputCapture(parent.keymap.Handler(KeymapLink, map[string]func(){
+		"next":          li.Next,
+		"previous":      li.Previous,
+		"page-down":     scroll(tcell.KeyPgDn),
+		"page-up":       scroll(tcell.KeyPgUp),
+		"first":         scroll(tcell.KeyHome),
+		"last":          scroll(tcell.KeyEnd),
+		"open-selected": li.OpenSelected,
+		"open-last":     li.OpenLast,
+		"yank-selected": li.YankSelected,
+		"close": func() {
 			li.Close()
 			li.parent.NormalMode()
-			return nil
-		//case tcell.KeyUp:
-		//li.Next()
-		//return nil
-		//case tcell.KeyDown:
-		//li.Previous()
-		//return nil
-		case tcell.KeyPgUp:
-			inputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyPgDn:
-			inputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyEnd:
-			inputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyHome:
-			inputHandler(event, func(p tview.Primitive) {})
-			return nil
-
-		case tcell.KeyRune:
-			switch event.Rune() {
-			case 108: // l
-				li.OpenLast()
-				return nil
-			case 106: // j
-				li.Next()
-				return nil
-			case 107: // k
-				li.Previous()
-				return nil
-			case 121: // y
-				li.YankSelected()
-				return nil
-			}
-		case tcell.KeyEnter:
-			li.OpenSelected()
-			return nil
-		}
-
-		return event
-	})
+		},
+	}))
 
 	//li.SetDynamicColors(true)
 	li.SetHighlightFullLine(true)
 	li.ShowSecondaryText(false)
 	li.SetBorder(true)
-	li.SetTitle(fmt.Sprintf("urls: %s", parent.currentContactName()))
+	li.SetTitle(fmt.Sprintf("urls: %s", tview.Escape(parent.currentContactName())))
 	li.SetTitleAlign(0)
 	li.init()
 	li.SetCurrentItem(-1)
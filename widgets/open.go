@@ -5,7 +5,6 @@ import (
 
 	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
-	log "github.com/sirupsen/logrus"
 	"github.com/skratchdot/open-golang/open"
 
 	"github.com/derricw/siggo/model"
@@ -26,8 +25,12 @@ func (oi *OpenInput) Close() {
 
 // init populates the list with attachments
 func (oi *OpenInput) init() {
+	oi.SetAttachments(oi.parent.getAttachments()) // should be sorted by date
+}
+
+// SetAttachments replaces the attachments to choose from
+func (oi *OpenInput) SetAttachments(a []*model.Attachment) {
 	oi.Clear()
-	a := oi.parent.getAttachments() // should be sorted by date
 	oi.attachments = a
 	for _, item := range a {
 		var text string
@@ -36,7 +39,7 @@ func (oi *OpenInput) init() {
 		} else {
 			text = fmt.Sprintf(" -> %s", item.String())
 		}
-		oi.AddItem(text, "", 0, nil)
+		oi.AddItem(tview.Escape(text), "", 0, nil)
 	}
 }
 
@@ -58,7 +61,7 @@ func (oi *OpenInput) OpenLast() {
 		last := attachments[len(attachments)-1]
 		oi.OpenAttachment(last)
 	} else {
-		oi.parent.SetStatus(fmt.Sprintf("📎<NO MATCHES>"))
+		oi.parent.SetStatus(fmt.Sprintf("%s<NO MATCHES>", oi.parent.theme.AttachmentGlyph))
 	}
 }
 
@@ -76,7 +79,7 @@ func (oi *OpenInput) OpenSelected() {
 func (oi *OpenInput) OpenAttachment(attachment *model.Attachment) {
 	path, err := attachment.Path()
 	if err != nil {
-		oi.parent.SetErrorStatus(fmt.Errorf("📎failed to find attachment: %v", err))
+		oi.parent.SetErrorStatus(fmt.Errorf("%sfailed to find attachment: %v", oi.parent.theme.AttachmentGlyph, err))
 		return
 	}
 	oi.OpenPath(path)
@@ -87,9 +90,9 @@ func (oi *OpenInput) OpenPath(path string) {
 	go func() {
 		err := open.Run(path)
 		if err != nil {
-			oi.parent.SetErrorStatus(fmt.Errorf("📎<OPEN FAILED: %v>", err))
+			oi.parent.SetErrorStatus(fmt.Errorf("%s<OPEN FAILED: %v>", oi.parent.theme.AttachmentGlyph, err))
 		} else {
-			oi.parent.SetStatus(fmt.Sprintf("📎%s", path))
+			oi.parent.SetStatus(fmt.Sprintf("%s%s", oi.parent.theme.AttachmentGlyph, path))
 		}
 	}()
 }
@@ -100,58 +103,31 @@ func NewOpenInput(parent *ChatWindow) *OpenInput {
 		parent: parent,
 	}
 	inputHandler := oi.List.InputHandler()
-	oi.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		// Setup keys
-		log.Debugf("Key Event <OPEN>: %v mods: %v rune: %v", event.Key(), event.Modifiers(), event.Rune())
-		switch event.Key() {
-		case tcell.KeyESC:
+	scroll := func(key tcell.Key) func() {
+		return func() {
+			inputHandler(tcell.NewEventKey(key, 0, tcell.ModNone), func(p tview.Primitive) {})
+		}
+	}
+	oi.SetInputCapture(parent.keymap.Handler(KeymapOpen, map[string]func(){
+		"next":          oi.Next,
+		"previous":      oi.Previous,
+		"page-down":     scroll(tcell.KeyPgDn),
+		"page-up":       scroll(tcell.KeyPgUp),
+		"first":         scroll(tcell.KeyHome),
+		"last":          scroll(tcell.KeyEnd),
+		"open-selected": oi.OpenSelected,
+		"open-last":     oi.OpenLast,
+		"close": func() {
 			oi.Close()
 			oi.parent.NormalMode()
-			return nil
-		//case tcell.KeyUp:
-		//oi.Next()
-		//return nil
-		//case tcell.KeyDown:
-		//oi.Previous()
-		//return nil
-		case tcell.KeyPgUp:
-			inputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyPgDn:
-			inputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyEnd:
-			inputHandler(event, func(p tview.Primitive) {})
-			return nil
-		case tcell.KeyHome:
-			inputHandler(event, func(p tview.Primitive) {})
-			return nil
-
-		case tcell.KeyRune:
-			switch event.Rune() {
-			case 111: // o
-				oi.OpenLast()
-				return nil
-			case 106: // j
-				oi.Next()
-				return nil
-			case 107: // k
-				oi.Previous()
-				return nil
-			}
-		case tcell.KeyEnter:
-			oi.OpenSelected()
-			return nil
-		}
-
-		return event
-	})
+		},
+	}))
 
 	//oi.SetDynamicColors(true)
 	oi.SetHighlightFullLine(true)
 	oi.ShowSecondaryText(false)
 	oi.SetBorder(true)
-	oi.SetTitle(fmt.Sprintf("attachments: %s", parent.currentContactName()))
+	oi.SetTitle(fmt.Sprintf("attachments: %s", tview.Escape(parent.currentContactName())))
 	oi.SetTitleAlign(0)
 	oi.init()
 	oi.SetCurrentItem(-1)
@@ -0,0 +1,436 @@
+package widgets
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/derricw/siggo/model"
+	"github.com/rivo/tview"
+)
+
+// minTextWidth is the least room wrapped messages get after the hanging indent. Narrower than
+// that, they wrap under the timestamp instead.
+const minTextWidth = 16
+
+// tagPattern matches escaped tags like "[red[]" (which show up as "[red]") and the color and
+// region tags tview understands, which take up no room
+var tagPattern = regexp.MustCompile(`\[[a-zA-Z0-9_,;: \-\."#]+\[\[*\]|\["[a-zA-Z0-9_,;: \-\.]*"\]|\[([a-zA-Z]+|#[0-9a-zA-Z]{6}|\-)?(:([a-zA-Z]+|#[0-9a-zA-Z]{6}|\-)?(:([lbdru]+|\-)?)?)?\]`)
+
+// Renderer renders messages for the conversation panel, in columns for the time, the delivery
+// status and the sender. Long messages are wrapped to line up after the sender, and a line shows
+// where each day starts.
+type Renderer struct {
+	theme  *model.Theme
+	format model.MessageFormat
+	loc    *time.Location
+	now    time.Time
+	// width is how wide lines can be, 0 for as wide as they like. The columns are as wide as they
+	// need to be for every message in the conversation.
+	width       int
+	timeWidth   int
+	statusWidth int
+	senderWidth int
+	// revealed are the messages showing their spoilers
+	revealed map[model.MessageKey]bool
+	// images shows thumbnails of image attachments, drawn with protocol. placements are where the
+	// last message rendered needs images drawn over it, in rows from its first line.
+	images     bool
+	protocol   string
+	thumbs     *Thumbnails
+	placements []imagePlacement
+}
+
+// Highlight is part of a message's content to put in a region of its own, like a search match.
+// Start and End are byte offsets in the content.
+type Highlight struct {
+	Start, End int
+	Region     string
+	// Current shows it with the search match background
+	Current bool
+}
+
+// NewRenderer returns a renderer for messages styled by `theme` and laid out by `format`
+func NewRenderer(theme *model.Theme, format model.MessageFormat) *Renderer {
+	loc, err := format.Location()
+	if err != nil {
+		// checked when the config is loaded, so this shouldn't happen
+		loc = time.Local
+	}
+	return &Renderer{
+		theme:    theme,
+		format:   format,
+		loc:      loc,
+		revealed: make(map[model.MessageKey]bool),
+		protocol: imageProtocol(format.ImageProtocol),
+		thumbs:   NewThumbnails(),
+	}
+}
+
+// Prepare gets ready to render the messages of `conv` in lines `width` columns wide, with
+// thumbnails of image attachments if `images` is true
+func (r *Renderer) Prepare(conv *model.Conversation, width int, images bool) {
+	r.now = time.Now().In(r.loc)
+	r.width = width
+	r.images = images
+	r.timeWidth, r.statusWidth, r.senderWidth = 0, 0, 0
+	for _, key := range conv.MessageOrder {
+		msg := conv.Messages[key]
+		r.timeWidth = max(r.timeWidth, tview.TaggedStringWidth(r.timestamp(msg)))
+		r.statusWidth = max(r.statusWidth, tview.TaggedStringWidth(r.theme.Status(msg)))
+		r.senderWidth = max(r.senderWidth, tview.TaggedStringWidth(r.sender(msg)))
+	}
+}
+
+// time returns when a message was sent, in the configured time zone
+func (r *Renderer) time(msg *model.Message) time.Time {
+	return model.MessageTime(msg.Timestamp).In(r.loc)
+}
+
+// timestamp returns the timestamp shown for a message
+func (r *Renderer) timestamp(msg *model.Message) string {
+	return r.format.Timestamp(r.time(msg), r.now)
+}
+
+// sender returns who a message is shown as from, escaped
+func (r *Renderer) sender(msg *model.Message) string {
+	if msg.FromSelf {
+		return r.theme.SelfGlyph
+	}
+	return tview.Escape(msg.FromContact.String())
+}
+
+// sameSender returns true if `a` and `b` are from the same person
+func sameSender(a, b *model.Message) bool {
+	if a.FromSelf || b.FromSelf {
+		return a.FromSelf && b.FromSelf
+	}
+	return a.FromContact == b.FromContact
+}
+
+// Separator returns the line shown before `msg` if it starts a new day since `prev`, which is nil
+// for the first message. Returns "" if there is no line to show.
+func (r *Renderer) Separator(prev, msg *model.Message) string {
+	if r.format.DayFormat == "" {
+		return ""
+	}
+	t := r.time(msg)
+	if prev != nil && model.SameDay(t, r.time(prev)) {
+		return ""
+	}
+	label := fmt.Sprintf(" %s ", t.Format(r.format.DayFormat))
+	width := r.width
+	if width <= 0 {
+		width = tview.TaggedStringWidth(label) + 8
+	}
+	left := (width - tview.TaggedStringWidth(label)) / 2
+	right := width - tview.TaggedStringWidth(label) - left
+	if left < 0 {
+		left, right = 0, 0
+	}
+	glyph := r.theme.SeparatorGlyph
+	line := strings.Repeat(glyph, left) + label + strings.Repeat(glyph, right)
+	return fmt.Sprintf("%s%s\n", model.StyleTag(r.theme.Timestamp), tview.Escape(line))
+}
+
+// ToggleSpoilers reveals or hides the spoilers of the message with `key`
+func (r *Renderer) ToggleSpoilers(key model.MessageKey) {
+	if r.revealed[key] {
+		delete(r.revealed, key)
+	} else {
+		r.revealed[key] = true
+	}
+}
+
+// Message renders `msg`, which follows `prev` (nil for the first message) and is in `region`. Each
+// of the `highlights` goes in a region of its own. Everything from the message itself is escaped,
+// so it can't be taken for tags.
+func (r *Renderer) Message(prev, msg *model.Message, region string, highlights []Highlight) string {
+	tag := r.theme.MessageTag(msg)
+	sender := r.sender(msg)
+	colon := ":"
+	if r.format.CollapseSenders && prev != nil && sameSender(prev, msg) && model.SameDay(r.time(prev), r.time(msg)) {
+		sender, colon = "", " "
+	}
+	header := fmt.Sprintf("%s[%s]%s%s|%s| %s%s ",
+		tag, r.theme.Timestamp, padRight(r.timestamp(msg), r.timeWidth),
+		tag, padRight(r.theme.Status(msg), r.statusWidth),
+		padLeft(sender, r.senderWidth), colon)
+
+	indent := tview.TaggedStringWidth(header)
+	if !r.format.HangingIndent || (r.width > 0 && r.width-indent < minTextWidth) {
+		indent = 0
+	}
+	first, rest := r.width-tview.TaggedStringWidth(header), r.width-indent
+	if r.width > 0 && first < 1 {
+		first = 1
+	}
+	if r.width <= 0 {
+		first, rest = 0, 0
+	}
+	pad := strings.Repeat(" ", indent)
+
+	var body strings.Builder
+	previews, attachments := make([]model.Span, 0), make([]model.Span, 0)
+	for _, span := range msg.Spans() {
+		switch span.Kind {
+		case model.QuoteSpan:
+			fmt.Fprintf(&body, "%s “%s” ", r.theme.ReplyGlyph, tview.Escape(span.Text))
+		case model.TextSpan, model.LinkSpan:
+			body.WriteString(r.text(msg, span, region, highlights))
+		case model.ReactionSpan:
+			fmt.Fprintf(&body, "%s  %s", tag, tview.Escape(span.Text))
+		case model.PreviewSpan:
+			previews = append(previews, span)
+		case model.AttachmentSpan:
+			attachments = append(attachments, span)
+		}
+	}
+	lines := wrap(body.String(), first, rest)
+	lines[0] = header + lines[0]
+	r.placements = r.placements[:0]
+	for _, span := range previews {
+		lines = append(lines, r.preview(msg, span.Preview, len(lines), indent, rest)...)
+	}
+	for _, span := range attachments {
+		a := span.Attachment
+		text := tview.Escape(fmt.Sprintf("%s %s | %s | %dB", r.theme.AttachmentGlyph, span.Text, a.ContentType, a.Size))
+		for _, line := range wrap(text, rest, rest) {
+			lines = append(lines, fmt.Sprintf("%s[%s]%s", tag, r.theme.Attachment, line))
+		}
+		lines = append(lines, r.thumbnail(a, len(lines), indent, rest)...)
+	}
+	return strings.Join(lines, "\n"+pad) + "\n"
+}
+
+// previewLines is the most lines of a link preview's description that are shown
+const previewLines = 2
+
+// preview returns the lines of a card showing the link preview `p`: the title, the start of the
+// description and the site, and a thumbnail of the page if it has one and thumbnails are shown.
+// It starts `row` lines into the message, `col` columns in, and is no wider than `width`.
+func (r *Renderer) preview(msg *model.Message, p *model.Preview, row, col, width int) []string {
+	side := fmt.Sprintf("%s%s ", r.theme.MessageTag(msg, r.theme.Preview), r.theme.PreviewGlyph)
+	inner := 0
+	if width > 0 {
+		inner = max(width-tview.TaggedStringWidth(side), 1)
+	}
+	lines := make([]string, 0)
+	add := func(tag, text string, limit int) {
+		wrapped := wrap(tview.Escape(text), inner, inner)
+		if limit > 0 && len(wrapped) > limit {
+			wrapped = wrapped[:limit]
+			wrapped[limit-1] += "…"
+		}
+		for _, line := range wrapped {
+			lines = append(lines, side+tag+line)
+		}
+	}
+	add(r.theme.MessageTag(msg, r.theme.Preview, r.theme.BoldText), p.Label(), 0)
+	if p.Description != "" {
+		add(r.theme.MessageTag(msg, r.theme.Preview), strings.Join(strings.Fields(p.Description), " "), previewLines)
+	}
+	if p.Title != "" {
+		add(r.theme.MessageTag(msg, r.theme.Preview, r.theme.LinkText), p.Site(), 1)
+	}
+	if p.Image != nil {
+		lines = append(lines, r.thumbnail(p.Image, row+len(lines), col, width)...)
+	}
+	return lines
+}
+
+// Images returns where the last message rendered needs thumbnails drawn over it, which is nowhere
+// if they are drawn with half-block characters
+func (r *Renderer) Images() []imagePlacement {
+	return r.placements
+}
+
+// thumbnail returns the lines of the thumbnail of `a`, if it is an image and thumbnails are shown.
+// It starts `row` lines into the message, `col` columns in, and is no wider than `width`. Drawn
+// with half-block characters, the lines are the image. Otherwise they are room for it, and where to
+// draw it is kept for Images.
+func (r *Renderer) thumbnail(a *model.Attachment, row, col, width int) []string {
+	if !r.images || !imageTypes[a.ContentType] {
+		return nil
+	}
+	path, err := a.Path()
+	if err != nil {
+		return nil
+	}
+	thumb := r.thumbs.Get(path)
+	if thumb == nil {
+		return nil
+	}
+	if width <= 0 {
+		width = 80
+	}
+	cols, rows := thumb.size(r.format.ImageRows, width)
+	if cols == 0 {
+		return nil
+	}
+	if r.protocol == model.ImagesBlocks {
+		return thumb.Blocks(cols, rows)
+	}
+	r.placements = append(r.placements, imagePlacement{thumb: thumb, row: row, col: col, cols: cols, rows: rows})
+	lines := make([]string, rows)
+	for i := range lines {
+		lines[i] = strings.Repeat(" ", cols)
+	}
+	return lines
+}
+
+// text renders a text or link span in its text styles, with spoilers hidden unless they were
+// revealed, and the parts of it in `highlights` in their regions. Every bit of it starts with a
+// tag, so that escaped text next to each other can't end up looking like a tag.
+func (r *Renderer) text(msg *model.Message, span model.Span, region string, highlights []Highlight) string {
+	var b strings.Builder
+	end := span.Start + len(span.Text)
+	cuts := []int{span.Start, end}
+	for _, h := range highlights {
+		for _, cut := range []int{h.Start, h.End} {
+			if cut > span.Start && cut < end {
+				cuts = append(cuts, cut)
+			}
+		}
+	}
+	sort.Ints(cuts)
+	styles := make([]string, 0, len(span.Styles)+2)
+	for _, style := range span.Styles {
+		styles = append(styles, r.theme.TextStyle(style))
+	}
+	if span.Kind == model.LinkSpan {
+		styles = append(styles, r.theme.LinkText)
+	}
+	for i := 0; i+1 < len(cuts); i++ {
+		start, stop := cuts[i], cuts[i+1]
+		text := tview.Escape(span.Text[start-span.Start : stop-span.Start])
+		if span.Has(model.StyleSpoiler) && !r.revealed[msg.Key()] {
+			text = strings.Repeat(r.theme.SpoilerGlyph, tview.TaggedStringWidth(text))
+		}
+		var h *Highlight
+		for j := range highlights {
+			if highlights[j].Start <= start && start < highlights[j].End {
+				h = &highlights[j]
+			}
+		}
+		if h != nil && h.Start == start {
+			fmt.Fprintf(&b, `["%s"]`, h.Region)
+		}
+		if h != nil && h.Current {
+			b.WriteString(r.theme.MessageTag(msg, append(styles, ":"+r.theme.SearchMatch+":")...))
+		} else {
+			b.WriteString(r.theme.MessageTag(msg, styles...))
+		}
+		b.WriteString(text)
+		if h != nil && h.End == stop {
+			fmt.Fprintf(&b, `["%s"]`, region)
+		}
+	}
+	return b.String()
+}
+
+// padLeft pads `s` with spaces on the left to be `width` columns wide
+func padLeft(s string, width int) string {
+	if w := tview.TaggedStringWidth(s); w < width {
+		return strings.Repeat(" ", width-w) + s
+	}
+	return s
+}
+
+// padRight pads `s` with spaces on the right to be `width` columns wide
+func padRight(s string, width int) string {
+	if w := tview.TaggedStringWidth(s); w < width {
+		return s + strings.Repeat(" ", width-w)
+	}
+	return s
+}
+
+// piece is a bit of text that doesn't get split when wrapping: a character or a tag
+type piece struct {
+	text  string
+	width int
+}
+
+// pieces splits `text` into characters and tags
+func pieces(text string) []piece {
+	out := make([]piece, 0, len(text))
+	addText := func(s string) {
+		for _, c := range s {
+			out = append(out, piece{string(c), tview.TaggedStringWidth(string(c))})
+		}
+	}
+	pos := 0
+	for _, loc := range tagPattern.FindAllStringIndex(text, -1) {
+		if loc[1]-loc[0] == 2 {
+			// "[]" isn't a tag
+			continue
+		}
+		addText(text[pos:loc[0]])
+		tag := text[loc[0]:loc[1]]
+		width := tview.TaggedStringWidth(tag)
+		if regionTag.MatchString(tag) {
+			width = 0
+		}
+		out = append(out, piece{tag, width})
+		pos = loc[1]
+	}
+	addText(text[pos:])
+	return out
+}
+
+// wrap splits `text` into lines no wider than `first` columns for the first line and `rest` for
+// the others, breaking at spaces where it can. Tags take up no room and stay where they are. A
+// width of 0 doesn't wrap.
+func wrap(text string, first, rest int) []string {
+	lines := make([]string, 0)
+	limit := first
+	for _, paragraph := range strings.Split(text, "\n") {
+		if len(lines) > 0 {
+			limit = rest
+		}
+		if limit <= 0 {
+			lines = append(lines, paragraph)
+			continue
+		}
+		line := make([]piece, 0)
+		width, space := 0, -1
+		for _, p := range pieces(paragraph) {
+			if p.text == " " {
+				space = len(line)
+			}
+			line = append(line, p)
+			width += p.width
+			if width <= limit {
+				continue
+			}
+			// too wide, break at the last space, or else before this piece
+			cut, next := len(line)-1, len(line)-1
+			if space >= 0 {
+				cut, next = space, space+1
+			} else if cut == 0 {
+				// a single character wider than the line, nothing to do but let it stick out
+				continue
+			}
+			lines = append(lines, joinPieces(line[:cut]))
+			line = append(line[:0:0], line[next:]...)
+			width, space = 0, -1
+			for _, p := range line {
+				width += p.width
+			}
+			limit = rest
+		}
+		lines = append(lines, joinPieces(line))
+	}
+	return lines
+}
+
+// joinPieces joins pieces back together
+func joinPieces(pieces []piece) string {
+	var b strings.Builder
+	for _, p := range pieces {
+		b.WriteString(p.text)
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package widgets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPieces(t *testing.T) {
+	assert.Equal(t, []piece{{"a", 1}, {"[red]", 0}, {"界", 2}, {"[", 1}, {"]", 1}, {`["msg-1"]`, 0}, {"b", 1}},
+		pieces(`a[red]界[]["msg-1"]b`))
+	// "[]" isn't a tag, it's two characters
+	assert.Equal(t, []piece{{"[", 1}, {"]", 1}}, pieces("[]"))
+}
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		first, rest int
+		lines       []string
+	}{
+		{name: "fits", text: "leeloo dallas", first: 20, rest: 20, lines: []string{"leeloo dallas"}},
+		{name: "no width", text: "leeloo dallas", first: 0, rest: 0, lines: []string{"leeloo dallas"}},
+		{
+			name:  "at spaces",
+			text:  "leeloo dallas multipass",
+			first: 10, rest: 10,
+			lines: []string{"leeloo", "dallas", "multipass"},
+		},
+		{
+			name:  "narrower first line",
+			text:  "leeloo dallas multipass",
+			first: 6, rest: 20,
+			lines: []string{"leeloo", "dallas multipass"},
+		},
+		{
+			name:  "newlines",
+			text:  "leeloo\ndallas multipass",
+			first: 20, rest: 9,
+			lines: []string{"leeloo", "dallas", "multipass"},
+		},
+		{
+			name:  "long word",
+			text:  "a multipass",
+			first: 4, rest: 4,
+			lines: []string{"a", "mult", "ipas", "s"},
+		},
+		{
+			name:  "wide runes",
+			text:  "世界世界世",
+			first: 4, rest: 4,
+			lines: []string{"世界", "世界", "世"},
+		},
+		{
+			name:  "wide rune that doesn't fit",
+			text:  "a世b",
+			first: 2, rest: 2,
+			lines: []string{"a", "世", "b"},
+		},
+		{
+			name:  "wider than the line",
+			text:  "世",
+			first: 1, rest: 1,
+			lines: []string{"世"},
+		},
+		{
+			name:  "color tags take no room",
+			text:  "[red]leeloo[-] [::b]dallas[::-]",
+			first: 6, rest: 6,
+			lines: []string{"[red]leeloo[-]", "[::b]dallas[::-]"},
+		},
+		{
+			name:  "region tags take no room",
+			text:  `["msg-0"]leeloo dallas[""]`,
+			first: 13, rest: 13,
+			lines: []string{`["msg-0"]leeloo dallas[""]`},
+		},
+		{
+			name:  "tags in a long word",
+			text:  "mul[red]tipass[-]",
+			first: 4, rest: 4,
+			lines: []string{"mul[red]t", "ipas", "s[-]"},
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.lines, wrap(test.text, test.first, test.rest), test.name)
+	}
+}
@@ -0,0 +1,144 @@
+package widgets
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/derricw/siggo/model"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxSearchResults is how many matches we list in the message search
+const maxSearchResults = 200
+
+// MessageSearch is a widget that searches messages in every conversation and jumps to the one
+// we pick
+type MessageSearch struct {
+	*tview.Flex
+	input   *tview.InputField
+	list    *tview.List
+	parent  *ChatWindow
+	results []*model.SearchResult
+}
+
+// Close hides the search and shows the conversation again
+func (ms *MessageSearch) Close() {
+	ms.parent.Grid.RemoveItem(ms)
+	ms.parent.ShowConversation()
+	ms.parent.NormalMode()
+}
+
+// Search looks for the query in the background, the index is built the first time. The
+// conversations are copied first, so they can keep changing while we search.
+func (ms *MessageSearch) Search() {
+	query := ms.input.GetText()
+	if strings.TrimSpace(query) == "" {
+		return
+	}
+	ms.parent.SetStatus(fmt.Sprintf("🔍searching for: %s", query))
+	snap := ms.parent.siggo.Snapshot()
+	go func() {
+		results, err := snap.Search(query, model.SearchOptions{Limit: maxSearchResults})
+		ms.parent.app.QueueUpdateDraw(func() {
+			if err != nil {
+				ms.parent.SetErrorStatus(fmt.Errorf("🔍search failed: %v", err))
+				return
+			}
+			ms.setResults(results)
+			ms.parent.SetStatus(fmt.Sprintf("🔍%d matches for: %s", len(results), query))
+			if len(results) > 0 {
+				ms.parent.app.SetFocus(ms.list)
+			}
+		})
+	}()
+}
+
+func (ms *MessageSearch) setResults(results []*model.SearchResult) {
+	ms.results = results
+	ms.list.Clear()
+	for _, r := range results {
+		ts := time.Unix(0, r.Message.Timestamp*int64(time.Millisecond)).Format("2006-01-02 15:04")
+		sender := "~"
+		if !r.Message.FromSelf && r.Message.FromContact != nil {
+			sender = r.Message.FromContact.String()
+		}
+		content := strings.ReplaceAll(r.Message.MaskedText(ms.parent.theme.SpoilerGlyph), "\n", " ")
+		ms.list.AddItem(tview.Escape(fmt.Sprintf(" %s | %s | %s: %s", ts, r.Contact, sender, content)),
+			"", 0, nil)
+	}
+}
+
+// GotoSelected jumps to the selected match in its conversation
+func (ms *MessageSearch) GotoSelected() {
+	selected := ms.list.GetCurrentItem()
+	if len(ms.results) == 0 || selected >= len(ms.results) {
+		return
+	}
+	result := ms.results[selected]
+	ms.Close()
+	ms.parent.GotoMessage(result.Contact, result.Message.Key())
+}
+
+// NewMessageSearch creates a message search widget
+func NewMessageSearch(parent *ChatWindow) *MessageSearch {
+	ms := &MessageSearch{
+		Flex:   tview.NewFlex().SetDirection(tview.FlexRow),
+		input:  tview.NewInputField(),
+		list:   tview.NewList(),
+		parent: parent,
+	}
+	ms.input.SetLabel("🔍: ")
+	ms.input.SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor)
+	ms.input.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		log.Debugf("Key Event <SEARCH>: %v mods: %v rune: %v", event.Key(), event.Modifiers(), event.Rune())
+		switch event.Key() {
+		case tcell.KeyESC:
+			ms.Close()
+			return nil
+		case tcell.KeyEnter:
+			ms.Search()
+			return nil
+		case tcell.KeyTab, tcell.KeyDown:
+			ms.parent.app.SetFocus(ms.list)
+			return nil
+		}
+		return event
+	})
+	ms.list.ShowSecondaryText(false)
+	ms.list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		log.Debugf("Key Event <SEARCH>: %v mods: %v rune: %v", event.Key(), event.Modifiers(), event.Rune())
+		switch event.Key() {
+		case tcell.KeyESC:
+			ms.Close()
+			return nil
+		case tcell.KeyEnter:
+			ms.GotoSelected()
+			return nil
+		case tcell.KeyTab:
+			ms.parent.app.SetFocus(ms.input)
+			return nil
+		case tcell.KeyRune:
+			switch event.Rune() {
+			case 106: // j
+				ms.list.SetCurrentItem(ms.list.GetCurrentItem() + 1)
+				return nil
+			case 107: // k
+				ms.list.SetCurrentItem(ms.list.GetCurrentItem() - 1)
+				return nil
+			case 47: // /
+				ms.parent.app.SetFocus(ms.input)
+				return nil
+			}
+		}
+		return event
+	})
+	ms.AddItem(ms.input, 1, 0, true)
+	ms.AddItem(ms.list, 0, 1, false)
+	ms.SetBorder(true)
+	ms.SetTitle("search messages...")
+	ms.SetTitleAlign(0)
+	return ms
+}
@@ -0,0 +1,301 @@
+package widgets
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/derricw/siggo/model"
+	"github.com/gdamore/tcell"
+	"github.com/kyokomi/emoji"
+	"github.com/rivo/tview"
+	log "github.com/sirupsen/logrus"
+)
+
+// selectedMessage returns the message under the cursor, showing an error if there isn't one
+func (c *ChatWindow) selectedMessage() *model.Message {
+	msg := c.conversationPanel.Selected()
+	if msg == nil {
+		c.SetErrorStatus(fmt.Errorf("no message selected"))
+	}
+	return msg
+}
+
+// YankSelected copies the selected message to the clipboard
+func (c *ChatWindow) YankSelected() {
+	msg := c.selectedMessage()
+	if msg == nil {
+		return
+	}
+	content := strings.TrimSpace(msg.Text())
+	if err := clipboard.WriteAll(content); err != nil {
+		c.SetErrorStatus(err)
+		return
+	}
+	c.SetStatus(fmt.Sprintf("%s%s", c.theme.ClipboardGlyph, content))
+}
+
+// OpenSelected opens the attachment of the selected message, or lets us choose if there are more
+func (c *ChatWindow) OpenSelected() {
+	msg := c.selectedMessage()
+	if msg == nil {
+		return
+	}
+	oi := NewOpenInput(c)
+	oi.SetAttachments(msg.Attachments)
+	switch len(msg.Attachments) {
+	case 0:
+		c.SetStatus(c.theme.AttachmentGlyph + "<NO MATCHES>")
+	case 1:
+		oi.OpenAttachment(msg.Attachments[0])
+	default:
+		c.mode = OpenMode
+		c.HideConversation(oi)
+		c.app.SetFocus(oi)
+	}
+}
+
+// LinkSelected opens the link in the selected message, or lets us choose if there are more
+func (c *ChatWindow) LinkSelected() {
+	msg := c.selectedMessage()
+	if msg == nil {
+		return
+	}
+	links := msg.Links()
+	li := NewLinksInput(c)
+	li.SetLinks(links, linkTitles(msg))
+	switch len(links) {
+	case 0:
+		c.SetStatus(c.theme.LinkGlyph + "<NO MATCHES>")
+	case 1:
+		li.OpenLink(links[0])
+	default:
+		c.mode = LinkMode
+		c.HideConversation(li)
+		c.app.SetFocus(li)
+	}
+}
+
+// ReplySelected starts a reply to the selected message
+func (c *ChatWindow) ReplySelected() {
+	msg := c.selectedMessage()
+	if msg == nil {
+		return
+	}
+	if msg.IsDeleted {
+		c.SetErrorStatus(fmt.Errorf("can't reply to a deleted message"))
+		return
+	}
+	conv, err := c.currentConversation()
+	if err != nil {
+		c.SetErrorStatus(err)
+		return
+	}
+	conv.StageReply(msg)
+	c.conversationPanel.ClearSelection()
+	c.sendPanel.Update()
+	c.InsertMode()
+}
+
+// ReactSelected asks for an emoji to react to the selected message with
+func (c *ChatWindow) ReactSelected() {
+	msg := c.selectedMessage()
+	if msg == nil {
+		return
+	}
+	contact := c.currentContact
+	p := NewPromptInput(c, "react (:thumbsup:, empty to remove): ", func(answer string) {
+		go func() {
+			err := c.siggo.React(contact, msg, emoji.Sprint(strings.TrimSpace(answer)))
+			c.app.QueueUpdateDraw(func() {
+				if err != nil {
+					c.SetErrorStatus(fmt.Errorf("failed to react: %v", err))
+				}
+			})
+		}()
+	})
+	c.showPrompt(p)
+}
+
+// DeleteSelected deletes the selected message after asking first. Our own messages are deleted for
+// everyone.
+func (c *ChatWindow) DeleteSelected() {
+	msg := c.selectedMessage()
+	if msg == nil {
+		return
+	}
+	question := "delete for me? (y/n): "
+	if msg.FromSelf && !msg.IsPending && !msg.IsFailed {
+		question = "delete for everyone? (y/n): "
+	}
+	contact := c.currentContact
+	p := NewPromptInput(c, question, func(answer string) {
+		if answer != "y" {
+			return
+		}
+		go func() {
+			err := c.siggo.Delete(contact, msg)
+			c.app.QueueUpdateDraw(func() {
+				if err != nil {
+					c.SetErrorStatus(fmt.Errorf("failed to delete: %v", err))
+				}
+			})
+		}()
+	})
+	c.showPrompt(p)
+}
+
+// ShowDetails shows everything we know about the selected message
+func (c *ChatWindow) ShowDetails() {
+	msg := c.selectedMessage()
+	if msg == nil {
+		return
+	}
+	d := NewMessageDetails(c, msg)
+	c.HideConversation(d)
+	c.app.SetFocus(d)
+}
+
+// ToggleSpoilers reveals or hides the spoilers of the selected message
+func (c *ChatWindow) ToggleSpoilers() {
+	msg := c.selectedMessage()
+	if msg == nil {
+		return
+	}
+	c.conversationPanel.ToggleSpoilers(msg.Key())
+}
+
+// showPrompt shows a prompt below everything else
+func (c *ChatWindow) showPrompt(p *CommandInput) {
+	c.showBottom(p, 1)
+	c.app.SetFocus(p)
+}
+
+// NewPromptInput is a command input that asks a question and calls `answered` with the answer,
+// unless we hit ESC
+func NewPromptInput(parent *ChatWindow, question string, answered func(string)) *CommandInput {
+	ci := &CommandInput{
+		InputField: tview.NewInputField(),
+		parent:     parent,
+	}
+	ci.SetLabel(question)
+	ci.SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor)
+	ci.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		log.Debugf("Key Event <PROMPT>: %v mods: %v rune: %v", event.Key(), event.Modifiers(), event.Rune())
+		switch event.Key() {
+		case tcell.KeyESC:
+			ci.parent.HideCommandInput()
+			return nil
+		case tcell.KeyEnter:
+			answer := ci.GetText()
+			ci.parent.HideCommandInput()
+			answered(answer)
+			return nil
+		}
+		return event
+	})
+	return ci
+}
+
+// MessageDetails shows the details of a message in place of the conversation
+type MessageDetails struct {
+	*tview.TextView
+	parent *ChatWindow
+}
+
+// Close goes back to the conversation
+func (md *MessageDetails) Close() {
+	md.parent.Grid.RemoveItem(md)
+	md.parent.ShowConversation()
+	md.parent.FocusMe()
+}
+
+// contactName returns the name we have for a number
+func (c *ChatWindow) contactName(number model.PhoneNumber) string {
+	if number == c.siggo.Config().UserNumber {
+		return "~"
+	}
+	if contact, ok := c.siggo.Contacts()[number]; ok {
+		return contact.String()
+	}
+	return number
+}
+
+// NewMessageDetails creates a view of the details of `msg`
+func NewMessageDetails(parent *ChatWindow, msg *model.Message) *MessageDetails {
+	md := &MessageDetails{
+		TextView: tview.NewTextView(),
+		parent:   parent,
+	}
+	var b strings.Builder
+	ts := time.Unix(0, msg.Timestamp*int64(time.Millisecond))
+	fmt.Fprintf(&b, "from:      %s <%s>\n", parent.contactName(msg.Author), msg.Author)
+	fmt.Fprintf(&b, "sent:      %s\n", ts.Format("Mon 2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "timestamp: %d\n", msg.Timestamp)
+	if msg.FromSelf {
+		status := "sent"
+		switch {
+		case msg.IsPending:
+			status = "sending"
+		case msg.IsFailed:
+			status = "failed"
+		case msg.IsRead:
+			status = "read"
+		case msg.IsDelivered:
+			status = "delivered"
+		}
+		fmt.Fprintf(&b, "status:    %s\n", status)
+	}
+	if msg.IsDeleted {
+		fmt.Fprintf(&b, "deleted\n")
+	}
+	if msg.Quote != nil {
+		fmt.Fprintf(&b, "reply to:  %s: %s\n", parent.contactName(msg.Quote.Author), msg.Quote)
+	}
+	if len(msg.Reactions) > 0 {
+		authors := make([]string, 0, len(msg.Reactions))
+		for author := range msg.Reactions {
+			authors = append(authors, author)
+		}
+		sort.Strings(authors)
+		fmt.Fprintf(&b, "reactions:\n")
+		for _, author := range authors {
+			fmt.Fprintf(&b, "  %s %s\n", msg.Reactions[author], parent.contactName(author))
+		}
+	}
+	if len(msg.Attachments) > 0 {
+		fmt.Fprintf(&b, "attachments:\n")
+		for _, a := range msg.Attachments {
+			path, err := a.Path()
+			if err != nil {
+				path = fmt.Sprintf("<%v>", err)
+			}
+			fmt.Fprintf(&b, "  %s (%s)\n    %s\n", a.DisplayName(), a.ContentType, path)
+		}
+	}
+	if text := msg.MaskedText(parent.theme.SpoilerGlyph); text != "" {
+		fmt.Fprintf(&b, "\n%s\n", text)
+	}
+	md.SetText(b.String())
+	md.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		log.Debugf("Key Event <DETAILS>: %v mods: %v rune: %v", event.Key(), event.Modifiers(), event.Rune())
+		switch event.Key() {
+		case tcell.KeyESC, tcell.KeyEnter:
+			md.Close()
+			return nil
+		case tcell.KeyRune:
+			switch event.Rune() {
+			case 113: // q
+				md.Close()
+				return nil
+			}
+		}
+		return event
+	})
+	md.SetBorder(true)
+	md.SetTitle("message details")
+	md.SetTitleAlign(0)
+	return md
+}
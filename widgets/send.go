@@ -23,7 +23,6 @@ func (s *SendPanel) Send() {
 	}
 	msg := s.GetText()
 	contact := s.parent.currentContact
-	s.parent.ShowTempSentMsg(msg)
 	go s.siggo.Send(msg, contact)
 	log.Infof("sent message: %s to contact: %s", msg, contact)
 	s.SetText("")
@@ -37,6 +36,7 @@ func (s *SendPanel) Clear() {
 		return
 	}
 	conv.ClearAttachments()
+	conv.StageReply(nil)
 	s.Update()
 }
 
@@ -62,12 +62,14 @@ func (s *SendPanel) Update() {
 	if err != nil {
 		return
 	}
-	nAttachments := conv.NumAttachments()
-	if nAttachments > 0 {
-		s.SetLabel(fmt.Sprintf("📎(%d) ", nAttachments))
-	} else {
-		s.SetLabel("")
+	label := ""
+	if reply := conv.StagedReply(); reply != nil {
+		label = fmt.Sprintf("%s “%s” ", s.parent.theme.ReplyGlyph, tview.Escape((&model.Quote{Text: reply.Text()}).String()))
 	}
+	if nAttachments := conv.NumAttachments(); nAttachments > 0 {
+		label += fmt.Sprintf("%s(%d) ", s.parent.theme.AttachmentGlyph, nAttachments)
+	}
+	s.SetLabel(label)
 	if conv.StagedMessage != "" {
 		s.SetText(conv.StagedMessage)
 	}